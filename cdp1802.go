@@ -0,0 +1,271 @@
+package main
+
+// RCA CDP1802 CPU of COSMAC VIP. Memory and I/O are reached through bus
+type CDP1802Bus interface {
+	read(addr uint16) uint8
+	write(addr uint16, val uint8)
+	out(port uint8, val uint8) // OUT 1-7
+	in(port uint8) uint8       // INP 1-7
+	ef(n uint8) bool           // External flags EF1-EF4
+	set_q(on bool)             // Q output changed
+}
+
+type CDP1802 struct {
+	r    [16]uint16 // Scratchpad registers R0-RF
+	d    uint8      // Accumulator
+	df   uint8      // Carry flag
+	p, x uint8      // Numbers of program counter and data pointer registers
+	t    uint8      // X and P saved by interrupt or MARK
+	ie   bool       // Interrupts enabled
+	q    bool       // Q output flip-flop
+	idle bool       // Stopped by IDL till interrupt or DMA
+	bus  CDP1802Bus
+}
+
+func (cpu *CDP1802) reset() {
+	cpu.x = 0
+	cpu.p = 0
+	cpu.r[0] = 0
+	cpu.ie = true
+	cpu.idle = false
+	cpu.set_q(false)
+}
+
+func (cpu *CDP1802) set_q(on bool) {
+	cpu.q = on
+	cpu.bus.set_q(on)
+}
+
+// Interrupt request. Ignored when interrupts are disabled
+func (cpu *CDP1802) interrupt() {
+	if !cpu.ie {
+		return
+	}
+	cpu.t = cpu.x<<4 | cpu.p
+	cpu.p = 1
+	cpu.x = 2
+	cpu.ie = false
+	cpu.idle = false
+}
+
+// DMA output cycle: device reads byte at R0
+func (cpu *CDP1802) dma_out() uint8 {
+	val := cpu.bus.read(cpu.r[0])
+	cpu.r[0]++
+	cpu.idle = false
+	return val
+}
+
+func (cpu *CDP1802) fetch() uint8 {
+	val := cpu.bus.read(cpu.r[cpu.p])
+	cpu.r[cpu.p]++
+	return val
+}
+
+// Condition of branches: 0 - always, 1 - Q, 2 - D is zero, 3 - DF, 4-7 - EF1-EF4
+func (cpu *CDP1802) condition(n uint8) bool {
+	switch n & 7 {
+	case 0:
+		return true
+	case 1:
+		return cpu.q
+	case 2:
+		return cpu.d == 0
+	case 3:
+		return cpu.df == 1
+	}
+	return cpu.bus.ef(n&7 - 3)
+}
+
+// D, DF = a + b + carry. Subtraction is addition of complement
+func (cpu *CDP1802) add(a, b, carry uint8) {
+	sum := uint16(a) + uint16(b) + uint16(carry)
+	cpu.d = uint8(sum)
+	cpu.df = uint8(sum >> 8)
+}
+
+// Executes one instruction, returns machine cycles it took
+func (cpu *CDP1802) step() int {
+	if cpu.idle {
+		return 2
+	}
+	op := cpu.fetch()
+	n := op & 0xF
+	switch op >> 4 {
+	case 0x0:
+		if n == 0 { // IDL
+			cpu.idle = true
+		} else { // LDN
+			cpu.d = cpu.bus.read(cpu.r[n])
+		}
+	case 0x1: // INC
+		cpu.r[n]++
+	case 0x2: // DEC
+		cpu.r[n]--
+	case 0x3: // Short branches. 38 (SKP) never branches and skips address byte
+		cond := cpu.condition(n)
+		if n&8 != 0 {
+			cond = !cond
+		}
+		if cond {
+			cpu.r[cpu.p] = cpu.r[cpu.p]&0xFF00 | uint16(cpu.bus.read(cpu.r[cpu.p]))
+		} else {
+			cpu.r[cpu.p]++
+		}
+	case 0x4: // LDA
+		cpu.d = cpu.bus.read(cpu.r[n])
+		cpu.r[n]++
+	case 0x5: // STR
+		cpu.bus.write(cpu.r[n], cpu.d)
+	case 0x6:
+		switch {
+		case n == 0: // IRX
+			cpu.r[cpu.x]++
+		case n < 8: // OUT
+			cpu.bus.out(n, cpu.bus.read(cpu.r[cpu.x]))
+			cpu.r[cpu.x]++
+		case n == 8: // Extended instructions of 1804, nothing on 1802
+		default: // INP
+			val := cpu.bus.in(n - 8)
+			cpu.bus.write(cpu.r[cpu.x], val)
+			cpu.d = val
+		}
+	case 0x7:
+		cpu.step_7N(n)
+	case 0x8: // GLO
+		cpu.d = uint8(cpu.r[n])
+	case 0x9: // GHI
+		cpu.d = uint8(cpu.r[n] >> 8)
+	case 0xA: // PLO
+		cpu.r[n] = cpu.r[n]&0xFF00 | uint16(cpu.d)
+	case 0xB: // PHI
+		cpu.r[n] = cpu.r[n]&0x00FF | uint16(cpu.d)<<8
+	case 0xC:
+		cpu.step_CN(n)
+		return 3
+	case 0xD: // SEP
+		cpu.p = n
+	case 0xE: // SEX
+		cpu.x = n
+	case 0xF:
+		cpu.step_FN(n)
+	}
+	return 2
+}
+
+func (cpu *CDP1802) step_7N(n uint8) {
+	switch n {
+	case 0x0, 0x1: // RET, DIS
+		val := cpu.bus.read(cpu.r[cpu.x])
+		cpu.r[cpu.x]++
+		cpu.x = val >> 4
+		cpu.p = val & 0xF
+		cpu.ie = n == 0x0
+	case 0x2: // LDXA
+		cpu.d = cpu.bus.read(cpu.r[cpu.x])
+		cpu.r[cpu.x]++
+	case 0x3: // STXD
+		cpu.bus.write(cpu.r[cpu.x], cpu.d)
+		cpu.r[cpu.x]--
+	case 0x4: // ADC
+		cpu.add(cpu.bus.read(cpu.r[cpu.x]), cpu.d, cpu.df)
+	case 0x5: // SDB
+		cpu.add(cpu.bus.read(cpu.r[cpu.x]), ^cpu.d, cpu.df)
+	case 0x6: // SHRC
+		df := cpu.d & 1
+		cpu.d = cpu.d>>1 | cpu.df<<7
+		cpu.df = df
+	case 0x7: // SMB
+		cpu.add(cpu.d, ^cpu.bus.read(cpu.r[cpu.x]), cpu.df)
+	case 0x8: // SAV
+		cpu.bus.write(cpu.r[cpu.x], cpu.t)
+	case 0x9: // MARK
+		cpu.t = cpu.x<<4 | cpu.p
+		cpu.bus.write(cpu.r[2], cpu.t)
+		cpu.x = cpu.p
+		cpu.r[2]--
+	case 0xA: // REQ
+		cpu.set_q(false)
+	case 0xB: // SEQ
+		cpu.set_q(true)
+	case 0xC: // ADCI
+		cpu.add(cpu.fetch(), cpu.d, cpu.df)
+	case 0xD: // SDBI
+		cpu.add(cpu.fetch(), ^cpu.d, cpu.df)
+	case 0xE: // SHLC
+		df := cpu.d >> 7
+		cpu.d = cpu.d<<1 | cpu.df
+		cpu.df = df
+	case 0xF: // SMBI
+		cpu.add(cpu.d, ^cpu.fetch(), cpu.df)
+	}
+}
+
+// Long branches (C0-C3, C8-CB) and long skips (C4-C7, CC-CF)
+func (cpu *CDP1802) step_CN(n uint8) {
+	if n&4 == 0 {
+		cond := cpu.condition(n & 3)
+		if n&8 != 0 {
+			cond = !cond
+		}
+		if cond {
+			hi := cpu.bus.read(cpu.r[cpu.p])
+			lo := cpu.bus.read(cpu.r[cpu.p] + 1)
+			cpu.r[cpu.p] = uint16(hi)<<8 | uint16(lo)
+		} else {
+			cpu.r[cpu.p] += 2
+		}
+		return
+	}
+	var skip bool
+	switch n {
+	case 0x4: // NOP
+		skip = false
+	case 0xC: // LSIE
+		skip = cpu.ie
+	case 0x5, 0x6, 0x7: // LSNQ, LSNZ, LSNF
+		skip = !cpu.condition(n & 3)
+	default: // LSQ, LSZ, LSDF
+		skip = cpu.condition(n & 3)
+	}
+	if skip {
+		cpu.r[cpu.p] += 2
+	}
+}
+
+func (cpu *CDP1802) step_FN(n uint8) {
+	var m uint8 // Operand: M(R(X)), or immediate byte for F8-FF
+	switch n {
+	case 0x6, 0xE: // SHR, SHL have no operand
+	default:
+		if n < 8 {
+			m = cpu.bus.read(cpu.r[cpu.x])
+		} else {
+			m = cpu.fetch()
+		}
+	}
+	switch n & 7 {
+	case 0x0: // LDX, LDI
+		cpu.d = m
+	case 0x1: // OR, ORI
+		cpu.d |= m
+	case 0x2: // AND, ANI
+		cpu.d &= m
+	case 0x3: // XOR, XRI
+		cpu.d ^= m
+	case 0x4: // ADD, ADI
+		cpu.add(m, cpu.d, 0)
+	case 0x5: // SD, SDI
+		cpu.add(m, ^cpu.d, 1)
+	case 0x6:
+		if n == 0x6 { // SHR
+			cpu.df = cpu.d & 1
+			cpu.d >>= 1
+		} else { // SHL
+			cpu.df = cpu.d >> 7
+			cpu.d <<= 1
+		}
+	case 0x7: // SM, SMI
+		cpu.add(cpu.d, ^m, 1)
+	}
+}
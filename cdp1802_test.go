@@ -0,0 +1,215 @@
+package main
+
+import "testing"
+
+// Loads 1802 program at addr of fake console memory
+func load_1802(console *CHIP8Console, addr uint32, program ...uint8) {
+	for i, val := range program {
+		console.mem.write(addr+uint32(i), val)
+	}
+}
+
+type cdp1802_case struct {
+	name    string
+	program []uint8 // Runs from 0x000 after reset till IDL
+	check   func(t *testing.T, cpu *CDP1802)
+}
+
+func expect_d(t *testing.T, cpu *CDP1802, d, df uint8) {
+	if cpu.d != d || cpu.df != df {
+		t.Errorf("D = 0x%02X, DF = %d, want 0x%02X, %d", cpu.d, cpu.df, d, df)
+	}
+}
+
+func expect_r(t *testing.T, cpu *CDP1802, n int, val uint16) {
+	if cpu.r[n] != val {
+		t.Errorf("R%X = 0x%04X, want 0x%04X", n, cpu.r[n], val)
+	}
+}
+
+var cdp1802_cases = []cdp1802_case{
+	{"LDI", []uint8{0xF8, 0x5A, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x5A, 0)
+	}},
+	{"PLO PHI GLO GHI", []uint8{0xF8, 0x34, 0xA5, 0xF8, 0x12, 0xB5, 0x85, 0xB6, 0x95, 0xA6, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_r(t, cpu, 5, 0x1234)
+		expect_r(t, cpu, 6, 0x3412)
+	}},
+	{"INC DEC", []uint8{0x17, 0x17, 0x28, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_r(t, cpu, 7, 2)
+		expect_r(t, cpu, 8, 0xFFFF)
+	}},
+	{"ADI carry", []uint8{0xF8, 0xF0, 0xFC, 0x20, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x10, 1)
+	}},
+	{"ADCI uses DF", []uint8{0xF8, 0xFF, 0xFC, 0x01, 0x7C, 0x01, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x02, 0)
+	}},
+	{"SMI no borrow", []uint8{0xF8, 0x10, 0xFF, 0x01, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x0F, 1)
+	}},
+	{"SMI borrow", []uint8{0xF8, 0x01, 0xFF, 0x02, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0xFF, 0)
+	}},
+	{"SDI", []uint8{0xF8, 0x01, 0xFD, 0x03, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x02, 1)
+	}},
+	{"SMBI borrow in", []uint8{0xF8, 0x00, 0xFF, 0x01, 0xF8, 0x05, 0x7F, 0x01, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x03, 1)
+	}},
+	{"ANI ORI XRI", []uint8{0xF8, 0xF0, 0xFA, 0x3C, 0xF9, 0x01, 0xFB, 0xFF, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0xCE, 0)
+	}},
+	{"SHR SHL", []uint8{0xF8, 0x81, 0xF6, 0xFE, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x80, 0)
+	}},
+	{"SHRC SHLC", []uint8{0xF8, 0x01, 0x76, 0x7E, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x01, 0)
+	}},
+	{"STR LDN LDA", []uint8{0xF8, 0x40, 0xA3, 0xF8, 0x77, 0x53, 0xF8, 0x00, 0x03, 0x43, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x77, 0)
+		expect_r(t, cpu, 3, 0x41)
+	}},
+	{"STXD LDXA", []uint8{0xF8, 0x40, 0xA2, 0xE2, 0xF8, 0x99, 0x73, 0x60, 0xF8, 0x00, 0x72, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x99, 0)
+		expect_r(t, cpu, 2, 0x41)
+	}},
+	{"BZ taken", []uint8{0xF8, 0x00, 0x32, 0x06, 0xF8, 0x11, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x00, 0)
+	}},
+	{"BNZ not taken", []uint8{0xF8, 0x00, 0x3A, 0x06, 0xF8, 0x11, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x11, 0)
+	}},
+	{"SKP", []uint8{0x38, 0xF8, 0xF8, 0x22, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x22, 0)
+	}},
+	{"LBR", []uint8{0xC0, 0x00, 0x06, 0xF8, 0x11, 0x00, 0xF8, 0x22, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x22, 0)
+	}},
+	{"LSZ", []uint8{0xF8, 0x00, 0xCE, 0xF8, 0x11, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x00, 0)
+	}},
+	{"SEQ LBQ", []uint8{0x7B, 0xC1, 0x00, 0x07, 0xF8, 0x11, 0x00, 0xF8, 0x22, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		expect_d(t, cpu, 0x22, 0)
+		if !cpu.q {
+			t.Errorf("Q is not set")
+		}
+	}},
+	{"SEP SEX", []uint8{0xF8, 0x07, 0xA3, 0xD3, 0x00, 0x00, 0x00, 0xE5, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		if cpu.p != 3 || cpu.x != 5 {
+			t.Errorf("P = %X, X = %X, want 3, 5", cpu.p, cpu.x)
+		}
+	}},
+	{"MARK RET", []uint8{0xF8, 0x40, 0xA2, 0x71, 0x00, 0xE5, 0x79, 0x12, 0xE2, 0x70, 0x00}, func(t *testing.T, cpu *CDP1802) {
+		if cpu.t != 0x50 || cpu.p != 0 || cpu.x != 5 || !cpu.ie {
+			t.Errorf("T = %02X, P = %X, X = %X, IE = %v, want 50, 0, 5, true", cpu.t, cpu.p, cpu.x, cpu.ie)
+		}
+	}},
+}
+
+func TestCDP1802Instructions(t *testing.T) {
+	for _, c := range cdp1802_cases {
+		t.Run(c.name, func(t *testing.T) {
+			console, _, _, _ := new_fake_console()
+			vip := console.get_vip()
+			load_1802(console, 0, c.program...)
+			vip.boot()
+			for i := 0; i < 100 && !vip.cpu.idle; i++ {
+				vip.cpu.step()
+			}
+			if !vip.cpu.idle {
+				t.Fatalf("Program doesn't reach IDL")
+			}
+			c.check(t, &vip.cpu)
+		})
+	}
+}
+
+func TestCDP1802Interrupt(t *testing.T) {
+	console, _, _, _ := new_fake_console()
+	vip := console.get_vip()
+	vip.boot()
+	vip.cpu.p, vip.cpu.x = 3, 4
+	vip.cpu.interrupt()
+	if vip.cpu.p != 1 || vip.cpu.x != 2 || vip.cpu.t != 0x43 || vip.cpu.ie {
+		t.Errorf("P = %X, X = %X, T = %02X, IE = %v, want 1, 2, 43, false", vip.cpu.p, vip.cpu.x, vip.cpu.t, vip.cpu.ie)
+	}
+	vip.cpu.p = 3
+	vip.cpu.interrupt()
+	if vip.cpu.p != 3 {
+		t.Errorf("Interrupt is taken with IE reset")
+	}
+}
+
+// 0NNN routine sees VX through R6, I in RA and display at RB, and returns with SEP R4
+func TestHybridCall(t *testing.T) {
+	console, cpu, gpu, _ := new_fake_console()
+	cpu.set_platform(PlatformHybridVIP)
+	load_1802(console, 0x300,
+		0x06, 0xFC, 0x01, 0x56, // LDN R6, ADI 01, STR R6: VX++
+		0x8A, 0xFC, 0x02, 0xAA, // GLO RA, ADI 02, PLO RA: I += 2
+		0x9B, 0xBF, 0x8B, 0xAF, // RF = RB
+		0xF8, 0xFF, 0x5F, // LDI FF, STR RF: top left byte of display
+		0xD4) // SEP R4
+	load_1802(console, 0x200, 0x03, 0x00) // SYS 300 with X = 3
+	cpu.v[3] = 0x41
+	cpu.i = 0x250
+	gpu.pic[10][5] = 1
+	console.tick()
+	expect_v(t, cpu, 3, 0x42)
+	expect_i(t, cpu, 0x252)
+	expect_pc(t, cpu, 0x202)
+	for x := 0; x < 8; x++ {
+		if gpu.pixel(x, 0) != 1 {
+			t.Errorf("Pixel (%d, 0) is not set by routine", x)
+		}
+	}
+	if gpu.pixel(10, 5) != 1 {
+		t.Errorf("Pixel (10, 5) is lost by routine call")
+	}
+}
+
+func TestHybridCallNotOnCHIP8(t *testing.T) {
+	console, cpu, _, _ := new_fake_console()
+	quiet(t)
+	load_1802(console, 0x300, 0xF8, 0x00, 0xA6, 0x56, 0xD4)
+	load_1802(console, 0x200, 0x03, 0x00)
+	cpu.v[3] = 0x41
+	console.tick()
+	expect_v(t, cpu, 3, 0x41)
+	if console.vip != nil {
+		t.Errorf("CDP1802 is created on CHIP-8 platform")
+	}
+}
+
+// Interpreter image turns display on, its interrupt routine points R0 to
+// screen data and CDP1861 DMA draws it
+func TestInterpreterBootFrame(t *testing.T) {
+	console, _, gpu, _ := new_fake_console()
+	vip := console.get_vip()
+	load_1802(console, 0,
+		0xF8, 0x00, 0xB1, 0xF8, 0x40, 0xA1, // R1 = 0040: interrupt routine
+		0xF8, 0x30, 0xA2, 0xE2, // R2 = 0030, X = 2
+		0x69,       // INP 1: display on
+		0x00,       // IDL
+		0x30, 0x0B) // BR 0B
+	load_1802(console, 0x40,
+		0xF8, 0x04, 0xB0, 0xF8, 0x00, 0xA0, // R0 = 0400: screen data
+		0x30, 0x46) // BR 46
+	for i := 0; i < 0x400; i++ {
+		console.mem.write(uint32(0x400+i), 0xAA)
+	}
+	vip.boot()
+	vip.run_frame()
+	if !vip.display_on {
+		t.Fatalf("Display is not turned on")
+	}
+	w, h := gpu.size()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if want := uint8(1 - x%2); gpu.pixel(x, y) != want {
+				t.Fatalf("Pixel (%d, %d) = %d, want %d", x, y, gpu.pixel(x, y), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Cheat search: memory is snapshotted, then addresses are narrowed down to
+// ones whose values change the way asked between snapshots. Five lives that
+// become four are found with value 5, decreased, value 4
+type CHIP8CheatSearch struct {
+	snapshot   []uint8  // Memory at last step
+	candidates []uint32 // Addresses that matched every step, nil before start
+}
+
+// Comparisons of search with previous snapshot, or with value
+var cheat_searches = map[string]func(old, now, value uint8) bool{
+	"equal":     func(old, now, value uint8) bool { return now == old },
+	"changed":   func(old, now, value uint8) bool { return now != old },
+	"increased": func(old, now, value uint8) bool { return now > old },
+	"decreased": func(old, now, value uint8) bool { return now < old },
+	"value":     func(old, now, value uint8) bool { return now == value },
+}
+
+func memory_snapshot(mem CHIP8Memory_i) []uint8 {
+	state := &CHIP8State{}
+	mem.save_state(state)
+	return state.Memory
+}
+
+// Starts search over: every address is candidate
+func (search *CHIP8CheatSearch) start(mem CHIP8Memory_i) {
+	search.snapshot = memory_snapshot(mem)
+	search.candidates = make([]uint32, len(search.snapshot))
+	for i := range search.candidates {
+		search.candidates[i] = uint32(i)
+	}
+}
+
+// Keeps candidates that pass comparison of kind, then snapshots memory for next step
+func (search *CHIP8CheatSearch) filter(mem CHIP8Memory_i, kind string, value uint8) error {
+	compare, ok := cheat_searches[kind]
+	if !ok {
+		return fmt.Errorf("unknown search %q, equal, changed, increased, decreased or value", kind)
+	}
+	if search.candidates == nil {
+		return fmt.Errorf("search isn't started")
+	}
+	now := memory_snapshot(mem)
+	if len(now) != len(search.snapshot) {
+		return fmt.Errorf("memory has changed size, search is to be started again")
+	}
+	kept := search.candidates[:0]
+	for _, addr := range search.candidates {
+		if compare(search.snapshot[addr], now[addr], value) {
+			kept = append(kept, addr)
+		}
+	}
+	search.candidates = kept
+	search.snapshot = now
+	return nil
+}
+
+// Value written to address every frame
+type CHIP8Cheat struct {
+	Name    string `toml:"name" json:"name"`
+	Addr    uint32 `toml:"addr" json:"addr"`
+	Value   uint8  `toml:"value" json:"value"`
+	Enabled bool   `toml:"enabled" json:"enabled"`
+}
+
+// Cheats of one ROM, saved in cheats/<SHA-1 of ROM>.cht of user config directory:
+//
+//	rom = "<SHA-1>"
+//	[[cheat]]
+//	name = "Lives"
+//	addr = 0x2F4
+//	value = 9
+//	enabled = true
+type CHIP8Cheats struct {
+	ROM    string       `toml:"rom"`
+	Cheats []CHIP8Cheat `toml:"cheat"`
+}
+
+// Path of cheats file of ROM. Empty if user config directory can't be found
+func cheats_path(hash string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "chipigo", "cheats", hash+".cht")
+}
+
+// Reads cheats of ROM, missing file is no cheats
+func read_cheats(path, hash string) (*CHIP8Cheats, error) {
+	cheats := &CHIP8Cheats{ROM: hash}
+	if path == "" {
+		return cheats, nil
+	}
+	if _, err := toml.DecodeFile(path, cheats); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if cheats.ROM != hash {
+		return nil, fmt.Errorf("%s: cheats are for other ROM", path)
+	}
+	return cheats, nil
+}
+
+func write_cheats(path string, cheats *CHIP8Cheats) error {
+	if path == "" {
+		return fmt.Errorf("user config directory isn't found")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = toml.NewEncoder(file).Encode(cheats); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Freezes address to value, replacing cheat of the same address
+func (cheats *CHIP8Cheats) freeze(addr uint32, value uint8, name string) {
+	for i := range cheats.Cheats {
+		if cheats.Cheats[i].Addr == addr {
+			cheats.Cheats[i] = CHIP8Cheat{Name: name, Addr: addr, Value: value, Enabled: true}
+			return
+		}
+	}
+	cheats.Cheats = append(cheats.Cheats, CHIP8Cheat{Name: name, Addr: addr, Value: value, Enabled: true})
+}
+
+// Removes cheat of address. False if there is none
+func (cheats *CHIP8Cheats) unfreeze(addr uint32) bool {
+	for i, cheat := range cheats.Cheats {
+		if cheat.Addr == addr {
+			cheats.Cheats = append(cheats.Cheats[:i], cheats.Cheats[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Writes values of enabled cheats. Bytes that hold them already aren't
+// written, so write hooks of scripts see only real changes
+func (cheats *CHIP8Cheats) apply(mem CHIP8Memory_i) {
+	for _, cheat := range cheats.Cheats {
+		if cheat.Enabled && mem.read(cheat.Addr) != cheat.Value {
+			mem.write(cheat.Addr, cheat.Value)
+		}
+	}
+}
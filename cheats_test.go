@@ -0,0 +1,128 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Decrements byte at 300 every frame of 5 instructions
+var lives_rom = []uint8{
+	0xA3, 0x00, // LD I, 300
+	0xF0, 0x65, // LD V0, [I]
+	0x70, 0xFF, // ADD V0, FF
+	0xF0, 0x55, // LD [I], V0
+	0x12, 0x00, // JP 200
+}
+
+func new_lives_console(t *testing.T) *CHIP8Console {
+	console := new_rom_console(t, lives_rom, "")
+	console.settings.cycles_per_frame = 5
+	console.mem.write(0x300, 100)
+	return console
+}
+
+func TestCheatSearch(t *testing.T) {
+	console := new_lives_console(t)
+	search := &CHIP8CheatSearch{}
+	if err := search.filter(console.mem, "changed", 0); err == nil {
+		t.Errorf("search isn't started, but filters")
+	}
+	search.start(console.mem)
+	if len(search.candidates) != 0x1000 {
+		t.Fatalf("%d candidates at start", len(search.candidates))
+	}
+	console.emulate_frame()
+	search.filter(console.mem, "decreased", 0)
+	if len(search.candidates) != 1 || search.candidates[0] != 0x300 {
+		t.Errorf("candidates %v after decrease", search.candidates)
+	}
+	search.filter(console.mem, "equal", 0) // No frame, nothing changes
+	console.emulate_frame()
+	search.filter(console.mem, "value", 98)
+	if len(search.candidates) != 1 {
+		t.Errorf("candidates %v of value 98", search.candidates)
+	}
+	search.filter(console.mem, "increased", 0)
+	if len(search.candidates) != 0 {
+		t.Errorf("candidates %v after nothing has increased", search.candidates)
+	}
+	if err := search.filter(console.mem, "bigger", 0); err == nil {
+		t.Errorf("unknown search is done")
+	}
+}
+
+func TestCheatFreeze(t *testing.T) {
+	console := new_lives_console(t)
+	console.cheats = &CHIP8Cheats{ROM: console.rom_hash}
+	console.cheats.freeze(0x300, 50, "Lives")
+	console.cheats.freeze(0x300, 9, "Lives") // Replaces
+	for i := 0; i < 3; i++ {
+		console.emulate_frame()
+		if val := console.mem.read(0x300); val != 9 {
+			t.Fatalf("frozen byte is %d after frame %d", val, i)
+		}
+	}
+	if !console.cheats.unfreeze(0x300) || console.cheats.unfreeze(0x300) {
+		t.Errorf("cheat isn't removed once")
+	}
+	console.emulate_frame()
+	if val := console.mem.read(0x300); val != 8 {
+		t.Errorf("unfrozen byte is %d", val)
+	}
+}
+
+func TestCheatsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cheats", "abc.cht")
+	cheats, err := read_cheats(path, "abc")
+	if err != nil || len(cheats.Cheats) != 0 {
+		t.Fatalf("missing file is %v, %v", cheats, err)
+	}
+	cheats.freeze(0x2F4, 9, "Lives")
+	cheats.Cheats = append(cheats.Cheats, CHIP8Cheat{Name: "Off", Addr: 0x10, Value: 1})
+	if err = write_cheats(path, cheats); err != nil {
+		t.Fatal(err)
+	}
+	read, err := read_cheats(path, "abc")
+	if err != nil || len(read.Cheats) != 2 || read.Cheats[0] != cheats.Cheats[0] || read.Cheats[1].Enabled {
+		t.Errorf("read cheats are %+v, %v", read, err)
+	}
+	if _, err = read_cheats(path, "def"); err == nil {
+		t.Errorf("cheats of other ROM are read")
+	}
+}
+
+func TestControlCheats(t *testing.T) {
+	console := new_lives_console(t)
+	path := filepath.Join(t.TempDir(), "lives.cht")
+	lines := run_control(t, console, `{"cmd": "search", "op": "start"}
+{"cmd": "frames"}
+{"cmd": "search", "op": "decreased"}
+{"cmd": "freeze", "addr": 768, "value": 7, "name": "Lives"}
+{"cmd": "frames", "count": 2}
+{"cmd": "cheats"}
+{"cmd": "save_cheats", "path": "`+path+`"}
+{"cmd": "unfreeze", "addr": 1}
+{"cmd": "search", "op": "value", "value": 300}
+`)
+	if len(lines) != 9 {
+		t.Fatalf("answers are %v", lines)
+	}
+	if lines[0]["count"] != 4096.0 || len(lines[0]["addrs"].([]interface{})) != control_addrs_max {
+		t.Errorf("start of search is %v", lines[0])
+	}
+	if lines[2]["count"] != 1.0 || lines[2]["addrs"].([]interface{})[0] != 768.0 {
+		t.Errorf("search is %v", lines[2])
+	}
+	if val := console.mem.read(0x300); val != 7 {
+		t.Errorf("frozen byte is %d", val)
+	}
+	if cheats := lines[5]["cheats"].([]interface{}); len(cheats) != 1 {
+		t.Errorf("cheats are %v", cheats)
+	}
+	if cheats, err := read_cheats(path, console.rom_hash); err != nil || len(cheats.Cheats) != 1 {
+		t.Errorf("saved cheats are %v, %v", cheats, err)
+	}
+	if lines[7]["ok"] != false || lines[8]["ok"] != false {
+		t.Errorf("bad requests are answered %v and %v", lines[7], lines[8])
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Source syntax is the one printed by disassembler: one instruction per line,
+// labels end with ':' and comments start with ';'. Numbers are decimal, or hex
+// with 0x, # or $ prefix. DB and DW directives emit raw bytes and words.
+
+type asm_line struct {
+	num      int      // Line number in source, for error messages
+	mnemonic string   // Upper case mnemonic or directive
+	args     []string // Operands as written in source
+}
+
+func asm_rom(src_path, rom_path string, platform Platform) error {
+	src, err := ioutil.ReadFile(src_path)
+	if err != nil {
+		return err
+	}
+	rom, err := assemble(string(src), platform, platform_spec(platform).load)
+	if err != nil {
+		return fmt.Errorf("%s: %s", src_path, err.Error())
+	}
+	return ioutil.WriteFile(rom_path, rom, 0644)
+}
+
+// Assembles source into binary image that will be loaded at origin address
+func assemble(src string, platform Platform, origin uint16) ([]uint8, error) {
+	labels := make(map[string]uint16)
+	lines := make([]asm_line, 0)
+	pc := origin
+	// First pass: collect labels and instruction addresses
+	for num, text := range strings.Split(src, "\n") {
+		if i := strings.IndexByte(text, ';'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		for i := strings.IndexByte(text, ':'); i >= 0; i = strings.IndexByte(text, ':') {
+			label := strings.TrimSpace(text[:i])
+			if !asm_is_identifier(label) || asm_is_reserved(label) {
+				return nil, fmt.Errorf("line %d: bad label %q", num+1, label)
+			}
+			if _, ok := labels[label]; ok {
+				return nil, fmt.Errorf("line %d: label %s is already defined", num+1, label)
+			}
+			labels[label] = pc
+			text = strings.TrimSpace(text[i+1:])
+		}
+		if text == "" {
+			continue
+		}
+		line := asm_line{num: num + 1}
+		if i := strings.IndexAny(text, " \t"); i >= 0 {
+			for _, arg := range strings.Split(text[i+1:], ",") {
+				line.args = append(line.args, strings.TrimSpace(arg))
+			}
+			text = text[:i]
+		}
+		line.mnemonic = strings.ToUpper(text)
+		switch line.mnemonic {
+		case "DB":
+			pc += uint16(len(line.args))
+		case "DW":
+			pc += uint16(2 * len(line.args))
+		default:
+			pc += 2
+		}
+		lines = append(lines, line)
+	}
+	// Second pass: encode instructions
+	rom := make([]uint8, 0)
+	for _, line := range lines {
+		switch line.mnemonic {
+		case "DB":
+			for _, arg := range line.args {
+				val, err := asm_value(arg, 0xFF, labels)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s", line.num, err.Error())
+				}
+				rom = append(rom, uint8(val))
+			}
+		case "DW":
+			for _, arg := range line.args {
+				val, err := asm_value(arg, 0xFFFF, labels)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s", line.num, err.Error())
+				}
+				rom = append(rom, uint8(val>>8), uint8(val))
+			}
+		default:
+			op, err := asm_instruction(line, platform, labels)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line.num, err.Error())
+			}
+			rom = append(rom, uint8(op>>8), uint8(op))
+		}
+	}
+	return rom, nil
+}
+
+// Finds instruction matching mnemonic and operands, and encodes it
+func asm_instruction(line asm_line, platform Platform, labels map[string]uint16) (OpCode, error) {
+	known := false
+	for _, ins := range chip8_instructions {
+		if ins.platforms&platform == 0 || ins.mnemonic != line.mnemonic {
+			continue
+		}
+		known = true
+		for _, syntax := range ins.syntax_variants() {
+			op, ok, err := ins.assemble(syntax, line.args, labels)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				return op, nil
+			}
+		}
+	}
+	if !known {
+		return 0, fmt.Errorf("unknown instruction %s", line.mnemonic)
+	}
+	return 0, fmt.Errorf("bad operands for %s: %s", line.mnemonic, strings.Join(line.args, ", "))
+}
+
+// Returns operand lists accepted by instruction. Part of syntax in braces is optional
+func (ins *CHIP8Instruction) syntax_variants() [][]string {
+	split := func(syntax string) []string {
+		tokens := make([]string, 0)
+		for _, token := range strings.Split(syntax, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+		return tokens
+	}
+	full := strings.NewReplacer("{", "", "}", "").Replace(ins.syntax)
+	variants := [][]string{split(full)}
+	if i := strings.IndexByte(ins.syntax, '{'); i >= 0 {
+		variants = append(variants, split(ins.syntax[:i]))
+	}
+	return variants
+}
+
+// Encodes instruction if operands fit syntax. Returns false if they don't
+func (ins *CHIP8Instruction) assemble(syntax []string, args []string, labels map[string]uint16) (OpCode, bool, error) {
+	if len(syntax) != len(args) {
+		return 0, false, nil
+	}
+	var x, y uint8
+	var n uint16
+	for i, token := range syntax {
+		arg := args[i]
+		switch token {
+		case "Vx", "Vy":
+			reg, ok := asm_register(arg)
+			if !ok {
+				return 0, false, nil
+			}
+			if token == "Vx" {
+				x = reg
+			} else {
+				y = reg
+			}
+		case "nnn", "nn", "n":
+			if asm_is_reserved(arg) {
+				return 0, false, nil
+			}
+			max := map[string]uint16{"nnn": 0xFFF, "nn": 0xFF, "n": 0xF}[token]
+			val, err := asm_value(arg, max, labels)
+			if err != nil {
+				return 0, false, err
+			}
+			n = val
+		default:
+			if !strings.EqualFold(token, arg) {
+				return 0, false, nil
+			}
+		}
+	}
+	return ins.encode(x, y, n), true, nil
+}
+
+// Parses V0-VF register name
+func asm_register(arg string) (uint8, bool) {
+	if len(arg) != 2 || (arg[0] != 'V' && arg[0] != 'v') {
+		return 0, false
+	}
+	reg, err := strconv.ParseUint(arg[1:], 16, 4)
+	if err != nil {
+		return 0, false
+	}
+	return uint8(reg), true
+}
+
+// Parses number or label and checks that it fits max
+func asm_value(arg string, max uint16, labels map[string]uint16) (uint16, error) {
+	var val uint64
+	var err error
+	lower := strings.ToLower(arg)
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		val, err = strconv.ParseUint(arg[2:], 16, 16)
+	case strings.HasPrefix(arg, "#"), strings.HasPrefix(arg, "$"):
+		val, err = strconv.ParseUint(arg[1:], 16, 16)
+	case asm_is_identifier(arg):
+		addr, ok := labels[arg]
+		if !ok {
+			return 0, fmt.Errorf("unknown label %s", arg)
+		}
+		val = uint64(addr)
+	default:
+		val, err = strconv.ParseUint(arg, 10, 16)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("bad number %s", arg)
+	}
+	if val > uint64(max) {
+		return 0, fmt.Errorf("value %s doesn't fit 0x%X", arg, max)
+	}
+	return uint16(val), nil
+}
+
+func asm_is_identifier(str string) bool {
+	if str == "" || (str[0] >= '0' && str[0] <= '9') {
+		return false
+	}
+	for _, c := range str {
+		if !(c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// Registers and literal operands of instructions (I, DT, K...) can't be labels
+func asm_is_reserved(str string) bool {
+	if _, ok := asm_register(str); ok {
+		return true
+	}
+	for _, ins := range chip8_instructions {
+		for _, syntax := range ins.syntax_variants() {
+			for _, token := range syntax {
+				switch token {
+				case "Vx", "Vy", "nnn", "nn", "n":
+				default:
+					if strings.EqualFold(token, str) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+// Disassembled opcode of every instruction assembles back to the same opcode
+func TestDisasmAsm(t *testing.T) {
+	for _, ins := range chip8_instructions {
+		op := ins.encode(0x1, 0xA, 0xB5C)
+		for platform := PlatformCHIP8; platform&PlatformsClassic != 0; platform <<= 1 {
+			if ins.platforms&platform == 0 || decode(op, platform) != ins {
+				continue
+			}
+			text := ins.format(op)
+			rom, err := assemble(text, platform, 0x200)
+			if err != nil {
+				t.Errorf("%s on platform %X: %s", text, platform, err.Error())
+				continue
+			}
+			if len(rom) != 2 || OpCode(rom[0])<<8|OpCode(rom[1]) != op {
+				t.Errorf("%04X is %s on platform %X, which assembles to % X", uint16(op), text, platform, rom)
+			}
+		}
+	}
+}
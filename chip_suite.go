@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Test suite file lists ROMs to run headlessly and their expected results:
+//
+//	[[test]]
+//	name = "IBM logo"
+//	rom = "roms/ibm.ch8"
+//	frames = 60
+//	golden = "golden/ibm.png"  # .png is compared by pixels, other files hold SHA-1 of screen
+//	input = [{frame = 10, keys = "5"}, {frame = 20, keys = ""}]
+//	memory = {"0x300" = 1}
+//	load = 0x100               # Load address and entry, platform's when unset
+//	entry = 0x100
+//	image = false              # ROM is image of whole 4K or 64K memory
+//	seed = 1                   # Random numbers of CXNN, seed 0 when unset
+//
+// Paths are relative to suite file. Missing golden file fails the test; with
+// update, golden files are written from screens of the run.
+// Tests run without user config and user ROM database, with seeded random
+// numbers, so results are the same on every machine.
+type CHIP8Suite struct {
+	Tests []CHIP8SuiteTest `toml:"test"`
+}
+
+type CHIP8SuiteTest struct {
+	Name   string
+	Rom    string
+	Frames int
+	Golden string
+	Input  []CHIP8SuiteInput
+	Memory map[string]uint8 // Expected bytes by address
+	Load   *uint32          // Nil is platform's
+	Entry  *uint32
+	Image  bool
+	Seed   int64
+}
+
+type CHIP8SuiteInput struct {
+	Frame int    // Frame from which keys are held
+	Keys  string // Hex digits of pressed keys, empty to release all
+}
+
+// Runs all tests of suite. Returns false if any of them failed. With update,
+// golden files are written instead of compared
+func run_suite(suite_path string, update bool) bool {
+	var suite CHIP8Suite
+	if _, err := toml.DecodeFile(suite_path, &suite); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return false
+	}
+	dir := filepath.Dir(suite_path)
+	failed := 0
+	for _, test := range suite.Tests {
+		if test.Name == "" {
+			test.Name = test.Rom
+		}
+		if err := test.run(dir, update); err != nil {
+			fmt.Printf("FAIL %s: %s\n", test.Name, err.Error())
+			failed++
+		} else {
+			fmt.Printf("ok   %s\n", test.Name)
+		}
+	}
+	fmt.Printf("%d passed, %d failed\n", len(suite.Tests)-failed, failed)
+	return failed == 0
+}
+
+func (test *CHIP8SuiteTest) run(dir string, update bool) error {
+	rom_path := filepath.Join(dir, test.Rom)
+	keys, err := test.key_script()
+	if err != nil {
+		return err
+	}
+	console := &CHIP8Console{headless: true, bundled_db: true, rng: new_random(test.Seed), boot: CHIP8Boot{image: test.Image}}
+	if test.Load != nil {
+		console.boot.load, console.boot.has_load = *test.Load, true
+	}
+	if test.Entry != nil {
+		console.boot.entry, console.boot.has_entry = *test.Entry, true
+	}
+	if err := console.init(rom_path); err != nil {
+		return err
+	}
+	for frame := 0; frame < test.Frames; frame++ {
+		if mask, ok := keys[frame]; ok {
+			console.input.set_keys(mask)
+		}
+		console.frame()
+	}
+	screen := screenshot(console.gpu)
+	errors := make([]string, 0)
+	if test.Golden != "" {
+		if err := compare_golden(screen, filepath.Join(dir, test.Golden), update); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+	addrs := make([]string, 0, len(test.Memory))
+	for addr := range test.Memory {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		n, err := strconv.ParseUint(addr, 0, 32)
+		if err != nil {
+			return fmt.Errorf("bad memory address %q", addr)
+		}
+		if got := console.mem.read(uint32(n)); got != test.Memory[addr] {
+			errors = append(errors, fmt.Sprintf("mem[%s] = 0x%02X, want 0x%02X", addr, got, test.Memory[addr]))
+		}
+	}
+	if len(errors) > 0 {
+		return fmt.Errorf("%s", strings.Join(errors, "; "))
+	}
+	return nil
+}
+
+// Returns pressed keys mask by frame where it changes
+func (test *CHIP8SuiteTest) key_script() (map[int]uint16, error) {
+	keys := make(map[int]uint16)
+	for _, input := range test.Input {
+		var mask uint16
+		for _, c := range input.Keys {
+			key, err := strconv.ParseUint(string(c), 16, 4)
+			if err != nil {
+				return nil, fmt.Errorf("bad key %q at frame %d", c, input.Frame)
+			}
+			mask |= 1 << key
+		}
+		keys[input.Frame] = mask
+	}
+	return keys, nil
+}
+
+// Returns screen as black and white image, one image pixel per screen pixel
+func screenshot(gpu CHIP8GPU_i) *image.Gray {
+	w, h := gpu.size()
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			if gpu.pixel(x, y) != 0 {
+				img.SetGray(x, y, color.Gray{0xFF})
+			}
+		}
+	}
+	return img
+}
+
+func screen_hash(screen *image.Gray) string {
+	sum := sha1.Sum(screen.Pix)
+	return hex.EncodeToString(sum[:])
+}
+
+// Compares screen with golden PNG image or hash file, or writes golden file
+// with update. On mismatch actual screen and image diff are written next to golden file
+func compare_golden(screen *image.Gray, golden_path string, update bool) error {
+	is_png := strings.EqualFold(filepath.Ext(golden_path), ".png")
+	if update {
+		var err error
+		if is_png {
+			err = write_png(golden_path, screen)
+		} else {
+			err = ioutil.WriteFile(golden_path, []byte(screen_hash(screen)+"\n"), 0644)
+		}
+		if err == nil {
+			fmt.Printf("     updated %s\n", golden_path)
+		}
+		return err
+	}
+	if _, err := os.Stat(golden_path); os.IsNotExist(err) {
+		return fmt.Errorf("golden file %s is missing, -update writes it", golden_path)
+	}
+	base := strings.TrimSuffix(golden_path, filepath.Ext(golden_path))
+	if !is_png {
+		data, err := ioutil.ReadFile(golden_path)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(data)) == screen_hash(screen) {
+			return nil
+		}
+		write_png(base+".actual.png", screen)
+		return fmt.Errorf("screen hash differs from %s, screen written to %s.actual.png", golden_path, base)
+	}
+	golden, err := read_png(golden_path)
+	if err != nil {
+		return err
+	}
+	if golden.Bounds() != screen.Bounds() {
+		write_png(base+".actual.png", screen)
+		return fmt.Errorf("screen size %v differs from %v in %s", screen.Bounds().Size(), golden.Bounds().Size(), golden_path)
+	}
+	diff, count := image_diff(golden, screen)
+	if count == 0 {
+		return nil
+	}
+	write_png(base+".actual.png", screen)
+	write_png(base+".diff.png", diff)
+	return fmt.Errorf("%d pixels differ from %s, diff written to %s.diff.png", count, golden_path, base)
+}
+
+// Returns image where pixels lit in both images are white, only in golden are red,
+// only in actual are green. Also returns number of different pixels
+func image_diff(golden image.Image, actual *image.Gray) (*image.RGBA, int) {
+	bounds := actual.Bounds()
+	diff := image.NewRGBA(bounds)
+	count := 0
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			want := color.GrayModel.Convert(golden.At(x, y)).(color.Gray).Y >= 0x80
+			got := actual.GrayAt(x, y).Y >= 0x80
+			switch {
+			case want && got:
+				diff.Set(x, y, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF})
+			case want:
+				diff.Set(x, y, color.RGBA{0xFF, 0x00, 0x00, 0xFF})
+				count++
+			case got:
+				diff.Set(x, y, color.RGBA{0x00, 0xFF, 0x00, 0xFF})
+				count++
+			default:
+				diff.Set(x, y, color.RGBA{0x00, 0x00, 0x00, 0xFF})
+			}
+		}
+	}
+	return diff, count
+}
+
+func read_png(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return png.Decode(file)
+}
+
+func write_png(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Draws digit of pressed key, waits for key with FX0A
+const suite_test_src = `
+	LD V0, K
+	LD F, V0
+	LD V1, 10
+	DRW V1, V1, 5
+	LD I, 0x300
+	LD B, V0
+loop:
+	JP loop
+`
+
+func write_suite(t *testing.T, dir, golden, key string) string {
+	rom, err := assemble(suite_test_src, PlatformCHIP8, 0x200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "key.ch8"), rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	suite := `
+[[test]]
+name = "key"
+rom = "key.ch8"
+frames = 10
+golden = "` + golden + `"
+input = [{frame = 3, keys = "` + key + `"}, {frame = 4, keys = ""}]
+memory = {"0x302" = 0x` + key + `}
+`
+	path := filepath.Join(dir, "suite.toml")
+	if err = ioutil.WriteFile(path, []byte(suite), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSuiteGoldenImage(t *testing.T) {
+	dir := t.TempDir()
+	path := write_suite(t, dir, "key.png", "7")
+	if run_suite(path, false) {
+		t.Fatal("run without golden image passed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "key.png")); err == nil {
+		t.Fatal("golden image is created without update")
+	}
+	if !run_suite(path, true) {
+		t.Fatal("update failed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "key.png")); err != nil {
+		t.Fatal("golden image is not written by update")
+	}
+	if !run_suite(path, false) {
+		t.Fatal("run against created golden image failed")
+	}
+	// Other key draws other digit
+	write_suite(t, dir, "key.png", "3")
+	if run_suite(path, false) {
+		t.Fatal("run with different screen passed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "key.diff.png")); err != nil {
+		t.Fatal("image diff is not written")
+	}
+}
+
+func TestSuiteGoldenHash(t *testing.T) {
+	dir := t.TempDir()
+	path := write_suite(t, dir, "key.sha1", "7")
+	if run_suite(path, false) {
+		t.Fatal("run without golden hash passed")
+	}
+	if !run_suite(path, true) || !run_suite(path, false) {
+		t.Fatal("run failed")
+	}
+	ioutil.WriteFile(filepath.Join(dir, "key.sha1"), []byte("0000\n"), 0644)
+	if run_suite(path, false) {
+		t.Fatal("run with wrong hash passed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "key.actual.png")); err != nil {
+		t.Fatal("actual screen is not written")
+	}
+}
+
+// RND is seeded and user ROM database is ignored, so suite repeats anywhere
+func TestSuiteRepeatable(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	rom := []uint8{
+		0xC0, 0xFF, // RND V0, FF
+		0xA3, 0x00, // LD I, 300
+		0xF0, 0x55, // LD [I], V0
+		0x12, 0x06, // JP 206
+	}
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "rnd.ch8"), rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	settings := default_settings()
+	settings.cycles_per_frame = 1 // Store wouldn't run in the frame
+	if err := save_rom_settings(rom_hash(rom), "rnd.ch8", settings); err != nil {
+		t.Fatal(err)
+	}
+	want := uint8(new_random(42).next())
+	suite := fmt.Sprintf("[[test]]\nrom = \"rnd.ch8\"\nframes = 1\nseed = 42\nmemory = {\"0x300\" = %d}\n", want)
+	path := filepath.Join(dir, "suite.toml")
+	if err := ioutil.WriteFile(path, []byte(suite), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !run_suite(path, false) || !run_suite(path, false) {
+		t.Fatal("seeded run failed")
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Subcommand of command line: chipigo <name> [flags] args
+type cli_command struct {
+	name string
+	args string // Arguments after flags, for usage
+	help string
+	run  func(cmd *cli_command, args []string) int // Returns exit code
+}
+
+// Exit codes
+const (
+	exit_ok    = 0
+	exit_error = 1
+	exit_usage = 2
+)
+
+var cli_commands = []*cli_command{
+	{name: "run", args: "rom", help: "Run ROM in window.", run: cmd_run},
+	{name: "debug", args: "rom", help: "Run ROM paused, printing every executed instruction.\nP resumes, N runs one frame, M runs one instruction.", run: cmd_run},
+	{name: "disasm", args: "rom", help: "Print assembler text of ROM.", run: cmd_disasm},
+	{name: "asm", args: "source rom", help: "Assemble source into ROM.", run: cmd_asm},
+	{name: "mkpatch", args: "original new", help: "Write patch that turns original ROM into new one, BPS or IPS.", run: cmd_mkpatch},
+	{name: "export", args: "rom image", help: "Write memory with ROM loaded to Intel HEX (.hex) or S-record (.srec) image.\nFlags choose range and frames run first.", run: cmd_export},
+	{name: "info", args: "rom", help: "Print ROM hash and settings found in ROM database or detected.", run: cmd_info},
+	{name: "test", args: "suite.toml", help: "Run ROMs of test suite headlessly and compare screens with golden files.", run: cmd_test},
+	{name: "serve", args: "rom", help: "Run ROM headlessly and serve it on web page over WebSocket.\nFirst connected browser plays, others spectate.", run: cmd_serve},
+	{name: "netplay", args: "host rom | join address rom", help: "Play two-player game with other chipigo over TCP.\nBoth players use one keypad, host chooses input delay.", run: cmd_netplay},
+	{name: "gym", args: "rom", help: "Run ROM headlessly as reinforcement learning environments.\nReads reset and step requests as JSON lines on stdin, answers on stdout.", run: cmd_gym},
+	{name: "bench", args: "rom", help: "Run ROM headlessly as fast as possible and print emulation speed.", run: cmd_bench},
+}
+
+// Runs command line, returns exit code. ROM without command is run
+func run_cli(args []string) int {
+	if len(args) == 0 {
+		cli_usage()
+		return exit_usage
+	}
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		if len(args) > 1 {
+			if cmd := find_command(args[1]); cmd != nil {
+				cmd.run(cmd, []string{"-help"}) // Defines flags and prints usage with them
+				return exit_ok
+			}
+		}
+		cli_usage()
+		return exit_ok
+	}
+	cmd := find_command(name)
+	if cmd == nil {
+		cmd = find_command("run")
+	} else {
+		args = args[1:]
+	}
+	return cmd.run(cmd, args)
+}
+
+func find_command(name string) *cli_command {
+	for _, cmd := range cli_commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func cli_usage() {
+	fmt.Printf("Usage: chipigo <command> [flags] args\n\nCommands:\n")
+	for _, cmd := range cli_commands {
+		fmt.Printf("  %-8s %s\n", cmd.name, strings.SplitN(cmd.help, "\n", 2)[0])
+	}
+	fmt.Printf("\nchipigo rom is short for chipigo run rom. chipigo help <command> prints its flags.\n")
+}
+
+// New flag set of command with usage text
+func (cmd *cli_command) flag_set() *flag.FlagSet {
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.SetOutput(os.Stdout)
+	flags.Usage = func() {
+		fmt.Printf("Usage: chipigo %s [flags] %s\n\n%s\n", cmd.name, cmd.args, cmd.help)
+		has_flags := false
+		flags.VisitAll(func(*flag.Flag) { has_flags = true })
+		if has_flags {
+			fmt.Printf("\nFlags:\n")
+			flags.PrintDefaults()
+		}
+	}
+	return flags
+}
+
+// Parses flags and checks count of arguments. Flags may come before and
+// after arguments, -- ends flags. Returns false if usage was printed
+func parse_args(flags *flag.FlagSet, args []string, nargs int) ([]string, bool) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, false
+		}
+		rest := flags.Args()
+		if len(rest) == 0 {
+			break
+		}
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	if len(positional) != nargs {
+		flags.Usage()
+		return nil, false
+	}
+	return positional, true
+}
+
+// Flags of consoles, shared by run, debug, serve, netplay and bench
+type console_flags struct {
+	vip_timing  *bool
+	platform    *string
+	interpreter *string
+	config      *string
+	scale       *int
+	foreground  *string
+	background  *string
+	speed       *float64
+	tickrate    *int
+	font        *string
+	font_base   *uint
+	mute        *bool
+	script      *string
+	script_dir  *string
+	cheats      *bool
+	patches     *string_list
+	boot        *boot_flags
+	set         *flag.FlagSet // Tells which flags are given
+}
+
+// Flags of load address and entry, shared by console commands, info and gym
+type boot_flags struct {
+	load  *uint
+	entry *uint
+	image *bool
+	set   *flag.FlagSet // Tells whether load and entry are given
+}
+
+func add_boot_flags(flags *flag.FlagSet) *boot_flags {
+	return &boot_flags{
+		load:  flags.Uint("load", 0, "Address ROM is loaded at, 0x300 for example. Default is platform's"),
+		entry: flags.Uint("entry", 0, "Address of first instruction. Default is platform's"),
+		image: flags.Bool("image", false, "ROM is image of whole 4K or 64K memory with interpreter area, loaded at 0"),
+		set:   flags,
+	}
+}
+
+func (f *boot_flags) boot() CHIP8Boot {
+	boot := CHIP8Boot{load: uint32(*f.load), entry: uint32(*f.entry), image: *f.image}
+	f.set.Visit(func(given *flag.Flag) {
+		boot.has_load = boot.has_load || given.Name == "load"
+		boot.has_entry = boot.has_entry || given.Name == "entry"
+	})
+	return boot
+}
+
+// Flag that can be given several times
+type string_list []string
+
+func (list *string_list) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *string_list) Set(val string) error {
+	*list = append(*list, val)
+	return nil
+}
+
+func add_console_flags(flags *flag.FlagSet) *console_flags {
+	patches := &string_list{}
+	flags.Var(patches, "patch", "IPS or BPS patch applied to ROM, can be given several times")
+	return &console_flags{
+		vip_timing:  flags.Bool("vip", false, "COSMAC VIP cycle-accurate timing"),
+		platform:    flags.String("platform", "", "Platform id of ROM database (originalChip8, hybridVIP, chip8HiRes...)"),
+		interpreter: flags.String("interpreter", "", "Boot CHIP-8 interpreter image at 0x000 on emulated CDP1802"),
+		config:      flags.String("config", "", "Config file instead of user config.toml"),
+		scale:       flags.Int("scale", 0, "Window pixels per display pixel"),
+		foreground:  flags.String("fg", "", "Colour of lit pixels, #RRGGBB"),
+		background:  flags.String("bg", "", "Colour of dark pixels, #RRGGBB"),
+		speed:       flags.Float64("speed", 0, "Emulation speed, 0.25-8"),
+		tickrate:    flags.Int("tickrate", 0, "Instructions per frame"),
+		font:        flags.String("font", "", "Font: "+strings.Join(font_names(), ", ")+", or font file"),
+		font_base:   flags.Uint("font-base", 0, "Address of font, 0x050 for example"),
+		mute:        flags.Bool("mute", false, "No sound"),
+		script:      flags.String("script", "", "Starlark script with hooks of frames, instructions, memory writes and drawing"),
+		script_dir:  flags.String("script-dir", "", "Directory script may write screenshots to, no file access without it"),
+		cheats:      flags.Bool("cheats", false, "Freeze values of cheats saved for ROM"),
+		patches:     patches,
+		boot:        add_boot_flags(flags),
+		set:         flags,
+	}
+}
+
+// Console with config layers and command line layer of flags
+func (f *console_flags) console(headless bool) *CHIP8Console {
+	path := *f.config
+	if path == "" {
+		path = config_path()
+	}
+	over := &CHIP8Config{
+		Scale:      *f.scale,
+		Foreground: *f.foreground,
+		Background: *f.background,
+		Speed:      *f.speed,
+		Tickrate:   *f.tickrate,
+		Font:       *f.font,
+	}
+	f.set.Visit(func(given *flag.Flag) { // Given false and 0 override config too
+		switch given.Name {
+		case "font-base":
+			font_base := int(*f.font_base)
+			over.FontBase = &font_base
+		case "mute":
+			over.Audio.Mute = f.mute
+		}
+	})
+	return &CHIP8Console{
+		headless:    headless,
+		vip_timing:  *f.vip_timing,
+		interpreter: *f.interpreter,
+		platform_id: *f.platform,
+		config:      load_config(path),
+		flags:       over,
+		script_path: *f.script,
+		script_dir:  *f.script_dir,
+		use_cheats:  *f.cheats,
+		patches:     *f.patches,
+		boot:        f.boot.boot(),
+	}
+}
+
+func cmd_run(cmd *cli_command, args []string) int {
+	flags := cmd.flag_set()
+	f := add_console_flags(flags)
+	control := flags.String("control", "", "Control protocol: stdio reads JSON requests from stdin and answers on stdout")
+	headless := flags.Bool("headless", false, "No window: emulation runs by requests of control protocol, or until script quits")
+	args, ok := parse_args(flags, args, 1)
+	if !ok {
+		return exit_usage
+	}
+	if *control != "" && *control != "stdio" || *headless && *control == "" && *f.script == "" {
+		flags.Usage()
+		return exit_usage
+	}
+	console := f.console(*headless)
+	console.trace = cmd.name == "debug"
+	if err := console.init(args[0]); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return exit_error
+	}
+	if *control != "" {
+		console.remote = new_control(console, os.Stdin, os.Stdout)
+	}
+	if *headless && console.remote != nil {
+		console.remote.serve()
+	} else if *headless {
+		for !console.script_done() {
+			console.frame()
+		}
+	} else {
+		if cmd.name == "debug" {
+			console.set_paused(true)
+		}
+		console.loop()
+	}
+	if console.script != nil && console.script.err != nil {
+		fmt.Printf("Script: %s\n", console.script.err.Error())
+		return exit_error
+	}
+	return exit_ok
+}
+
+// Platform by ROM database id
+func parse_platform(id string) (Platform, error) {
+	platform, ok := romdb_platforms[id]
+	if !ok {
+		ids := make([]string, 0, len(romdb_platforms))
+		for id := range romdb_platforms {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return 0, fmt.Errorf("unknown platform %s, known are %s", id, strings.Join(ids, ", "))
+	}
+	return platform, nil
+}
+
+func cmd_disasm(cmd *cli_command, args []string) int {
+	flags := cmd.flag_set()
+	platform_id := flags.String("platform", "originalChip8", "Platform id of ROM database")
+	args, ok := parse_args(flags, args, 1)
+	if !ok {
+		return exit_usage
+	}
+	platform, err := parse_platform(*platform_id)
+	if err == nil {
+		err = disasm_rom(args[0], platform)
+	}
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return exit_error
+	}
+	return exit_ok
+}
+
+func cmd_asm(cmd *cli_command, args []string) int {
+	flags := cmd.flag_set()
+	platform_id := flags.String("platform", "originalChip8", "Platform id of ROM database")
+	args, ok := parse_args(flags, args, 2)
+	if !ok {
+		return exit_usage
+	}
+	platform, err := parse_platform(*platform_id)
+	if err == nil {
+		err = asm_rom(args[0], args[1], platform)
+	}
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return exit_error
+	}
+	return exit_ok
+}
+
+func cmd_info(cmd *cli_command, args []string) int {
+	flags := cmd.flag_set()
+	platform_id := flags.String("platform", "", "Platform id of ROM database instead of found one")
+	boot := add_boot_flags(flags)
+	args, ok := parse_args(flags, args, 1)
+	if !ok {
+		return exit_usage
+	}
+	console := &CHIP8Console{headless: true, platform_id: *platform_id, boot: boot.boot()}
+	if err := console.init(args[0]); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return exit_error
+	}
+	settings := console.settings
+	source := "ROM database"
+	if !console.rom_known {
+		source = "not in ROM database"
+	}
+	title := settings.title
+	if title == "" {
+		title = "-"
+	}
+	spec := platform_spec(settings.platform)
+	fmt.Printf("File:      %s\n", args[0])
+	if stat, err := os.Stat(args[0]); err == nil {
+		fmt.Printf("Size:      %d bytes\n", stat.Size())
+	}
+	fmt.Printf("SHA-1:     %s (%s)\n", console.rom_hash, source)
+	fmt.Printf("Title:     %s\n", title)
+	fmt.Printf("Platform:  %s, %dx%d, loaded at 0x%X, entry 0x%X\n", settings.platform_id, spec.width, spec.height, console.load_address(), console.entry_point())
+	fmt.Printf("Tickrate:  %d\n", settings.cycles_per_frame)
+	fmt.Printf("Quirks:    %+v\n", settings.quirks)
+	fmt.Printf("Colours:   %s on %s\n", format_color(settings.foreground), format_color(settings.background))
+	font := settings.font
+	if font == "" {
+		font = default_font
+	}
+	fmt.Printf("Font:      %s at 0x%03X, stack at 0x%03X\n", font, console.font.small, console.font.stack)
+	return exit_ok
+}
+
+func cmd_test(cmd *cli_command, args []string) int {
+	flags := cmd.flag_set()
+	update := flags.Bool("update", false, "Write golden files from screens of this run instead of comparing")
+	args, ok := parse_args(flags, args, 1)
+	if !ok {
+		return exit_usage
+	}
+	if !run_suite(args[0], *update) {
+		return exit_error
+	}
+	return exit_ok
+}
+
+func cmd_bench(cmd *cli_command, args []string) int {
+	flags := cmd.flag_set()
+	f := add_console_flags(flags)
+	frames := flags.Int("frames", 600, "Frames to run")
+	args, ok := parse_args(flags, args, 1)
+	if !ok {
+		return exit_usage
+	}
+	console := f.console(true)
+	if err := console.init(args[0]); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return exit_error
+	}
+	start := time.Now()
+	for i := 0; i < *frames; i++ {
+		console.frame()
+	}
+	seconds := time.Since(start).Seconds()
+	fps := float64(*frames) / seconds
+	fmt.Printf("%d frames in %.3f s: %.0f frames/s, %.1fx real time\n", *frames, seconds, fps, fps/frames_per_second)
+	return exit_ok
+}
+
+func cmd_gym(cmd *cli_command, args []string) int {
+	flags := cmd.flag_set()
+	platform_id := flags.String("platform", "", "Platform id of ROM database instead of found one")
+	envs := flags.Int("envs", 1, "Environments stepped in parallel")
+	reward := flags.String("reward", "", "Scores of reward, ADDR[:BYTES][:bcd][*WEIGHT], comma separated")
+	done := flags.String("done", "", "Episode ends when byte is value, ADDR=VALUE, comma separated")
+	max_frames := flags.Int("max-frames", 0, "Episode ends after frames, 0 is no limit")
+	boot := add_boot_flags(flags)
+	args, ok := parse_args(flags, args, 1)
+	if !ok {
+		return exit_usage
+	}
+	config := &CHIP8EnvConfig{platform_id: *platform_id, max_frames: *max_frames, boot: boot.boot()}
+	var err error
+	if config.scores, err = parse_scores(*reward); err == nil {
+		config.done, err = parse_env_ends(*done)
+	}
+	var batch *CHIP8EnvBatch
+	if err == nil {
+		batch, err = new_env_batch(args[0], config, *envs)
+	}
+	if err == nil {
+		err = batch.serve(os.Stdin, os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error()) // Stdout is for answers
+		return exit_error
+	}
+	return exit_ok
+}
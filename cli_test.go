@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Flags go before or after ROM, the way serve rom.ch8 --listen :8080 is written
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args  []string
+		rest  []string
+		vip   bool
+		patch []string
+	}{
+		{[]string{"-vip", "rom.ch8"}, []string{"rom.ch8"}, true, nil},
+		{[]string{"rom.ch8", "--vip"}, []string{"rom.ch8"}, true, nil},
+		{[]string{"--patch", "a.ips", "rom.ch8", "--patch", "b.bps"}, []string{"rom.ch8"}, false, []string{"a.ips", "b.bps"}},
+		{[]string{"--", "-rom.ch8"}, []string{"-rom.ch8"}, false, nil},
+	}
+	for _, test := range tests {
+		cmd := find_command("run")
+		flags := cmd.flag_set()
+		f := add_console_flags(flags)
+		rest, ok := parse_args(flags, test.args, 1)
+		if !ok || !reflect.DeepEqual(rest, test.rest) || *f.vip_timing != test.vip || f.patches.String() != strings.Join(test.patch, ",") {
+			t.Errorf("%v: args %v, vip %v, patches %v", test.args, rest, *f.vip_timing, *f.patches)
+		}
+	}
+	cmd := find_command("export")
+	flags := cmd.flag_set()
+	addr := flags.Uint("addr", 0, "")
+	if rest, ok := parse_args(flags, []string{"rom.ch8", "--addr", "0x200", "out.hex"}, 2); !ok || *addr != 0x200 || !reflect.DeepEqual(rest, []string{"rom.ch8", "out.hex"}) {
+		t.Errorf("flag between arguments: %v, addr %X", rest, *addr)
+	}
+}
+
+// Load and entry 0 are given addresses, not platform's
+func TestBootFlags(t *testing.T) {
+	flags := find_command("info").flag_set()
+	f := add_boot_flags(flags)
+	if _, ok := parse_args(flags, []string{"rom.ch8", "-load", "0"}, 1); !ok {
+		t.Fatal("flags aren't parsed")
+	}
+	if boot := f.boot(); !boot.has_load || boot.load != 0 || boot.has_entry {
+		t.Errorf("boot is %+v", boot)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-gl/glfw/v3.1/glfw"
+)
+
+// User defaults in config.toml of user config directory:
+//
+//	scale = 10                # Window pixels per display pixel of 64 pixel wide display
+//	foreground = "#11ff11"
+//	background = "#1a1a1a"
+//	speed = 1.0               # 0.25-8
+//	tickrate = 15             # Instructions per frame
+//	font = "vip"              # Built-in font or font file
+//	font_base = 0x050         # Address of font
+//	[quirks]                  # chip-8-database names, unset ones keep defaults
+//	wrap = false
+//	[keymap]                  # CHIP-8 key to host key
+//	5 = "up"
+//	[audio]
+//	volume = 0.5
+//	tone = 440.0              # Beep frequency, Hz
+//	mute = false
+//
+// Layers go from weakest to strongest: built-in defaults, config file,
+// CHIPIGO_* environment, ROM database entry of the game, command line flags.
+// Zero values are unset and don't override weaker layers. Values whose zero
+// means something, like mute = false, are pointers, nil is unset.
+type CHIP8Config struct {
+	Scale      int               `toml:"scale"`
+	Foreground string            `toml:"foreground"`
+	Background string            `toml:"background"`
+	Speed      float64           `toml:"speed"`
+	Tickrate   int               `toml:"tickrate"`
+	Font       string            `toml:"font"`
+	FontBase   *int              `toml:"font_base"`
+	Quirks     map[string]bool   `toml:"quirks"`
+	Keymap     map[string]string `toml:"keymap"`
+	Audio      CHIP8AudioConfig  `toml:"audio"`
+}
+
+type CHIP8AudioConfig struct {
+	Volume *float64 `toml:"volume"` // 0-1
+	Tone   float64  `toml:"tone"`
+	Mute   *bool    `toml:"mute"`
+}
+
+const (
+	default_scale  = 10
+	default_volume = 1.0
+	default_tone   = 440.0
+)
+
+// Environment variables of config layer. CHIPIGO_CONFIG is path of config file
+var config_env = []string{
+	"CHIPIGO_SCALE", "CHIPIGO_FOREGROUND", "CHIPIGO_BACKGROUND", "CHIPIGO_SPEED",
+	"CHIPIGO_TICKRATE", "CHIPIGO_VOLUME", "CHIPIGO_TONE", "CHIPIGO_MUTE",
+	"CHIPIGO_FONT", "CHIPIGO_FONT_BASE",
+}
+
+// Path of user config file. Empty if user config directory can't be found
+func config_path() string {
+	if path := os.Getenv("CHIPIGO_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "chipigo", "config.toml")
+}
+
+// Reads config file, missing file is empty config
+func read_config(path string) (*CHIP8Config, error) {
+	config := &CHIP8Config{}
+	if path == "" {
+		return config, nil
+	}
+	if _, err := toml.DecodeFile(path, config); err != nil && !os.IsNotExist(err) {
+		return config, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return config, nil
+}
+
+// Reads config layer of environment variables
+func env_config(getenv func(string) string) (*CHIP8Config, error) {
+	config := &CHIP8Config{}
+	var err error
+	for _, name := range config_env {
+		val := getenv(name)
+		if val == "" {
+			continue
+		}
+		switch name {
+		case "CHIPIGO_SCALE":
+			config.Scale, err = strconv.Atoi(val)
+		case "CHIPIGO_FOREGROUND":
+			config.Foreground = val
+		case "CHIPIGO_BACKGROUND":
+			config.Background = val
+		case "CHIPIGO_SPEED":
+			config.Speed, err = strconv.ParseFloat(val, 64)
+		case "CHIPIGO_TICKRATE":
+			config.Tickrate, err = strconv.Atoi(val)
+		case "CHIPIGO_VOLUME":
+			var volume float64
+			volume, err = strconv.ParseFloat(val, 64)
+			config.Audio.Volume = &volume
+		case "CHIPIGO_TONE":
+			config.Audio.Tone, err = strconv.ParseFloat(val, 64)
+		case "CHIPIGO_MUTE":
+			var mute bool
+			mute, err = strconv.ParseBool(val)
+			config.Audio.Mute = &mute
+		case "CHIPIGO_FONT":
+			config.Font = val
+		case "CHIPIGO_FONT_BASE":
+			var base int64
+			base, err = strconv.ParseInt(val, 0, 32)
+			font_base := int(base)
+			config.FontBase = &font_base
+		}
+		if err != nil {
+			return config, fmt.Errorf("%s: bad value %q", name, val)
+		}
+	}
+	return config, nil
+}
+
+// Config of file and environment. Errors are printed, bad layer is skipped
+func load_config(path string) *CHIP8Config {
+	config := &CHIP8Config{}
+	if file, err := read_config(path); err != nil {
+		fmt.Printf("Config is ignored: %s\n", err.Error())
+	} else {
+		config.merge(file)
+	}
+	if env, err := env_config(os.Getenv); err != nil {
+		fmt.Printf("Environment is ignored: %s\n", err.Error())
+	} else {
+		config.merge(env)
+	}
+	return config
+}
+
+// Sets fields of over that are set on top of config
+func (config *CHIP8Config) merge(over *CHIP8Config) {
+	if over == nil {
+		return
+	}
+	if over.Scale != 0 {
+		config.Scale = over.Scale
+	}
+	if over.Foreground != "" {
+		config.Foreground = over.Foreground
+	}
+	if over.Background != "" {
+		config.Background = over.Background
+	}
+	if over.Speed != 0 {
+		config.Speed = over.Speed
+	}
+	if over.Tickrate != 0 {
+		config.Tickrate = over.Tickrate
+	}
+	if over.Font != "" {
+		config.Font = over.Font
+	}
+	if over.FontBase != nil {
+		config.FontBase = over.FontBase
+	}
+	for name, on := range over.Quirks {
+		if config.Quirks == nil {
+			config.Quirks = make(map[string]bool)
+		}
+		config.Quirks[name] = on
+	}
+	for key, host := range over.Keymap {
+		if config.Keymap == nil {
+			config.Keymap = make(map[string]string)
+		}
+		config.Keymap[key] = host
+	}
+	if over.Audio.Volume != nil {
+		config.Audio.Volume = over.Audio.Volume
+	}
+	if over.Audio.Tone != 0 {
+		config.Audio.Tone = over.Audio.Tone
+	}
+	if over.Audio.Mute != nil {
+		config.Audio.Mute = over.Audio.Mute
+	}
+}
+
+// Applies game settings of config: colours, tickrate, font and quirks
+func (config *CHIP8Config) apply(settings *CHIP8Settings) {
+	if config == nil {
+		return
+	}
+	if config.Foreground != "" {
+		if color, err := parse_color(config.Foreground); err != nil {
+			fmt.Printf("Foreground is ignored: %s\n", err.Error())
+		} else {
+			settings.foreground = color
+		}
+	}
+	if config.Background != "" {
+		if color, err := parse_color(config.Background); err != nil {
+			fmt.Printf("Background is ignored: %s\n", err.Error())
+		} else {
+			settings.background = color
+		}
+	}
+	if config.Tickrate > 0 {
+		settings.cycles_per_frame = config.Tickrate
+	}
+	if config.Font != "" {
+		settings.font = config.Font
+	}
+	if config.FontBase != nil {
+		settings.font_base = uint32(*config.FontBase)
+	}
+	if len(config.Quirks) > 0 { // Partial quirks, the same way ROM database has them
+		data, _ := json.Marshal(config.Quirks)
+		if err := json.Unmarshal(data, &settings.quirks); err != nil {
+			fmt.Printf("Quirks are ignored: %s\n", err.Error())
+		}
+	}
+}
+
+// Host keys by config name: letters, digits, kp0-kp9 and some named keys
+var config_key_names = map[string]glfw.Key{
+	"space": glfw.KeySpace, "enter": glfw.KeyEnter, "tab": glfw.KeyTab,
+	"up": glfw.KeyUp, "down": glfw.KeyDown, "left": glfw.KeyLeft, "right": glfw.KeyRight,
+}
+
+func init() {
+	for i := 0; i < 26; i++ {
+		config_key_names[string(rune('a'+i))] = glfw.KeyA + glfw.Key(i)
+	}
+	for i := 0; i < 10; i++ {
+		config_key_names[strconv.Itoa(i)] = glfw.Key0 + glfw.Key(i)
+		config_key_names["kp"+strconv.Itoa(i)] = glfw.KeyKP0 + glfw.Key(i)
+	}
+}
+
+// Binds host keys of keymap to CHIP-8 keys
+func (config *CHIP8Config) apply_keymap(input CHIP8Input_i) {
+	for key, name := range config.Keymap {
+		n, err := strconv.ParseUint(key, 16, 4)
+		host, ok := config_key_names[strings.ToLower(name)]
+		if err != nil || !ok {
+			fmt.Printf("Key %s = %q is ignored\n", key, name)
+			continue
+		}
+		input.remap(uint8(n), host)
+	}
+}
+
+// Audio settings with defaults for unset ones
+func (config *CHIP8Config) audio() (volume, tone float64) {
+	volume, tone = default_volume, default_tone
+	if config.Audio.Volume != nil {
+		volume = *config.Audio.Volume
+	}
+	if config.Audio.Tone != 0 {
+		tone = config.Audio.Tone
+	}
+	if config.Audio.Mute != nil && *config.Audio.Mute {
+		volume = 0
+	}
+	return volume, tone
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func write_config(t *testing.T, text string) string {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFile(t *testing.T) {
+	config, err := read_config(write_config(t, `
+scale = 4
+foreground = "#ff0000"
+tickrate = 30
+[quirks]
+wrap = false
+logic = true
+[keymap]
+5 = "up"
+[audio]
+mute = true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := default_settings()
+	config.apply(settings)
+	if settings.foreground != 0xFF0000 || settings.background != default_settings().background || settings.cycles_per_frame != 30 {
+		t.Errorf("settings are %+v", settings)
+	}
+	want := default_quirks
+	want.Wrap, want.Logic = false, true
+	if settings.quirks != want {
+		t.Errorf("quirks are %+v, want %+v", settings.quirks, want)
+	}
+	if volume, _ := config.audio(); volume != 0 {
+		t.Errorf("muted volume is %v", volume)
+	}
+	if config.Scale != 4 || config.Keymap["5"] != "up" {
+		t.Errorf("config is %+v", config)
+	}
+
+	if _, err := read_config(filepath.Join(t.TempDir(), "missing.toml")); err != nil {
+		t.Errorf("missing config is an error: %s", err.Error())
+	}
+	if _, err := read_config(write_config(t, "scale = ")); err == nil {
+		t.Errorf("bad config is not an error")
+	}
+}
+
+// Environment is over config file, flags are over both
+func TestConfigLayers(t *testing.T) {
+	env := map[string]string{"CHIPIGO_SPEED": "2", "CHIPIGO_BACKGROUND": "#000080", "CHIPIGO_FONT_BASE": "0x050"}
+	env_layer, err := env_config(func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &CHIP8Config{Speed: 0.5, Scale: 3, Background: "#ffffff"}
+	config.merge(env_layer)
+	config.merge(&CHIP8Config{Scale: 6})
+	if config.Speed != 2 || config.Scale != 6 || config.Background != "#000080" || config.FontBase == nil || *config.FontBase != 0x50 {
+		t.Errorf("config is %+v", config)
+	}
+	if _, err := env_config(func(string) string { return "fast" }); err == nil {
+		t.Errorf("bad environment value is not an error")
+	}
+}
+
+// Config is under ROM database, flags are over it
+func TestConsoleConfig(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "test.ch8")
+	if err := ioutil.WriteFile(path, []uint8{0x12, 0x00}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	console := &CHIP8Console{
+		headless: true,
+		config:   &CHIP8Config{Foreground: "#ff0000", Tickrate: 20, Speed: 4},
+		flags:    &CHIP8Config{Tickrate: 50},
+	}
+	if err := console.init(path); err != nil {
+		t.Fatal(err)
+	}
+	if console.settings.foreground != 0xFF0000 || console.settings.cycles_per_frame != 50 || console.speed != 4 {
+		t.Errorf("settings are %+v, speed %v", console.settings, console.speed)
+	}
+
+	console = &CHIP8Console{headless: true, config: &CHIP8Config{Tickrate: 20}}
+	console.platform_id = "chip8x" // Platform of database is over config
+	console.init(path)
+	if console.settings.cycles_per_frame == 20 {
+		t.Errorf("platform tickrate of database doesn't override config")
+	}
+}
+
+// False and 0 of stronger layer override true and other numbers of weaker ones
+func TestConfigZeroOverrides(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	file, err := read_config(write_config(t, "font_base = 0x50\n[audio]\nmute = true\nvolume = 0.5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"CHIPIGO_MUTE": "false", "CHIPIGO_VOLUME": "0"}
+	env_layer, err := env_config(func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &CHIP8Config{}
+	config.merge(file)
+	config.merge(env_layer)
+	if volume, _ := config.audio(); *config.Audio.Mute || volume != 0 {
+		t.Errorf("environment doesn't unmute or set volume 0: mute %v, volume %v", *config.Audio.Mute, volume)
+	}
+
+	flag_layer := func(args ...string) *CHIP8Config {
+		flags := find_command("run").flag_set()
+		f := add_console_flags(flags)
+		if _, ok := parse_args(flags, append(args, "rom.ch8"), 1); !ok {
+			t.Fatalf("flags %v aren't parsed", args)
+		}
+		return f.console(true).flags
+	}
+	config = &CHIP8Config{}
+	config.merge(file)
+	config.merge(flag_layer("-font-base", "0", "-mute=false"))
+	settings := default_settings()
+	settings.font_base = 0x50
+	config.apply(settings)
+	if volume, _ := config.audio(); settings.font_base != 0 || volume != 0.5 {
+		t.Errorf("flags don't set font base 0 or unmute: font base %X, volume %v", settings.font_base, volume)
+	}
+	if over := flag_layer(); over.FontBase != nil || over.Audio.Mute != nil {
+		t.Errorf("flags that aren't given are set: %+v", over)
+	}
+}
+
+func TestConsoleMissingROM(t *testing.T) {
+	console := &CHIP8Console{headless: true}
+	if err := console.init(filepath.Join(t.TempDir(), "missing.ch8")); err == nil {
+		t.Errorf("missing ROM is not an error")
+	}
+}
+
+func TestCLIExitCodes(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	quiet(t)
+	if code := run_cli(nil); code != exit_usage {
+		t.Errorf("no arguments exit with %d", code)
+	}
+	if code := run_cli([]string{"run"}); code != exit_usage {
+		t.Errorf("run without ROM exits with %d", code)
+	}
+	if code := run_cli([]string{"run", filepath.Join(t.TempDir(), "missing.ch8")}); code != exit_error {
+		t.Errorf("run of missing ROM exits with %d", code)
+	}
+	if code := run_cli([]string{"disasm", "-platform", "nope", "x.ch8"}); code != exit_error {
+		t.Errorf("unknown platform exits with %d", code)
+	}
+	if code := run_cli([]string{"help", "bench"}); code != exit_ok {
+		t.Errorf("help exits with %d", code)
+	}
+}
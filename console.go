@@ -1,16 +1,28 @@
 package main
 
 import (
+	"fmt"
 	"github.com/go-gl/glfw/v3.1/glfw"
+	"math/rand"
 	"time"
 )
 
 type CHIP8Console_i interface {
-	init(str string)
+	init(str string) error // Error if ROM can't be read
 	loop()
+	frame()
 	tick()
+	set_paused(on bool)
+	advance_frame() // Pause and run one frame
+	step()          // Pause and run one instruction
+	set_speed(speed float64)
+	set_fast_forward(on bool)
+	soft_reset() // Reset CPU, memory is kept
+	hard_reset() // Reload ROM and reset everything
 }
 
+const frames_per_second = 60.0
+
 type CHIP8Console struct {
 	mem    CHIP8Memory_i
 	cpu    CHIP8CPU_i
@@ -18,26 +30,253 @@ type CHIP8Console struct {
 	input  CHIP8Input_i
 	sound  CHIP8Sound_i
 	window *glfw.Window
+	vip    *COSMACVIP // CDP1802 for 0NNN calls and interpreter boot. Created on first use
+	port   CHIP8Port  // Device on CHIP-8X I/O port, nil if nothing is connected
+
+	headless    bool           // Run without window. Input is set by set_keys
+	vip_timing  bool           // Run instructions for COSMAC VIP machine cycles they take instead of fixed count per frame
+	settings    *CHIP8Settings // Per-game settings
+	interpreter string         // CHIP-8 interpreter image booted on CDP1802 instead of built-in interpreter
+	platform_id string         // Platform chosen by user, overrides ROM database and detection
+	config      *CHIP8Config   // Config file and environment layers, under ROM database. Nil is built-in defaults
+	flags       *CHIP8Config   // Command line layer, over ROM database
+	trace       bool           // Print every executed instruction
+	rom_path    string
+	rom_entry   string       // Entry of zip archive ROM is read from, picked once
+	rom         []uint8      // ROM as loaded, unwrapped and patched
+	rom_addr    int          // Address memory image file puts ROM at, -1 is load address of platform
+	patches     []string     // IPS and BPS patches applied to ROM in order
+	boot        CHIP8Boot    // Load address and entry chosen by user
+	font        font_layout  // Font of settings placed below program
+	rom_hash    string       // SHA-1 of ROM, key in ROM database
+	rom_known   bool         // ROM is found in ROM database
+	rng         *CHIP8Random // Random numbers of CXNN. Nil is shared source, environments and netplay seed their own
+	bundled_db  bool         // Bundled ROM database only, without user files, so runs repeat on any machine
+
+	paused       bool              // Frames run only by advance_frame and step
+	speed        float64           // Emulation speed, 1 is 60 frames per second
+	fast_forward bool              // Run frames as fast as host can
+	keys_down    map[glfw.Key]bool // Control keys held on last check, for key_hit
+	remote       *CHIP8Control     // JSON control protocol, nil if it's off
+	script       *CHIP8Script      // Hooks of Starlark script, nil without script
+	script_path  string
+	script_dir   string       // Directory script may write to, empty is no file access
+	cheats       *CHIP8Cheats // Values frozen every frame, nil without cheats
+	use_cheats   bool         // Load cheats saved for ROM
 }
 
-func (console *CHIP8Console) init(str string) {
+func (console *CHIP8Console) init(str string) error {
+	file, err := load_rom_file(str, console.rom_entry, console.patches)
+	if err != nil {
+		return err
+	}
+	rom := file.data
+	config := &CHIP8Config{}
+	config.merge(console.config)
+	config.merge(console.flags)
 	console.cpu = new(CHIP8CPU)
 	console.mem = new(CHIP8Memory)
-	console.gpu = new(CHIP8GPU)
+	console.gpu = &CHIP8GPU{headless: console.headless, scale: config.Scale}
 	console.input = new(CHIP8Input)
 	console.sound = new(CHIP8Sound)
 
-	console.cpu.init()
-	console.mem.init()
-	console.mem.read_rom(str)
+	console.rom_path = str
+	console.rom_entry = file.entry
+	console.rom = rom
+	console.rom_addr = file.addr
+	console.rom_hash = rom_hash(rom)
+	db := load_romdb()
+	if console.bundled_db {
+		db = load_romdb_from("")
+	}
+	base := default_settings()
+	console.config.apply(base)
+	console.settings, console.rom_known = db.lookup(console.rom_hash, base)
+	if !console.rom_known && len(console.patches) > 0 { // Fixes and hacks keep settings of game
+		console.settings, console.rom_known = db.lookup(rom_hash(file.original), base)
+	}
+	if console.platform_id != "" {
+		if !db.set_platform(console.settings, console.platform_id) {
+			fmt.Printf("Unknown platform %s\n", console.platform_id)
+		}
+	} else if !console.rom_known && file.options != nil {
+		if err = file.options.apply(console.settings, db); err != nil {
+			return fmt.Errorf("%s: %s", str, err.Error())
+		}
+	} else if !console.rom_known {
+		if platform, id, ok := detect_platform(rom); ok {
+			console.settings.platform = platform
+			console.settings.platform_id = id
+		}
+	}
+	console.flags.apply(console.settings)
+	if err = console.check_boot(rom); err != nil {
+		return err
+	}
+	if err = console.check_font(); err != nil {
+		return err
+	}
+
 	console.window = console.gpu.init()
 	console.input.init(console.window)
+	config.apply_keymap(console.input)
+	console.sound.set_audio(config.audio())
+	console.speed = 1
+	if config.Speed != 0 {
+		console.set_speed(config.Speed)
+	}
+	console.keys_down = make(map[glfw.Key]bool)
+	console.reset(rom)
+	if console.use_cheats {
+		if console.cheats, err = read_cheats(cheats_path(console.rom_hash), console.rom_hash); err != nil {
+			return err
+		}
+	}
+	if console.script_path != "" {
+		if console.script, err = load_script(console, console.script_path, console.script_dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Puts machine into power-on state with ROM loaded
+func (console *CHIP8Console) reset(rom []uint8) {
+	console.cpu.init()
+	console.mem.init()
+	spec := platform_spec(console.settings.platform)
+	if console.boot.image {
+		console.mem.resize(uint32(len(rom)))
+	} else if spec.memory != 0 {
+		console.mem.resize(spec.memory)
+	}
+	if !console.boot.image { // Image has font of its interpreter
+		console.load_font()
+	}
+	console.mem.load_rom(rom, console.load_address())
 	console.sound.init()
+	console.apply_settings()
+	console.cpu.set_trace(console.trace)
+	if console.interpreter != "" {
+		if err := console.get_vip().load_interpreter(console.interpreter); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			console.interpreter = ""
+		} else {
+			console.vip.boot()
+		}
+	}
+}
+
+func (console *CHIP8Console) apply_settings() {
+	settings := console.settings
+	spec := platform_spec(settings.platform)
+	console.apply_cpu_settings()
+	console.gpu.set_size(spec.width, spec.height)
+	console.gpu.set_colors(settings.foreground, settings.background)
+	console.gpu.set_color_zones(spec.color_zones)
+	console.gpu.set_title(settings.title)
+	for button, key := range settings.keys {
+		if host, ok := input_buttons[button]; ok {
+			console.input.bind(host, key)
+		}
+	}
+}
+
+// Address ROM is loaded at: chosen by user, given by memory image file, or platform's
+func (console *CHIP8Console) load_address() uint32 {
+	switch {
+	case console.boot.image:
+		return 0
+	case console.boot.has_load:
+		return console.boot.load
+	case console.rom_addr >= 0:
+		return uint32(console.rom_addr)
+	}
+	return uint32(platform_spec(console.settings.platform).load)
+}
+
+// Address of first instruction: chosen by user or platform's
+func (console *CHIP8Console) entry_point() uint16 {
+	if console.boot.has_entry {
+		return uint16(console.boot.entry)
+	}
+	return platform_spec(console.settings.platform).entry
+}
+
+// Checks that ROM and addresses chosen by user fit memory
+func (console *CHIP8Console) check_boot(rom []uint8) error {
+	boot := console.boot
+	memory := uint32(0x1000)
+	if spec := platform_spec(console.settings.platform); spec.memory != 0 {
+		memory = spec.memory
+	}
+	if boot.image {
+		if !contains_int(boot_image_sizes, len(rom)) {
+			return fmt.Errorf("memory image is %d bytes, 4096 or 65536 expected", len(rom))
+		}
+		memory = uint32(len(rom))
+	}
+	load := console.load_address()
+	if load >= memory {
+		return fmt.Errorf("load address %X is out of %X bytes of memory", load, memory)
+	}
+	if uint32(len(rom)) > memory-load {
+		return fmt.Errorf("ROM of %d bytes at %X doesn't fit %X bytes of memory", len(rom), load, memory)
+	}
+	if boot.entry >= memory || boot.entry > 0xFFFF {
+		return fmt.Errorf("entry %X is out of %X bytes of memory", boot.entry, memory)
+	}
+	return nil
+}
+
+// Loads font of settings and places it below program
+func (console *CHIP8Console) check_font() error {
+	font, err := load_font(console.settings.font)
+	if err != nil {
+		return err
+	}
+	end := console.load_address()
+	default_font := console.settings.font == "" && console.settings.font_base == 0
+	if console.boot.image || console.boot.has_load && default_font { // ROM may cover default font
+		end = uint32(platform_spec(console.settings.platform).load)
+	}
+	console.font, err = place_font(font, console.settings.font_base, end, console.settings.platform)
+	return err
+}
+
+// Moves default font of cleared memory to where font of settings goes
+func (console *CHIP8Console) load_font() {
+	console.mem.load_rom(make([]uint8, len(chip8_fonts[default_font].small)), 0)
+	console.mem.load_rom(console.font.data, console.font.small)
+}
+
+func contains_int(list []int, n int) bool {
+	for _, i := range list {
+		if i == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Platform, entry point, font, stack, quirks and timing of CPU
+func (console *CHIP8Console) apply_cpu_settings() {
+	settings := console.settings
+	console.cpu.set_platform(settings.platform)
+	console.cpu.set_entry(console.entry_point())
+	console.cpu.set_font(console.font.small, console.font.big)
+	console.cpu.set_stack(console.font.stack)
+	console.cpu.set_quirks(settings.quirks)
+	if console.vip_timing {
+		quirks := settings.quirks
+		quirks.VBlank = true // VIP interpreter draws sprites after display interrupt
+		console.cpu.set_quirks(quirks)
+		console.cpu.set_vip_timing(true)
+	}
 }
 
 func (console *CHIP8Console) loop() {
-	cycles_per_second := 120.0
-	one_cycle_in_ms := 1.0 / cycles_per_second
+	one_frame := 1.0 / frames_per_second
 	last_time := glfw.GetTime()
 	new_time := glfw.GetTime()
 	unprocessed := 0.0
@@ -46,25 +285,121 @@ func (console *CHIP8Console) loop() {
 		new_time = glfw.GetTime()
 		unprocessed += new_time - last_time
 		glfw.PollEvents()
-		if console.window.GetKey(glfw.KeyEscape) == glfw.Press || console.window.ShouldClose() {
+		if console.window.GetKey(glfw.KeyEscape) == glfw.Press || console.window.ShouldClose() || console.script_done() {
 			break
 		}
-		for unprocessed > one_cycle_in_ms {
+		console.control()
+		if console.remote != nil {
+			console.remote.poll()
+		}
+		if console.paused {
+			unprocessed = 0
+		} else if console.fast_forward { // Emulate frames for one host frame, show the last one
+			for glfw.GetTime()-new_time < one_frame {
+				console.emulate_frame()
+			}
+			console.gpu.render()
+			unprocessed = 0
+			console.remote_events()
+			continue
+		}
+		for unprocessed > one_frame/console.speed {
 			glfw.PollEvents()
-			if console.window.GetKey(glfw.KeyEscape) == glfw.Press || console.window.ShouldClose() {
+			if console.window.GetKey(glfw.KeyEscape) == glfw.Press || console.window.ShouldClose() || console.script_done() {
 				break
 			}
-			console.tick()
-			unprocessed -= one_cycle_in_ms
+			console.frame()
+			unprocessed -= one_frame / console.speed
 		}
-		time.Sleep(10 * time.Millisecond)
+		console.remote_events()
+		time.Sleep(time.Millisecond)
 	}
 	glfw.Terminate()
+	if !console.rom_known && console.remote == nil && stdin_is_terminal() { // Control requests and pipes aren't answers
+		prompt_save_settings(console.rom_hash, console.rom_path, console.settings)
+	}
 }
 
-func (console *CHIP8Console) tick() {
-	console.input.tick()
-	console.cpu.tick(console)
+// Runs one 1/60 second frame: display interrupt, then CPU runs cycles_per_frame
+// instructions, or instructions for VIP frame cycles, and screen is redrawn.
+// Booted interpreter runs on CDP1802 for the whole frame instead
+func (console *CHIP8Console) frame() {
+	console.emulate_frame()
 	console.gpu.render()
+}
+
+// Runs frame without redrawing screen
+func (console *CHIP8Console) emulate_frame() {
+	console.input.tick()
+	console.run_frame()
+}
+
+// Runs frame with keys as they are set
+func (console *CHIP8Console) run_frame() {
+	if console.interpreter != "" {
+		console.vip.run_frame()
+	} else {
+		console.cpu.vblank()
+		if console.vip_timing {
+			for !console.cpu.frame_done() {
+				console.tick()
+			}
+		} else {
+			for i := 0; i < console.settings.cycles_per_frame; i++ {
+				console.tick()
+			}
+		}
+		console.sound.turn_beep(console.cpu.beeping()) // CDP1802 drives beeper with Q itself
+	}
 	console.sound.tick()
+	if console.cheats != nil { // Program reads frozen values next frame
+		console.cheats.apply(console.mem)
+	}
+	if console.script != nil {
+		console.script.after_frame()
+	}
+}
+
+func (console *CHIP8Console) tick() {
+	if console.script != nil {
+		console.script.tick()
+		return
+	}
+	console.cpu.tick(console)
+}
+
+// Script has quit or failed
+func (console *CHIP8Console) script_done() bool {
+	return console.script != nil && console.script.done()
+}
+
+func (console *CHIP8Console) remote_events() {
+	if console.remote != nil {
+		console.remote.events()
+	}
+}
+
+func (console *CHIP8Console) random() int {
+	if console.rng != nil {
+		return console.rng.next()
+	}
+	return rand.Int()
+}
+
+// Seeded random numbers (splitmix64). Whole state is one number, so save
+// states keep it and rolled back frames get the same numbers again
+type CHIP8Random struct {
+	state uint64
+}
+
+func new_random(seed int64) *CHIP8Random {
+	return &CHIP8Random{state: uint64(seed)}
+}
+
+func (rng *CHIP8Random) next() int {
+	rng.state += 0x9E3779B97F4A7C15
+	z := rng.state
+	z = (z ^ z>>30) * 0xBF58476D1CE4E5B9
+	z = (z ^ z>>27) * 0x94D049BB133111EB
+	return int((z ^ z>>31) >> 1)
 }
@@ -10,13 +10,62 @@ type CHIP8GPU_i interface {
 	render()
 	init() *glfw.Window
 	draw_line8(x, y int8, line uint8) Registr // Return new value of VF
+	size() (w, h int)
+	pixel(x, y int) uint8
+	pixel_color(x, y int) uint32 // Colour pixel is shown in, 0xRRGGBB
+	set_pixel(x, y int, val uint8)
+	set_size(w, h int) // Change display resolution, screen is cleared
+	set_colors(color, background uint32)
+	set_title(title string)
+	set_color_zones(on bool)                 // CHIP-8X colour board. Colours of zones are reset
+	color_zones(x, y, w, h int, color uint8) // Colour zones of 8x1 pixels: x and w are in zones, y and h in pixels
+	cycle_background()                       // Next CHIP-8X background colour
+	scroll(dx, dy int)                       // Move picture, uncovered pixels are cleared
+	set_mega(on bool)                        // MegaChip 256x192 colour mode, pixels are palette indexes
+	set_palette(index uint8, argb uint32)
+	set_blend(mode uint8)
+	set_alpha(alpha uint8)
+	draw_mega(x, y int, index uint8) uint8 // Draw pixel of palette colour, return index it had. Pixels out of screen are clipped
+	save_state(state *CHIP8State)
+	load_state(state *CHIP8State)
+	draw_text(x, y float64, text string) // Overlay text of scripts, position is in display pixels
+	clear_text()
 }
 
+// VP-590 colour board of CHIP-8X: 8 foreground and 4 background colours
+var chip8x_foreground = [8]uint32{0x000000, 0xFF0000, 0x0000FF, 0xFF00FF, 0x00FF00, 0xFFFF00, 0x00FFFF, 0xFFFFFF}
+var chip8x_background = [4]uint32{0x000080, 0x000000, 0x008000, 0x800000}
+
+const chip8x_default_color = 1 // Red
+
+// MegaChip sprite blending modes, 080N
+const (
+	mega_blend_normal = iota
+	mega_blend_25
+	mega_blend_50
+	mega_blend_75
+	mega_blend_add
+	mega_blend_multiply
+)
+
 type CHIP8GPU struct {
-	pic    [][]uint8 // Screen can display only black and some 1 color
-	w, h   int       // width and height of screen
-	color  uint32    // color for filled pixels
-	window *glfw.Window
+	pic              [][]uint8 // Screen can display only black and some 1 color
+	w, h             int       // width and height of screen
+	color            uint32    // color for filled pixels
+	background       uint32    // color for empty pixels
+	window           *glfw.Window
+	headless         bool      // Don't create window, keep picture in memory only
+	scale            int       // Window pixels per display pixel of 64 pixel wide display
+	zones            [][]uint8 // CHIP-8X colours of 8x1 pixel zones. Nil without colour board
+	background_index int       // CHIP-8X background in chip8x_background
+
+	mega    bool        // MegaChip colour mode
+	rgb     [][]uint32  // MegaChip colours of pixels after blending
+	palette [256]uint32 // MegaChip ARGB palette, index 0 is transparent
+	blend   uint8       // MegaChip sprite blending mode
+	alpha   uint8       // MegaChip screen alpha, for fading
+
+	overlay []overlay_text // Text of scripts over picture
 } // Not full implemented yet
 
 // The interpreter reads n bytes from memory, starting at the address stored in I.
@@ -29,25 +78,21 @@ type CHIP8GPU struct {
 // and section 2.4, Display, for more information on the Chip-8 screen and sprites.
 func (gpu *CHIP8GPU) draw_line8(x, y int8, line uint8) Registr {
 	ret := 0
-	if y >= 32 {
-		y -= 32
-	}
-	for y < 0 {
-		y += 32
+	w, h := gpu.w, gpu.h
+	yp := int(y) % h
+	if yp < 0 {
+		yp += h
 	}
 	for i := 0; i < 8; i++ {
-		xp := x + int8(i)
-		if xp >= 64 {
-			xp -= 64
-		}
-		for xp < 0 {
-			xp += 64
+		xp := (int(x) + i) % w
+		if xp < 0 {
+			xp += w
 		}
-		new_pix := (gpu.pic[xp][y] & 1) ^ ((line >> uint(7-i)) & 1)
-		if gpu.pic[xp][y] == 1 && new_pix == 0 {
+		new_pix := (gpu.pic[xp][yp] & 1) ^ ((line >> uint(7-i)) & 1)
+		if gpu.pic[xp][yp] == 1 && new_pix == 0 {
 			ret = 1
 		}
-		gpu.pic[xp][y] = new_pix
+		gpu.pic[xp][yp] = new_pix
 	}
 	return Registr(ret)
 }
@@ -56,19 +101,191 @@ func (gpu *CHIP8GPU) clear_screen() {
 	for x := 0; x < gpu.w; x++ {
 		for y := 0; y < gpu.h; y++ {
 			gpu.pic[x][y] = 0
+			if gpu.mega {
+				gpu.rgb[x][y] = 0
+			}
+		}
+	}
+}
+
+func (gpu *CHIP8GPU) scroll(dx, dy int) {
+	for i := 0; i < gpu.w; i++ {
+		x := i
+		if dx > 0 { // Go against the move, so pixels aren't overwritten before they are moved
+			x = gpu.w - 1 - i
+		}
+		for j := 0; j < gpu.h; j++ {
+			y := j
+			if dy > 0 {
+				y = gpu.h - 1 - j
+			}
+			sx, sy := x-dx, y-dy
+			on_screen := sx >= 0 && sx < gpu.w && sy >= 0 && sy < gpu.h
+			gpu.pic[x][y] = 0
+			if on_screen {
+				gpu.pic[x][y] = gpu.pic[sx][sy]
+			}
+			if gpu.mega {
+				gpu.rgb[x][y] = 0
+				if on_screen {
+					gpu.rgb[x][y] = gpu.rgb[sx][sy]
+				}
+			}
+		}
+	}
+}
+
+func (gpu *CHIP8GPU) set_mega(on bool) {
+	if !on {
+		gpu.set_size(64, 32)
+		return
+	}
+	gpu.set_size(256, 192)
+	gpu.rgb = make([][]uint32, gpu.w)
+	for x := range gpu.rgb {
+		gpu.rgb[x] = make([]uint32, gpu.h)
+	}
+	gpu.mega = true
+	gpu.blend = mega_blend_normal
+	gpu.alpha = 0xFF
+}
+
+func (gpu *CHIP8GPU) set_palette(index uint8, argb uint32) {
+	gpu.palette[index] = argb
+}
+
+func (gpu *CHIP8GPU) set_blend(mode uint8) {
+	gpu.blend = mode
+}
+
+func (gpu *CHIP8GPU) set_alpha(alpha uint8) {
+	gpu.alpha = alpha
+}
+
+func (gpu *CHIP8GPU) draw_mega(x, y int, index uint8) uint8 {
+	if !gpu.mega || x < 0 || x >= gpu.w || y < 0 || y >= gpu.h {
+		return 0
+	}
+	old := gpu.pic[x][y]
+	gpu.pic[x][y] = index
+	gpu.rgb[x][y] = mega_blend(gpu.rgb[x][y], gpu.palette[index], gpu.blend)
+	return old
+}
+
+// Blends colour channels of src over dst
+func mega_blend(dst, src uint32, mode uint8) uint32 {
+	var out uint32
+	for shift := uint(0); shift < 24; shift += 8 {
+		d, s := dst>>shift&0xFF, src>>shift&0xFF
+		var c uint32
+		switch mode {
+		case mega_blend_25:
+			c = (3*d + s) / 4
+		case mega_blend_50:
+			c = (d + s) / 2
+		case mega_blend_75:
+			c = (d + 3*s) / 4
+		case mega_blend_add:
+			c = d + s
+			if c > 0xFF {
+				c = 0xFF
+			}
+		case mega_blend_multiply:
+			c = d * s / 0xFF
+		default:
+			c = s
 		}
+		out |= c << shift
+	}
+	return out
+}
+
+func (gpu *CHIP8GPU) size() (w, h int) {
+	return gpu.w, gpu.h
+}
+
+func (gpu *CHIP8GPU) pixel(x, y int) uint8 {
+	return gpu.pic[x][y]
+}
+
+func (gpu *CHIP8GPU) pixel_color(x, y int) uint32 {
+	if gpu.pic[x][y] == 0 {
+		return gpu.background
+	}
+	if gpu.mega { // Screen alpha fades to black
+		return mega_blend(gpu.rgb[x][y], uint32(gpu.alpha)*0x010101, mega_blend_multiply)
+	}
+	if gpu.zones != nil {
+		return chip8x_foreground[gpu.zones[x/8][y]]
+	}
+	return gpu.color
+}
+
+func (gpu *CHIP8GPU) set_pixel(x, y int, val uint8) {
+	gpu.pic[x][y] = val
+}
+
+func (gpu *CHIP8GPU) set_color_zones(on bool) {
+	if !on {
+		gpu.zones = nil
+		return
+	}
+	gpu.zones = make([][]uint8, (gpu.w+7)/8)
+	for x := range gpu.zones {
+		gpu.zones[x] = make([]uint8, gpu.h)
+		for y := range gpu.zones[x] {
+			gpu.zones[x][y] = chip8x_default_color
+		}
+	}
+	gpu.background_index = 0
+	gpu.set_colors(gpu.color, chip8x_background[0])
+}
+
+// Zones out of screen wrap around
+func (gpu *CHIP8GPU) color_zones(x, y, w, h int, color uint8) {
+	if gpu.zones == nil {
+		return
+	}
+	cols := len(gpu.zones)
+	for i := 0; i < w && i < cols; i++ {
+		for j := 0; j < h && j < gpu.h; j++ {
+			gpu.zones[(x+i)%cols][(y+j)%gpu.h] = color & 7
+		}
+	}
+}
+
+func (gpu *CHIP8GPU) cycle_background() {
+	gpu.background_index = (gpu.background_index + 1) % len(chip8x_background)
+	gpu.set_colors(gpu.color, chip8x_background[gpu.background_index])
+}
+
+func (gpu *CHIP8GPU) set_colors(color, background uint32) {
+	gpu.color = color
+	gpu.background = background
+	if gpu.window != nil {
+		gl.ClearColor(float32(background>>16&0xFF)/255, float32(background>>8&0xFF)/255, float32(background&0xFF)/255, 1.0)
+	}
+}
+
+func (gpu *CHIP8GPU) set_title(title string) {
+	if gpu.window != nil && title != "" {
+		gpu.window.SetTitle("CHIPIGO - " + title)
 	}
 }
 
 func (gpu *CHIP8GPU) render() {
+	if gpu.headless {
+		return
+	}
 	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
 	gl.LoadIdentity()
 	var x, y int32
 	for x = 0; x < int32(gpu.w); x++ {
 		for y = 0; y < int32(gpu.h); y++ {
 			if gpu.pic[x][y] != 0 {
+				color := gpu.pixel_color(int(x), int(y))
 				gl.Begin(gl.QUADS)
-				gl.Color3ub(uint8(gpu.color&0xFF0000>>16), uint8(gpu.color&0x00FF00>>8), uint8(gpu.color&0x0000FF))
+				gl.Color3ub(uint8(color&0xFF0000>>16), uint8(color&0x00FF00>>8), uint8(color&0x0000FF))
 				gl.Vertex2i(x, y)
 				gl.Vertex2i(x+1, y)
 				gl.Vertex2i(x+1, y+1)
@@ -77,27 +294,48 @@ func (gpu *CHIP8GPU) render() {
 			}
 		}
 	}
+	gpu.render_overlay()
 	gpu.window.SwapBuffers()
 }
 
-func (gpu *CHIP8GPU) init() *glfw.Window {
-	gpu.w = 64
-	gpu.h = 32
+func (gpu *CHIP8GPU) set_size(w, h int) {
+	gpu.w = w
+	gpu.h = h
+	gpu.mega = false // Colour mode is turned on by set_mega after resizing
 	gpu.pic = make([][]uint8, gpu.w)
 	for x := 0; x < gpu.w; x++ {
 		gpu.pic[x] = make([]uint8, gpu.h)
-		for y := 0; y < gpu.h; y++ {
-			gpu.pic[x][y] = 0
-		}
 	}
+	if gpu.zones != nil {
+		gpu.set_color_zones(true)
+	}
+	if gpu.window != nil {
+		gpu.window.SetSize(64*gpu.scale, 64*gpu.scale*h/w)
+		gl.MatrixMode(gl.PROJECTION)
+		gl.LoadIdentity()
+		gl.Ortho(0, float64(w), float64(h), 0, -1, 1)
+		gl.MatrixMode(gl.MODELVIEW)
+		gl.LoadIdentity()
+	}
+}
+
+func (gpu *CHIP8GPU) init() *glfw.Window {
+	if gpu.scale <= 0 {
+		gpu.scale = default_scale
+	}
+	gpu.set_size(64, 32)
 	gpu.color = 0x11FF11 // Some kind of green
+	gpu.background = 0x1A1A1A
+	if gpu.headless {
+		return nil
+	}
 
 	glfw.Init()
 
 	glfw.WindowHint(glfw.Resizable, glfw.False)
 	glfw.WindowHint(glfw.ContextVersionMajor, 2)
 	glfw.WindowHint(glfw.ContextVersionMinor, 1)
-	gpu.window, _ = glfw.CreateWindow(640, 320, "CHIPIGO", nil, nil)
+	gpu.window, _ = glfw.CreateWindow(64*gpu.scale, 32*gpu.scale, "CHIPIGO", nil, nil)
 	gpu.window.MakeContextCurrent()
 	if err := gl.Init(); err != nil {
 		panic(err)
@@ -117,76 +355,217 @@ func (gpu *CHIP8GPU) init() *glfw.Window {
 type CHIP8Sound_i interface {
 	init()
 	turn_beep(val bool) // Change beep status from true to false and from false to true
+	beeping() bool
+	play_sample(data []uint8, rate int, loop bool) // MegaChip digitised sound, 8-bit unsigned
+	stop_sample()
+	set_pitch(pitch uint8)          // ETI-660 tone pitch
+	set_audio(volume, tone float64) // Volume 0-1, beep frequency in Hz
+	pcm(out []int16, rate int)      // Fill interleaved stereo samples that follow last ones
 	tick()
 }
 
 type CHIP8Sound struct {
-	turn_on bool
+	turn_on     bool
+	sample      []uint8 // Playing MegaChip sample, nil if none
+	sample_rate int
+	sample_loop bool
+	pitch       uint8
+	volume      float64
+	tone        float64
+	phase       float64 // Position in period of beep, 0-1
+	sample_pos  float64 // Position in MegaChip sample, in its samples
 } // Not implemented
 
 func (sound *CHIP8Sound) init() {
 	sound.turn_on = false
+	sound.stop_sample()
 }
 
 func (sound *CHIP8Sound) turn_beep(val bool) {
 	sound.turn_on = val
 }
 
+func (sound *CHIP8Sound) beeping() bool {
+	return sound.turn_on
+}
+
+func (sound *CHIP8Sound) play_sample(data []uint8, rate int, loop bool) {
+	sound.sample = data
+	sound.sample_rate = rate
+	sound.sample_loop = loop
+	sound.sample_pos = 0
+}
+
+func (sound *CHIP8Sound) stop_sample() {
+	sound.sample = nil
+}
+
+func (sound *CHIP8Sound) set_pitch(pitch uint8) {
+	sound.pitch = pitch
+}
+
+func (sound *CHIP8Sound) set_audio(volume, tone float64) {
+	sound.volume = volume
+	sound.tone = tone
+}
+
+// Square wave of beeper, or MegaChip sample resampled to rate. Silence is 0
+func (sound *CHIP8Sound) pcm(out []int16, rate int) {
+	for i := 0; i+1 < len(out); i += 2 {
+		var val float64
+		if len(sound.sample) > 0 {
+			val = (float64(sound.sample[int(sound.sample_pos)]) - 128) * 0x80
+			sound.sample_pos += float64(sound.sample_rate) / float64(rate)
+			if int(sound.sample_pos) >= len(sound.sample) {
+				sound.sample_pos = 0
+				if !sound.sample_loop {
+					sound.sample = nil
+				}
+			}
+		} else if sound.turn_on {
+			val = 0x2000
+			if sound.phase >= 0.5 {
+				val = -val
+			}
+			sound.phase += sound.tone / float64(rate)
+			sound.phase -= float64(int(sound.phase))
+		}
+		out[i] = int16(val * sound.volume)
+		out[i+1] = out[i]
+	}
+}
+
 func (sound *CHIP8Sound) tick() {}
 
+// Device on CHIP-8X I/O port: FXF8 outputs to it, FXFB inputs from it
+type CHIP8Port interface {
+	out(val uint8)
+	in() (val uint8, ready bool) // Not ready input is waited for
+}
+
 type CHIP8Input_i interface {
 	init(*glfw.Window)
 	is_pressed(key uint8) bool
+	is_pressed2(key uint8) bool // Key of second keypad, CHIP-8X has one
+	set_keys(keys uint16)       // Set pressed keys directly. Used when there is no window
+	set_keys2(keys uint16)
+	bind(host glfw.Key, key uint8)
+	remap(key uint8, host glfw.Key) // Move CHIP-8 key to other host key
+	hold(keys uint16)               // Keep keys pressed on top of host keys. Used by control protocol
+	pressed() uint16                // Keys pressed now, bit per key
 	tick()
 }
 
 type CHIP8Input struct {
-	keys   int
-	window *glfw.Window
+	keys    uint16 // Bit per pressed key
+	keys2   uint16 // Bit per pressed key of second keypad
+	held    uint16 // Keys held by control protocol
+	keymap  map[glfw.Key]uint8
+	keymap2 map[glfw.Key]uint8
+	window  *glfw.Window
+}
+
+// Host keys for CHIP-8 keys 0-F
+var input_keymap = [16]glfw.Key{
+	glfw.KeyX, glfw.Key1, glfw.Key2, glfw.Key3,
+	glfw.KeyQ, glfw.KeyW, glfw.KeyE, glfw.KeyA,
+	glfw.KeyS, glfw.KeyD, glfw.KeyZ, glfw.KeyC,
+	glfw.Key4, glfw.KeyR, glfw.KeyF, glfw.KeyV,
+}
+
+// Host keys for second keypad: numeric keypad in the same layout
+var input_keymap2 = [16]glfw.Key{
+	glfw.KeyKPDecimal, glfw.KeyKP7, glfw.KeyKP8, glfw.KeyKP9,
+	glfw.KeyKP4, glfw.KeyKP5, glfw.KeyKP6, glfw.KeyKP1,
+	glfw.KeyKP2, glfw.KeyKP3, glfw.KeyKP0, glfw.KeyKPEnter,
+	glfw.KeyKPDivide, glfw.KeyKPMultiply, glfw.KeyKPSubtract, glfw.KeyKPAdd,
+}
+
+// Host keys for game buttons of ROM database
+var input_buttons = map[string]glfw.Key{
+	"up":           glfw.KeyUp,
+	"down":         glfw.KeyDown,
+	"left":         glfw.KeyLeft,
+	"right":        glfw.KeyRight,
+	"a":            glfw.KeySpace,
+	"b":            glfw.KeyEnter,
+	"player2Up":    glfw.KeyI,
+	"player2Down":  glfw.KeyK,
+	"player2Left":  glfw.KeyJ,
+	"player2Right": glfw.KeyL,
+	"player2A":     glfw.KeyU,
+	"player2B":     glfw.KeyO,
 }
 
 func (input *CHIP8Input) init(window *glfw.Window) {
-	input.keys = -255
+	input.keys = 0
+	input.keys2 = 0
+	input.held = 0
 	input.window = window
+	input.keymap = make(map[glfw.Key]uint8)
+	for key, host := range input_keymap {
+		input.keymap[host] = uint8(key)
+	}
+	input.keymap2 = make(map[glfw.Key]uint8)
+	for key, host := range input_keymap2 {
+		input.keymap2[host] = uint8(key)
+	}
+}
+
+func (input *CHIP8Input) bind(host glfw.Key, key uint8) {
+	input.keymap[host] = key & 0xF
+}
+
+func (input *CHIP8Input) remap(key uint8, host glfw.Key) {
+	for h, k := range input.keymap {
+		if k == key&0xF {
+			delete(input.keymap, h)
+		}
+	}
+	input.bind(host, key)
 }
 
 func (input *CHIP8Input) is_pressed(key uint8) bool {
-	return (input.keys == int(key))
+	return key < 16 && input.keys&(1<<key) != 0
+}
+
+func (input *CHIP8Input) is_pressed2(key uint8) bool {
+	return key < 16 && input.keys2&(1<<key) != 0
+}
+
+func (input *CHIP8Input) set_keys(keys uint16) {
+	input.keys = keys
+}
+
+func (input *CHIP8Input) pressed() uint16 {
+	return input.keys
+}
+
+func (input *CHIP8Input) hold(keys uint16) {
+	input.held = keys
+	if input.window == nil {
+		input.keys = keys
+	}
+}
+
+func (input *CHIP8Input) set_keys2(keys uint16) {
+	input.keys2 = keys
 }
 
 func (input *CHIP8Input) tick() {
-	input.keys = -255
-	if input.window.GetKey(glfw.Key1) == glfw.Press {
-		input.keys = 0x1
-	} else if input.window.GetKey(glfw.Key2) == glfw.Press {
-		input.keys = 0x2
-	} else if input.window.GetKey(glfw.Key3) == glfw.Press {
-		input.keys = 0x3
-	} else if input.window.GetKey(glfw.Key4) == glfw.Press {
-		input.keys = 0xC
-	} else if input.window.GetKey(glfw.KeyQ) == glfw.Press {
-		input.keys = 0x4
-	} else if input.window.GetKey(glfw.KeyW) == glfw.Press {
-		input.keys = 0x5
-	} else if input.window.GetKey(glfw.KeyE) == glfw.Press {
-		input.keys = 0x6
-	} else if input.window.GetKey(glfw.KeyR) == glfw.Press {
-		input.keys = 0xD
-	} else if input.window.GetKey(glfw.KeyA) == glfw.Press {
-		input.keys = 0x7
-	} else if input.window.GetKey(glfw.KeyS) == glfw.Press {
-		input.keys = 0x8
-	} else if input.window.GetKey(glfw.KeyD) == glfw.Press {
-		input.keys = 0x9
-	} else if input.window.GetKey(glfw.KeyF) == glfw.Press {
-		input.keys = 0xE
-	} else if input.window.GetKey(glfw.KeyZ) == glfw.Press {
-		input.keys = 0xA
-	} else if input.window.GetKey(glfw.KeyX) == glfw.Press {
-		input.keys = 0x0
-	} else if input.window.GetKey(glfw.KeyC) == glfw.Press {
-		input.keys = 0xB
-	} else if input.window.GetKey(glfw.KeyV) == glfw.Press {
-		input.keys = 0xF
+	if input.window == nil {
+		return
+	}
+	input.keys = input.held
+	for host, key := range input.keymap {
+		if input.window.GetKey(host) == glfw.Press {
+			input.keys |= 1 << key
+		}
+	}
+	input.keys2 = 0
+	for host, key := range input.keymap2 {
+		if input.window.GetKey(host) == glfw.Press {
+			input.keys2 |= 1 << key
+		}
 	}
 }
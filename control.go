@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-gl/glfw/v3.1/glfw"
+	"path/filepath"
+	"strings"
+)
+
+// Host keys controlling emulation. Fast-forward runs while its key is held
+const (
+	key_pause        = glfw.KeyP
+	key_frame        = glfw.KeyN
+	key_step         = glfw.KeyM
+	key_slower       = glfw.KeyMinus
+	key_faster       = glfw.KeyEqual
+	key_fast_forward = glfw.KeyTab
+	key_soft_reset   = glfw.KeyF5
+	key_hard_reset   = glfw.KeyF6
+	key_export       = glfw.KeyF9
+)
+
+// Limits of set_speed. Hotkeys halve and double speed between them
+const (
+	speed_min = 0.25
+	speed_max = 8.0
+)
+
+// Handles control hotkeys of window
+func (console *CHIP8Console) control() {
+	if console.key_hit(key_pause) {
+		console.set_paused(!console.paused)
+	}
+	if console.key_hit(key_frame) {
+		console.advance_frame()
+	}
+	if console.key_hit(key_step) {
+		console.step()
+	}
+	if console.key_hit(key_slower) {
+		console.set_speed(console.speed / 2)
+	}
+	if console.key_hit(key_faster) {
+		console.set_speed(console.speed * 2)
+	}
+	if console.key_hit(key_soft_reset) {
+		console.soft_reset()
+	}
+	if console.key_hit(key_hard_reset) {
+		console.hard_reset()
+	}
+	if console.key_hit(key_export) {
+		console.export_snapshot()
+	}
+	console.set_fast_forward(console.window.GetKey(key_fast_forward) == glfw.Press)
+}
+
+// Reports key going down since last check
+func (console *CHIP8Console) key_hit(key glfw.Key) bool {
+	down := console.window.GetKey(key) == glfw.Press
+	hit := down && !console.keys_down[key]
+	console.keys_down[key] = down
+	return hit
+}
+
+func (console *CHIP8Console) set_paused(on bool) {
+	console.paused = on
+}
+
+// Pauses emulation and runs exactly one frame
+func (console *CHIP8Console) advance_frame() {
+	console.paused = true
+	console.frame()
+}
+
+// Pauses emulation and runs one instruction: CHIP-8 one, or CDP1802 one
+// of booted interpreter. Timers don't run between steps
+func (console *CHIP8Console) step() {
+	console.paused = true
+	console.input.tick()
+	if console.interpreter != "" {
+		console.vip.cpu.step()
+	} else {
+		console.tick()
+	}
+	console.gpu.render()
+}
+
+// Speed is clamped to speed_min-speed_max
+func (console *CHIP8Console) set_speed(speed float64) {
+	if speed < speed_min {
+		speed = speed_min
+	}
+	if speed > speed_max {
+		speed = speed_max
+	}
+	console.speed = speed
+}
+
+func (console *CHIP8Console) set_fast_forward(on bool) {
+	console.fast_forward = on
+}
+
+// Resets CPU only: program restarts with memory as it is. Display mode
+// belongs to interpreter, so changed resolution is reset too
+func (console *CHIP8Console) soft_reset() {
+	console.cpu.init()
+	console.apply_cpu_settings()
+	spec := platform_spec(console.settings.platform)
+	if w, h := console.gpu.size(); w != spec.width || h != spec.height {
+		console.gpu.set_size(spec.width, spec.height)
+	}
+	if console.interpreter != "" {
+		console.vip.boot()
+	}
+}
+
+// Reloads ROM from disk into cleared memory and resets everything.
+// Emulation goes on with old memory if ROM can't be read
+func (console *CHIP8Console) hard_reset() {
+	file, err := load_rom_file(console.rom_path, console.rom_entry, console.patches)
+	if err == nil {
+		err = console.check_boot(file.data)
+	}
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return
+	}
+	console.rom = file.data
+	console.rom_addr = file.addr
+	console.reset(console.rom)
+}
+
+// Writes whole memory to Intel HEX file next to ROM
+func (console *CHIP8Console) export_snapshot() {
+	path := strings.TrimSuffix(console.rom_path, filepath.Ext(console.rom_path)) + "-memory.hex"
+	if err := export_memory(path, console.mem, 0, 0); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return
+	}
+	fmt.Printf("Memory is written to %s\n", path)
+}
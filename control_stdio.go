@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"math"
+)
+
+// Control protocol of run -control stdio: JSON request per line in, JSON answer
+// per line out. Answers echo id of request and have ok set, or error:
+//
+//	{"id": 1, "cmd": "press", "key": 5}               keep CHIP-8 key pressed
+//	{"cmd": "release", "key": 5}
+//	{"cmd": "step", "count": 1}                       run instructions, emulation pauses
+//	{"cmd": "frames", "count": 60}                    run frames, emulation pauses
+//	{"cmd": "peek", "addr": 512, "len": 2}            answer has "data": [18, 0]
+//	{"cmd": "poke", "addr": 512, "data": [18, 0]}
+//	{"cmd": "export", "path": "mem.hex", "addr": 512, "len": 256}
+//	                                                  Intel HEX or S-record (.srec) image, without len up to end
+//	{"cmd": "regs"}                                   answer has "regs": {"v": [...], "i", "pc", "sp", "dt", "st"}
+//	{"cmd": "screenshot", "path": "screen.png"}       without path answer has "png": base64 of image
+//	{"cmd": "save_state", "path": "game.state"}       without path state is kept in memory
+//	{"cmd": "load_state", "path": "game.state"}
+//	{"cmd": "search", "op": "start"}                  cheat search, answer has "count" and first "addrs"
+//	{"cmd": "search", "op": "decreased"}              also equal, changed, increased
+//	{"cmd": "search", "op": "value", "value": 4}
+//	{"cmd": "freeze", "addr": 756, "value": 9, "name": "Lives"}
+//	{"cmd": "unfreeze", "addr": 756}
+//	{"cmd": "cheats"}                                 answer has "cheats"
+//	{"cmd": "save_cheats"}                            to cheats file of ROM, or path
+//
+// Events are written between answers as they happen:
+//
+//	{"event": "sound", "on": true}
+//	{"event": "halted"}                               program jumps to itself or has exited
+type CHIP8Control struct {
+	console  *CHIP8Console
+	out      *json.Encoder
+	requests chan []byte // Lines of input, closed at end of input
+	keys     uint16      // Keys pressed by requests
+	saved    *CHIP8State // State saved without path
+	search   CHIP8CheatSearch
+	beep     bool
+	halted   bool
+}
+
+type control_request struct {
+	ID    json.RawMessage `json:"id"`
+	Cmd   string          `json:"cmd"`
+	Key   int             `json:"key"`
+	Count int             `json:"count"`
+	Addr  uint32          `json:"addr"`
+	Len   int             `json:"len"`
+	Data  []int           `json:"data"`
+	Path  string          `json:"path"`
+	Op    string          `json:"op"`
+	Value int             `json:"value"`
+	Name  string          `json:"name"`
+}
+
+type control_answer struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	OK     bool            `json:"ok"`
+	Error  string          `json:"error,omitempty"`
+	Data   []int           `json:"data,omitempty"`
+	Regs   *control_regs   `json:"regs,omitempty"`
+	PNG    string          `json:"png,omitempty"`
+	Count  *int            `json:"count,omitempty"`
+	Addrs  []uint32        `json:"addrs,omitempty"`
+	Cheats []CHIP8Cheat    `json:"cheats,omitempty"`
+}
+
+const control_addrs_max = 256 // Addresses of cheat search in answer
+
+type control_regs struct {
+	V  []int  `json:"v"`
+	I  uint32 `json:"i"`
+	PC uint16 `json:"pc"`
+	SP uint16 `json:"sp"`
+	DT uint8  `json:"dt"`
+	ST uint8  `json:"st"`
+}
+
+type control_event struct {
+	Event string `json:"event"`
+	On    *bool  `json:"on,omitempty"`
+}
+
+// Starts reading requests of r in background, answers go to w
+func new_control(console *CHIP8Console, r io.Reader, w io.Writer) *CHIP8Control {
+	control := &CHIP8Control{console: console, out: json.NewEncoder(w), requests: make(chan []byte, 16)}
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) > 0 {
+				control.requests <- append([]byte(nil), line...)
+			}
+		}
+		close(control.requests)
+	}()
+	return control
+}
+
+// Handles requests that have come, without waiting. Used by window loop
+func (control *CHIP8Control) poll() {
+	for {
+		select {
+		case line, ok := <-control.requests:
+			if !ok {
+				return
+			}
+			control.handle(line)
+		default:
+			return
+		}
+	}
+}
+
+// Handles requests until end of input. Used without window, emulation runs
+// only by requests
+func (control *CHIP8Control) serve() {
+	for line := range control.requests {
+		control.handle(line)
+	}
+}
+
+func (control *CHIP8Control) handle(line []byte) {
+	var req control_request
+	answer := &control_answer{}
+	if err := json.Unmarshal(line, &req); err != nil {
+		answer.Error = "bad request: " + err.Error()
+	} else {
+		answer.ID = req.ID
+		if err = control.run(&req, answer); err != nil {
+			answer.Error = err.Error()
+		} else {
+			answer.OK = true
+		}
+	}
+	control.out.Encode(answer)
+	control.events()
+}
+
+func (control *CHIP8Control) run(req *control_request, answer *control_answer) error {
+	console := control.console
+	switch req.Cmd {
+	case "press", "release":
+		if req.Key < 0 || req.Key > 0xF {
+			return fmt.Errorf("key %d is not 0-15", req.Key)
+		}
+		if req.Cmd == "press" {
+			control.keys |= 1 << uint(req.Key)
+		} else {
+			control.keys &^= 1 << uint(req.Key)
+		}
+		console.input.hold(control.keys)
+	case "step":
+		for i := 0; i < request_count(req.Count); i++ {
+			console.step()
+		}
+	case "frames":
+		for i := 0; i < request_count(req.Count); i++ {
+			console.advance_frame()
+		}
+	case "peek":
+		if req.Len < 0 || uint32(req.Len) > console.mem.size() {
+			return fmt.Errorf("bad length %d", req.Len)
+		}
+		answer.Data = make([]int, request_count(req.Len))
+		for i := range answer.Data {
+			answer.Data[i] = int(console.mem.read(req.Addr + uint32(i)))
+		}
+	case "poke":
+		for i, b := range req.Data {
+			if b < 0 || b > 0xFF {
+				return fmt.Errorf("byte %d is not 0-255", b)
+			}
+			console.mem.write(req.Addr+uint32(i), uint8(b))
+		}
+	case "export":
+		if req.Len < 0 || int64(req.Len) > math.MaxUint32 || req.Path == "" {
+			return fmt.Errorf("export needs path and length of 0-%d", uint32(math.MaxUint32))
+		}
+		return export_memory(req.Path, console.mem, req.Addr, uint32(req.Len))
+	case "regs":
+		state := &CHIP8State{}
+		console.cpu.save_state(state)
+		cpu := &state.CPU
+		regs := &control_regs{I: cpu.I, PC: cpu.PC, SP: cpu.SP, DT: cpu.DT, ST: cpu.ST}
+		for _, v := range cpu.V {
+			regs.V = append(regs.V, int(v))
+		}
+		answer.Regs = regs
+	case "screenshot":
+		if req.Path != "" {
+			return write_png(req.Path, screenshot(console.gpu))
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, screenshot(console.gpu)); err != nil {
+			return err
+		}
+		answer.PNG = base64.StdEncoding.EncodeToString(buf.Bytes())
+	case "save_state":
+		state, err := console.save_state()
+		if err != nil {
+			return err
+		}
+		if req.Path != "" {
+			return write_state(req.Path, state)
+		}
+		control.saved = state
+	case "load_state":
+		state := control.saved
+		if req.Path != "" {
+			var err error
+			if state, err = read_state(req.Path); err != nil {
+				return err
+			}
+		} else if state == nil {
+			return fmt.Errorf("no state is saved")
+		}
+		return console.load_state(state)
+	case "search":
+		if req.Op == "start" {
+			control.search.start(console.mem)
+		} else if req.Value < 0 || req.Value > 0xFF {
+			return fmt.Errorf("value %d is not 0-255", req.Value)
+		} else if err := control.search.filter(console.mem, req.Op, uint8(req.Value)); err != nil {
+			return err
+		}
+		count := len(control.search.candidates)
+		answer.Count = &count
+		answer.Addrs = control.search.candidates
+		if count > control_addrs_max {
+			answer.Addrs = answer.Addrs[:control_addrs_max]
+		}
+	case "freeze":
+		if req.Value < 0 || req.Value > 0xFF {
+			return fmt.Errorf("value %d is not 0-255", req.Value)
+		}
+		if console.cheats == nil {
+			console.cheats = &CHIP8Cheats{ROM: console.rom_hash}
+		}
+		console.cheats.freeze(req.Addr, uint8(req.Value), req.Name)
+	case "unfreeze":
+		if console.cheats == nil || !console.cheats.unfreeze(req.Addr) {
+			return fmt.Errorf("address %d isn't frozen", req.Addr)
+		}
+	case "cheats":
+		if console.cheats != nil {
+			answer.Cheats = console.cheats.Cheats
+		}
+	case "save_cheats":
+		if console.cheats == nil {
+			return fmt.Errorf("no cheats are set")
+		}
+		path := req.Path
+		if path == "" {
+			path = cheats_path(console.rom_hash)
+		}
+		return write_cheats(path, console.cheats)
+	default:
+		return fmt.Errorf("unknown command %q", req.Cmd)
+	}
+	return nil
+}
+
+// Count of request, 1 if it's not given
+func request_count(n int) int {
+	if n <= 0 {
+		return 1
+	}
+	return n
+}
+
+// Writes events of changes since last check
+func (control *CHIP8Control) events() {
+	console := control.console
+	if beep := console.sound.beeping(); beep != control.beep {
+		control.beep = beep
+		control.out.Encode(&control_event{Event: "sound", On: &beep})
+	}
+	halted := console.interpreter == "" && console.cpu.halted(console)
+	if halted && !control.halted {
+		control.out.Encode(&control_event{Event: "halted"})
+	}
+	control.halted = halted
+}
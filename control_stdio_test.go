@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Runs requests on console without window, returns answers and events by line
+func run_control(t *testing.T, console *CHIP8Console, requests string) []map[string]interface{} {
+	var out bytes.Buffer
+	new_control(console, strings.NewReader(requests), &out).serve()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		msg := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("%s: %s", line, err.Error())
+		}
+		lines = append(lines, msg)
+	}
+	return lines
+}
+
+func TestControlMemoryAndRegisters(t *testing.T) {
+	console := new_rom_console(t, counter_rom, "")
+	lines := run_control(t, console, `{"id": 1, "cmd": "step", "count": 3}
+{"id": 2, "cmd": "regs"}
+{"id": 3, "cmd": "poke", "addr": 513, "data": [5]}
+{"id": 4, "cmd": "peek", "addr": 512, "len": 2}
+{"id": "x", "cmd": "nope"}
+not json
+`)
+	if len(lines) != 6 {
+		t.Fatalf("answers are %v", lines)
+	}
+	for _, line := range lines[:4] {
+		if line["ok"] != true {
+			t.Errorf("answer is %v", line)
+		}
+	}
+	regs := lines[1]["regs"].(map[string]interface{})
+	if regs["pc"] != float64(0x202) || regs["v"].([]interface{})[0] != 2.0 {
+		t.Errorf("registers after 3 steps are %v", regs)
+	}
+	if data := lines[3]["data"].([]interface{}); data[0] != 112.0 || data[1] != 5.0 {
+		t.Errorf("peek after poke is %v", data)
+	}
+	if lines[4]["id"] != "x" || lines[4]["ok"] != false || lines[4]["error"] == nil {
+		t.Errorf("answer of unknown command is %v", lines[4])
+	}
+	if lines[5]["ok"] != false {
+		t.Errorf("answer of bad JSON is %v", lines[5])
+	}
+}
+
+// Key 5 draws its digit and halts, beeping
+func TestControlKeysAndEvents(t *testing.T) {
+	rom := append([]uint8(nil), key_rom[:10]...)
+	rom = append(rom, 0xF0, 0x18, 0x12, 0x0C) // LD ST, V0; JP 20C
+	console := new_rom_console(t, rom, "")
+	lines := run_control(t, console, `{"cmd": "frames"}
+{"cmd": "press", "key": 5}
+{"cmd": "frames", "count": 2}
+{"cmd": "screenshot"}
+{"cmd": "release", "key": 16}
+`)
+	var events []string
+	for _, line := range lines {
+		if event, ok := line["event"].(string); ok {
+			events = append(events, event)
+		}
+	}
+	if strings.Join(events, " ") != "sound halted" {
+		t.Errorf("events are %v", events)
+	}
+	if console.gpu.pixel(0, 0) == 0 {
+		t.Errorf("key 5 is not pressed")
+	}
+	last := lines[len(lines)-1]
+	if last["ok"] != false {
+		t.Errorf("key 16 is released: %v", last)
+	}
+	if png, _ := lines[len(lines)-2]["png"].(string); !strings.HasPrefix(png, "iVBOR") {
+		t.Errorf("screenshot is %.20s", png)
+	}
+}
+
+func TestControlStates(t *testing.T) {
+	console := new_rom_console(t, counter_rom, "")
+	path := filepath.Join(t.TempDir(), "counter.state")
+	lines := run_control(t, console, `{"cmd": "load_state"}
+{"cmd": "step", "count": 2}
+{"cmd": "save_state"}
+{"cmd": "save_state", "path": "`+filepath.ToSlash(path)+`"}
+{"cmd": "step", "count": 4}
+{"cmd": "load_state"}
+`)
+	if lines[0]["ok"] != false {
+		t.Errorf("loading without saved state is %v", lines[0])
+	}
+	cpu := console.cpu.(*CHIP8CPU)
+	expect_v(t, cpu, 0, 1)
+	expect_pc(t, cpu, 0x200)
+
+	console.step()
+	state, err := read_state(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = console.load_state(state); err != nil {
+		t.Fatal(err)
+	}
+	expect_v(t, cpu, 0, 1)
+
+	other := new_rom_console(t, []uint8{0x12, 0x00}, "")
+	if err = other.load_state(state); err == nil {
+		t.Errorf("state of other ROM is loaded")
+	}
+}
+
+// Screen, resolution and memory of MegaChip go back with state
+func TestStateMegaChip(t *testing.T) {
+	console := new_rom_console(t, []uint8{0x00, 0x11}, "megachip8") // MEGAON
+	console.tick()
+	console.gpu.draw_mega(10, 10, 3)
+	console.mem.write(0x10000, 0xAB)
+	state, err := console.save_state()
+	if err != nil {
+		t.Fatal(err)
+	}
+	console.soft_reset()
+	console.mem.write(0x10000, 0)
+	if err = console.load_state(state); err != nil {
+		t.Fatal(err)
+	}
+	if w, h := console.gpu.size(); w != 256 || h != 192 || console.gpu.pixel(10, 10) != 3 {
+		t.Errorf("screen is %dx%d, pixel %d", w, h, console.gpu.pixel(10, 10))
+	}
+	if console.mem.read(0x10000) != 0xAB {
+		t.Errorf("memory is not loaded")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// Counts up V0 forever
+var counter_rom = []uint8{
+	0x70, 0x01, // ADD V0, 1
+	0x12, 0x00, // JP 200
+}
+
+func TestStepAndFrameAdvance(t *testing.T) {
+	console := new_rom_console(t, counter_rom, "")
+	cpu := console.cpu.(*CHIP8CPU)
+	console.step()
+	if !console.paused {
+		t.Errorf("step doesn't pause")
+	}
+	expect_v(t, cpu, 0, 1)
+	expect_pc(t, cpu, 0x202)
+	console.step()
+	expect_pc(t, cpu, 0x200)
+
+	console.set_paused(false)
+	console.advance_frame()
+	if !console.paused {
+		t.Errorf("frame advance doesn't pause")
+	}
+	expect_v(t, cpu, 0, Registr(1+(console.settings.cycles_per_frame+1)/2)) // Every other instruction adds
+}
+
+func TestSpeedLimits(t *testing.T) {
+	console := new_rom_console(t, counter_rom, "")
+	if console.speed != 1 {
+		t.Errorf("speed is %v, want 1", console.speed)
+	}
+	console.set_speed(100)
+	if console.speed != speed_max {
+		t.Errorf("speed is %v, want %v", console.speed, speed_max)
+	}
+	console.set_speed(0)
+	if console.speed != speed_min {
+		t.Errorf("speed is %v, want %v", console.speed, speed_min)
+	}
+}
+
+// Soft reset restarts program with memory kept, hard reset reloads ROM
+func TestReset(t *testing.T) {
+	console := new_rom_console(t, counter_rom, "")
+	cpu := console.cpu.(*CHIP8CPU)
+	console.step()
+	console.mem.write(0x201, 0x05) // ADD V0, 5
+	console.mem.write(0x300, 0xAA)
+	console.soft_reset()
+	expect_pc(t, cpu, 0x200)
+	expect_v(t, cpu, 0, 0)
+	console.step()
+	expect_v(t, cpu, 0, 5)
+
+	if err := ioutil.WriteFile(console.rom_path, []uint8{0x70, 0x07}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	console.hard_reset()
+	expect_pc(t, cpu, 0x200)
+	if console.mem.read(0x300) != 0 {
+		t.Errorf("memory is not cleared")
+	}
+	console.step()
+	expect_v(t, cpu, 0, 7)
+}
+
+func TestHardResetKeepsRunningWithoutROM(t *testing.T) {
+	console := new_rom_console(t, counter_rom, "")
+	quiet(t)
+	console.step()
+	console.rom_path = "missing.ch8"
+	console.hard_reset()
+	expect_pc(t, console.cpu.(*CHIP8CPU), 0x202)
+}
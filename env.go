@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Gym-style environment for training agents on a game. Console runs headlessly
+// and never renders; agent sees framebuffer bits and gets reward from score in
+// game memory.
+type CHIP8Env struct {
+	console *CHIP8Console
+	rom     []uint8
+	config  *CHIP8EnvConfig
+	score   float64 // Score at last step
+	frames  int     // Frames since reset
+}
+
+type CHIP8EnvConfig struct {
+	platform_id string        // ROM database platform, empty is found or detected one
+	rom_entry   string        // Program of zip archive, set by first environment so others don't ask
+	boot        CHIP8Boot     // Load address and entry, platform's unless given
+	scores      []CHIP8Score  // Reward is change of their weighted sum
+	done        []CHIP8EnvEnd // Episode ends when any of them holds
+	max_frames  int           // Episode ends after this many frames, 0 is no limit
+}
+
+// Value in memory counted into reward
+type CHIP8Score struct {
+	addr   uint32
+	bytes  int     // Big endian value, or digits with bcd
+	bcd    bool    // Byte per decimal digit, the way FX33 stores numbers
+	weight float64 // Negative for things like lost lives
+}
+
+// Episode end when byte at addr is value
+type CHIP8EnvEnd struct {
+	addr  uint32
+	value uint8
+}
+
+func new_env(rom_path string, config *CHIP8EnvConfig) (*CHIP8Env, error) {
+	console := &CHIP8Console{headless: true, platform_id: config.platform_id, rom_entry: config.rom_entry, boot: config.boot}
+	if err := console.init(rom_path); err != nil {
+		return nil, err
+	}
+	config.rom_entry = console.rom_entry
+	return &CHIP8Env{console: console, rom: console.rom, config: config}, nil
+}
+
+// Starts new episode from power-on state. Seed makes random numbers of the
+// episode repeatable. Returns first observation
+func (env *CHIP8Env) Reset(seed int64) []uint8 {
+	env.console.rng = new_random(seed)
+	env.console.reset(env.rom)
+	env.console.input.set_keys(0)
+	env.frames = 0
+	env.score = env.read_score()
+	return env.observe()
+}
+
+// Runs frames with keys of mask held. Returns observation after them, reward
+// earned by them and whether episode has ended
+func (env *CHIP8Env) Step(keys uint16, frames int) (obs []uint8, reward float64, done bool) {
+	env.console.input.set_keys(keys)
+	for i := 0; i < frames && !done; i++ {
+		env.console.emulate_frame()
+		env.frames++
+		done = env.done()
+	}
+	score := env.read_score()
+	reward, env.score = score-env.score, score
+	return env.observe(), reward, done
+}
+
+// Display size of observations, it changes with resolution switching of program
+func (env *CHIP8Env) size() (w, h int) {
+	return env.console.gpu.size()
+}
+
+// Framebuffer bits by rows, most significant bit of byte is on the left.
+// Rows take w/8 bytes rounded up
+func (env *CHIP8Env) observe() []uint8 {
+	gpu := env.console.gpu
+	w, h := gpu.size()
+	stride := (w + 7) / 8
+	obs := make([]uint8, stride*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if gpu.pixel(x, y) != 0 {
+				obs[y*stride+x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+	}
+	return obs
+}
+
+func (env *CHIP8Env) read_score() float64 {
+	total := 0.0
+	for _, score := range env.config.scores {
+		value := 0
+		for i := 0; i < score.bytes; i++ {
+			b := int(env.console.mem.read(score.addr + uint32(i)))
+			if score.bcd {
+				value = value*10 + b
+			} else {
+				value = value<<8 | b
+			}
+		}
+		total += float64(value) * score.weight
+	}
+	return total
+}
+
+func (env *CHIP8Env) done() bool {
+	if env.config.max_frames > 0 && env.frames >= env.config.max_frames {
+		return true
+	}
+	for _, end := range env.config.done {
+		if env.console.mem.read(end.addr) == end.value {
+			return true
+		}
+	}
+	return env.console.cpu.halted(env.console)
+}
+
+// Independent environments of one game, stepped in parallel
+type CHIP8EnvBatch struct {
+	envs []*CHIP8Env
+}
+
+// Result of step of one environment of batch
+type CHIP8EnvStep struct {
+	obs    []uint8
+	reward float64
+	done   bool
+}
+
+func new_env_batch(rom_path string, config *CHIP8EnvConfig, n int) (*CHIP8EnvBatch, error) {
+	batch := &CHIP8EnvBatch{}
+	for i := 0; i < n; i++ {
+		env, err := new_env(rom_path, config)
+		if err != nil {
+			return nil, err
+		}
+		batch.envs = append(batch.envs, env)
+	}
+	return batch, nil
+}
+
+// Resets environment i with seeds[i]
+func (batch *CHIP8EnvBatch) Reset(seeds []int64) [][]uint8 {
+	obs := make([][]uint8, len(batch.envs))
+	batch.parallel(func(i int, env *CHIP8Env) {
+		obs[i] = env.Reset(seeds[i])
+	})
+	return obs
+}
+
+// Steps environment i with keys[i]
+func (batch *CHIP8EnvBatch) Step(keys []uint16, frames int) []CHIP8EnvStep {
+	steps := make([]CHIP8EnvStep, len(batch.envs))
+	batch.parallel(func(i int, env *CHIP8Env) {
+		step := &steps[i]
+		step.obs, step.reward, step.done = env.Step(keys[i], frames)
+	})
+	return steps
+}
+
+func (batch *CHIP8EnvBatch) parallel(run func(i int, env *CHIP8Env)) {
+	var wg sync.WaitGroup
+	for i, env := range batch.envs {
+		wg.Add(1)
+		go func(i int, env *CHIP8Env) {
+			defer wg.Done()
+			run(i, env)
+		}(i, env)
+	}
+	wg.Wait()
+}
+
+// Parses scores of command line: ADDR[:BYTES][:bcd][*WEIGHT], comma separated.
+// 0x2F0:3:bcd is three score digits, 0x300*-10 is lives
+func parse_scores(text string) ([]CHIP8Score, error) {
+	var scores []CHIP8Score
+	for _, term := range strings.Split(text, ",") {
+		if term == "" {
+			continue
+		}
+		score := CHIP8Score{bytes: 1, weight: 1}
+		var err error
+		if i := strings.Index(term, "*"); i >= 0 {
+			if score.weight, err = strconv.ParseFloat(term[i+1:], 64); err != nil {
+				return nil, fmt.Errorf("bad weight of score %s", term)
+			}
+			term = term[:i]
+		}
+		parts := strings.Split(term, ":")
+		addr, err := strconv.ParseUint(parts[0], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad address of score %s", term)
+		}
+		score.addr = uint32(addr)
+		for _, part := range parts[1:] {
+			if part == "bcd" {
+				score.bcd = true
+			} else if score.bytes, err = strconv.Atoi(part); err != nil || score.bytes < 1 || score.bytes > 4 {
+				return nil, fmt.Errorf("bad size of score %s, 1-4 bytes", term)
+			}
+		}
+		scores = append(scores, score)
+	}
+	return scores, nil
+}
+
+// Parses episode ends of command line: ADDR=VALUE, comma separated
+func parse_env_ends(text string) ([]CHIP8EnvEnd, error) {
+	var ends []CHIP8EnvEnd
+	for _, term := range strings.Split(text, ",") {
+		if term == "" {
+			continue
+		}
+		parts := strings.Split(term, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad episode end %s, ADDR=VALUE is expected", term)
+		}
+		addr, err := strconv.ParseUint(parts[0], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad address of episode end %s", term)
+		}
+		value, err := strconv.ParseUint(parts[1], 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad value of episode end %s", term)
+		}
+		ends = append(ends, CHIP8EnvEnd{addr: uint32(addr), value: uint8(value)})
+	}
+	return ends, nil
+}
+
+// Request of gym wrapper, one JSON object per line:
+//
+//	{"cmd": "reset", "seeds": [1, 2]}
+//	{"cmd": "step", "keys": [32, 0], "frames": 4}
+//
+// Answer has result per environment, observation is base64 of framebuffer bits:
+//
+//	{"envs": [{"obs": "...", "width": 64, "height": 32, "reward": 1, "done": false}, ...]}
+//	{"error": "..."}
+type env_request struct {
+	Cmd    string   `json:"cmd"`
+	Seeds  []int64  `json:"seeds"`
+	Keys   []uint16 `json:"keys"`
+	Frames int      `json:"frames"`
+}
+
+type env_result struct {
+	Obs    string  `json:"obs"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Reward float64 `json:"reward"`
+	Done   bool    `json:"done"`
+}
+
+type env_answer struct {
+	Envs  []env_result `json:"envs,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// Serves requests of r until it ends, answering to w
+func (batch *CHIP8EnvBatch) serve(r io.Reader, w io.Writer) error {
+	decoder := json.NewDecoder(r)
+	encoder := json.NewEncoder(w)
+	for {
+		var req env_request
+		if err := decoder.Decode(&req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := encoder.Encode(batch.answer(&req)); err != nil {
+			return err
+		}
+	}
+}
+
+func (batch *CHIP8EnvBatch) answer(req *env_request) *env_answer {
+	n := len(batch.envs)
+	steps := make([]CHIP8EnvStep, n)
+	switch req.Cmd {
+	case "reset":
+		if len(req.Seeds) != n {
+			return &env_answer{Error: fmt.Sprintf("%d seeds for %d environments", len(req.Seeds), n)}
+		}
+		for i, obs := range batch.Reset(req.Seeds) {
+			steps[i].obs = obs
+		}
+	case "step":
+		if len(req.Keys) != n {
+			return &env_answer{Error: fmt.Sprintf("%d key masks for %d environments", len(req.Keys), n)}
+		}
+		frames := req.Frames
+		if frames == 0 {
+			frames = 1
+		}
+		steps = batch.Step(req.Keys, frames)
+	default:
+		return &env_answer{Error: fmt.Sprintf("unknown command %q", req.Cmd)}
+	}
+	answer := &env_answer{}
+	for i, step := range steps {
+		w, h := batch.envs[i].size()
+		answer.Envs = append(answer.Envs, env_result{
+			Obs:    base64.StdEncoding.EncodeToString(step.obs),
+			Width:  w,
+			Height: h,
+			Reward: step.reward,
+			Done:   step.done,
+		})
+	}
+	return answer
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Counts V1 up to 5 into BCD score at 300, then stores random byte at 310 and halts
+var score_rom = []uint8{
+	0x71, 0x01, // ADD V1, 1
+	0xA3, 0x00, // LD I, 300
+	0xF1, 0x33, // LD B, V1
+	0x31, 0x05, // SE V1, 5
+	0x12, 0x00, // JP 200
+	0xC0, 0xFF, // RND V0, FF
+	0xA3, 0x10, // LD I, 310
+	0xF0, 0x55, // LD [I], V0
+	0x12, 0x10, // JP 210
+}
+
+func new_test_env(t *testing.T, config *CHIP8EnvConfig) *CHIP8Env {
+	env, err := new_env(write_test_rom(t, "score.ch8", score_rom), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.console.settings.cycles_per_frame = 5 // Loop of counting takes one frame
+	return env
+}
+
+func TestEnvReward(t *testing.T) {
+	scores, _ := parse_scores("0x300:3:bcd")
+	env := new_test_env(t, &CHIP8EnvConfig{scores: scores})
+	if obs := env.Reset(1); len(obs) != 8*32 {
+		t.Errorf("observation has %d bytes", len(obs))
+	}
+	_, reward, done := env.Step(0, 2)
+	if reward != 2 || done {
+		t.Errorf("reward %v, done %v after 2 frames", reward, done)
+	}
+	_, reward, done = env.Step(0, 100)
+	if reward != 3 || !done {
+		t.Errorf("reward %v, done %v at halt", reward, done)
+	}
+
+	env.Reset(1) // Score starts again
+	if _, reward, _ = env.Step(0, 1); reward != 1 {
+		t.Errorf("reward %v after reset", reward)
+	}
+}
+
+func TestEnvDone(t *testing.T) {
+	ends, _ := parse_env_ends("0x302=3")
+	env := new_test_env(t, &CHIP8EnvConfig{done: ends})
+	env.Reset(0)
+	if _, _, done := env.Step(0, 100); !done || env.frames != 3 {
+		t.Errorf("done %v after %d frames, want end at frame 3", done, env.frames)
+	}
+
+	env = new_test_env(t, &CHIP8EnvConfig{max_frames: 2})
+	env.Reset(0)
+	if _, _, done := env.Step(0, 100); !done || env.frames != 2 {
+		t.Errorf("done %v after %d frames, want end at frame 2", done, env.frames)
+	}
+}
+
+func TestEnvObservation(t *testing.T) {
+	env := new_test_env(t, &CHIP8EnvConfig{})
+	env.Reset(0)
+	env.console.gpu.set_pixel(9, 1, 1)
+	obs := env.observe()
+	if obs[8+1] != 0x40 || bytes.Count(obs, []uint8{0}) != len(obs)-1 {
+		t.Errorf("observation of pixel 9,1 is % X", obs[:16])
+	}
+}
+
+// Same seed gives same random numbers, also when environments run in parallel
+func TestEnvBatch(t *testing.T) {
+	env := new_test_env(t, &CHIP8EnvConfig{})
+	batch := &CHIP8EnvBatch{}
+	for i := 0; i < 4; i++ {
+		e, _ := new_env(env.console.rom_path, env.config)
+		e.console.settings.cycles_per_frame = 5
+		batch.envs = append(batch.envs, e)
+	}
+	random := func() []uint8 {
+		var values []uint8
+		for _, e := range batch.envs {
+			values = append(values, e.console.mem.read(0x310))
+		}
+		return values
+	}
+	batch.Reset([]int64{1, 2, 1, 2})
+	steps := batch.Step([]uint16{0, 0, 0, 0}, 10)
+	first := random()
+	if !steps[0].done || first[0] != first[2] || first[1] != first[3] {
+		t.Errorf("random bytes of seeds 1, 2, 1, 2 are % X", first)
+	}
+	batch.Reset([]int64{1, 2, 1, 2})
+	batch.Step([]uint16{0, 0, 0, 0}, 10)
+	if again := random(); !bytes.Equal(first, again) {
+		t.Errorf("random bytes after reset are % X, want % X", again, first)
+	}
+
+	var out bytes.Buffer
+	in := `{"cmd": "reset", "seeds": [1, 2, 3, 4]}
+{"cmd": "step", "keys": [0, 0, 0, 0], "frames": 10}
+{"cmd": "step", "keys": [0]}
+`
+	if err := batch.serve(strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("answers are %s", out.String())
+	}
+	var answer env_answer
+	json.Unmarshal([]byte(lines[1]), &answer)
+	if len(answer.Envs) != 4 || !answer.Envs[3].Done || answer.Envs[0].Width != 64 {
+		t.Errorf("answer of step is %s", lines[1])
+	}
+	if !strings.Contains(lines[2], "error") {
+		t.Errorf("answer of bad step is %s", lines[2])
+	}
+}
+
+func TestParseScores(t *testing.T) {
+	scores, err := parse_scores("0x2F0:3:bcd,0x300*-10")
+	if err != nil || len(scores) != 2 {
+		t.Fatalf("scores are %+v, %v", scores, err)
+	}
+	if s := scores[0]; s.addr != 0x2F0 || s.bytes != 3 || !s.bcd || s.weight != 1 {
+		t.Errorf("BCD score is %+v", s)
+	}
+	if s := scores[1]; s.addr != 0x300 || s.bytes != 1 || s.bcd || s.weight != -10 {
+		t.Errorf("weighted score is %+v", s)
+	}
+	if _, err := parse_scores("0x300:9"); err == nil {
+		t.Errorf("9 byte score is accepted")
+	}
+	if _, err := parse_env_ends("0x300"); err == nil {
+		t.Errorf("end without value is accepted")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Hex digit font of interpreter: 16 small glyphs of 4x5 pixels for FX29, 5
+// bytes each, and big glyphs of 8x10 pixels for FX30, 10 bytes each
+type CHIP8Font struct {
+	small []uint8
+	big   []uint8 // Nil if interpreter has no big font. SCHIP has digits 0-9 only
+}
+
+const (
+	small_glyph_size = 5
+	big_glyph_size   = 10
+	default_font     = "octo" // Font chipigo always had
+)
+
+// Big font of platforms with FX30 when chosen font has none
+var octo_big_font = []uint8{
+	0xFF, 0xFF, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xFF, 0xFF, // 0
+	0x18, 0x78, 0x78, 0x18, 0x18, 0x18, 0x18, 0x18, 0xFF, 0xFF, // 1
+	0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, // 2
+	0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, // 3
+	0xC3, 0xC3, 0xC3, 0xC3, 0xFF, 0xFF, 0x03, 0x03, 0x03, 0x03, // 4
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, // 5
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, // 6
+	0xFF, 0xFF, 0x03, 0x03, 0x06, 0x0C, 0x18, 0x18, 0x18, 0x18, // 7
+	0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, // 8
+	0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, // 9
+	0x7E, 0xFF, 0xC3, 0xC3, 0xC3, 0xFF, 0xFF, 0xC3, 0xC3, 0xC3, // A
+	0xFC, 0xFC, 0xC3, 0xC3, 0xFC, 0xFC, 0xC3, 0xC3, 0xFC, 0xFC, // B
+	0x3C, 0xFF, 0xC3, 0xC0, 0xC0, 0xC0, 0xC0, 0xC3, 0xFF, 0x3C, // C
+	0xFC, 0xFE, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xFE, 0xFC, // D
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, // E
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC0, 0xC0, 0xC0, 0xC0, // F
+}
+
+// Small font of CHIP-48, SCHIP and Octo
+var chip48_font = []uint8{
+	0xF0, 0x90, 0x90, 0x90, 0xF0, // 0
+	0x20, 0x60, 0x20, 0x20, 0x70, // 1
+	0xF0, 0x10, 0xF0, 0x80, 0xF0, // 2
+	0xF0, 0x10, 0xF0, 0x10, 0xF0, // 3
+	0x90, 0x90, 0xF0, 0x10, 0x10, // 4
+	0xF0, 0x80, 0xF0, 0x10, 0xF0, // 5
+	0xF0, 0x80, 0xF0, 0x90, 0xF0, // 6
+	0xF0, 0x10, 0x20, 0x40, 0x40, // 7
+	0xF0, 0x90, 0xF0, 0x90, 0xF0, // 8
+	0xF0, 0x90, 0xF0, 0x10, 0xF0, // 9
+	0xF0, 0x90, 0xF0, 0x90, 0x90, // A
+	0xE0, 0x90, 0xE0, 0x90, 0xE0, // B
+	0xF0, 0x80, 0x80, 0x80, 0xF0, // C
+	0xE0, 0x90, 0x90, 0x90, 0xE0, // D
+	0xF0, 0x80, 0xF0, 0x80, 0xF0, // E
+	0xF0, 0x80, 0xF0, 0x80, 0x80, // F
+}
+
+// Built-in fonts by name, the fontStyle names of Octo and chip-8-database
+var chip8_fonts = map[string]*CHIP8Font{
+	"vip": {small: []uint8{
+		0xF0, 0x90, 0x90, 0x90, 0xF0, 0x60, 0x20, 0x20, 0x20, 0x70, 0xF0, 0x10, 0xF0, 0x80, 0xF0, 0xF0, 0x10, 0xF0, 0x10, 0xF0,
+		0xA0, 0xA0, 0xF0, 0x20, 0x20, 0xF0, 0x80, 0xF0, 0x10, 0xF0, 0xF0, 0x80, 0xF0, 0x90, 0xF0, 0xF0, 0x10, 0x10, 0x10, 0x10,
+		0xF0, 0x90, 0xF0, 0x90, 0xF0, 0xF0, 0x90, 0xF0, 0x10, 0xF0, 0xF0, 0x90, 0xF0, 0x90, 0x90, 0xF0, 0x50, 0x70, 0x50, 0xF0,
+		0xF0, 0x80, 0x80, 0x80, 0xF0, 0xF0, 0x50, 0x50, 0x50, 0xF0, 0xF0, 0x80, 0xF0, 0x80, 0xF0, 0xF0, 0x80, 0xF0, 0x80, 0x80,
+	}},
+	"eti660": {small: []uint8{
+		0xE0, 0xA0, 0xA0, 0xA0, 0xE0, 0x20, 0x20, 0x20, 0x20, 0x20, 0xE0, 0x20, 0xE0, 0x80, 0xE0, 0xE0, 0x20, 0xE0, 0x20, 0xE0,
+		0xA0, 0xA0, 0xE0, 0x20, 0x20, 0xE0, 0x80, 0xE0, 0x20, 0xE0, 0xE0, 0x80, 0xE0, 0xA0, 0xE0, 0xE0, 0x20, 0x20, 0x20, 0x20,
+		0xE0, 0xA0, 0xE0, 0xA0, 0xE0, 0xE0, 0xA0, 0xE0, 0x20, 0xE0, 0xE0, 0xA0, 0xE0, 0xA0, 0xA0, 0x80, 0x80, 0xE0, 0xA0, 0xE0,
+		0xE0, 0x80, 0x80, 0x80, 0xE0, 0x20, 0x20, 0xE0, 0xA0, 0xE0, 0xE0, 0x80, 0xE0, 0x80, 0xE0, 0xE0, 0x80, 0xE0, 0x80, 0x80,
+	}},
+	"dream6800": {small: []uint8{
+		0xE0, 0xA0, 0xA0, 0xA0, 0xE0, 0x40, 0x40, 0x40, 0x40, 0x40, 0xE0, 0x20, 0xE0, 0x80, 0xE0, 0xE0, 0x20, 0xE0, 0x20, 0xE0,
+		0x80, 0xA0, 0xA0, 0xE0, 0x20, 0xE0, 0x80, 0xE0, 0x20, 0xE0, 0xE0, 0x80, 0xE0, 0xA0, 0xE0, 0xE0, 0x20, 0x20, 0x20, 0x20,
+		0xE0, 0xA0, 0xE0, 0xA0, 0xE0, 0xE0, 0xA0, 0xE0, 0x20, 0xE0, 0xE0, 0xA0, 0xE0, 0xA0, 0xA0, 0xC0, 0xA0, 0xE0, 0xA0, 0xC0,
+		0xE0, 0x80, 0x80, 0x80, 0xE0, 0xC0, 0xA0, 0xA0, 0xA0, 0xC0, 0xE0, 0x80, 0xE0, 0x80, 0xE0, 0xE0, 0x80, 0xC0, 0x80, 0x80,
+	}},
+	"chip48": {small: chip48_font},
+	"schip": {small: chip48_font, big: []uint8{
+		0x3C, 0x7E, 0xE7, 0xC3, 0xC3, 0xC3, 0xC3, 0xE7, 0x7E, 0x3C, // 0
+		0x18, 0x38, 0x58, 0x18, 0x18, 0x18, 0x18, 0x18, 0x18, 0x3C, // 1
+		0x3E, 0x7F, 0xC3, 0x06, 0x0C, 0x18, 0x30, 0x60, 0xFF, 0xFF, // 2
+		0x3C, 0x7E, 0xC3, 0x03, 0x0E, 0x0E, 0x03, 0xC3, 0x7E, 0x3C, // 3
+		0x06, 0x0E, 0x1E, 0x36, 0x66, 0xC6, 0xFF, 0xFF, 0x06, 0x06, // 4
+		0xFF, 0xFF, 0xC0, 0xC0, 0xFC, 0xFE, 0x03, 0xC3, 0x7E, 0x3C, // 5
+		0x3E, 0x7C, 0xE0, 0xC0, 0xFC, 0xFE, 0xC3, 0xC3, 0x7E, 0x3C, // 6
+		0xFF, 0xFF, 0x03, 0x06, 0x0C, 0x18, 0x30, 0x60, 0x60, 0x60, // 7
+		0x3C, 0x7E, 0xC3, 0xC3, 0x7E, 0x7E, 0xC3, 0xC3, 0x7E, 0x3C, // 8
+		0x3C, 0x7E, 0xC3, 0xC3, 0x7F, 0x3F, 0x03, 0x03, 0x3E, 0x7C, // 9
+	}},
+	"octo": {small: chip48_font, big: octo_big_font},
+}
+
+// Names of built-in fonts, sorted
+func font_names() []string {
+	var names []string
+	for name := range chip8_fonts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Built-in font by name, or font file: 80 bytes of small glyphs, then
+// optionally 100 or 160 bytes of big ones. Empty name is default font
+func load_font(name string) (*CHIP8Font, error) {
+	if name == "" {
+		name = default_font
+	}
+	if font, ok := chip8_fonts[name]; ok {
+		return font, nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("font %s isn't one of %s, and can't be read: %s", name, strings.Join(font_names(), " "), err.Error())
+	}
+	small := 16 * small_glyph_size
+	switch len(data) - small {
+	case 0:
+		return &CHIP8Font{small: data}, nil
+	case 10 * big_glyph_size, 16 * big_glyph_size:
+		return &CHIP8Font{small: data[:small], big: data[small:]}, nil
+	}
+	return nil, fmt.Errorf("font %s is %d bytes: 80 bytes of small glyphs and 0, 100 or 160 bytes of big ones expected", name, len(data))
+}
+
+// Where font and stack are in memory
+type font_layout struct {
+	small, big uint32  // Addresses of glyphs of FX29 and FX30
+	data       []uint8 // Small glyphs, then big ones on platforms with FX30
+	stack      uint16  // Top of stack, moved off font when font is over it
+}
+
+// Places font at base, with big glyphs after small ones on platforms with
+// FX30. Stack goes right after font if font covers it, or before it when
+// there's no room up to end, the address program is loaded at
+func place_font(font *CHIP8Font, base, end uint32, platform Platform) (font_layout, error) {
+	layout := font_layout{small: base, data: font.small, stack: stack_top}
+	layout.big = base + uint32(len(font.small))
+	if dispatch_table(platform)[0xF030] != nil {
+		big := font.big
+		if big == nil {
+			big = octo_big_font
+		}
+		layout.data = append(append([]uint8(nil), font.small...), big...)
+	}
+	font_end := base + uint32(len(layout.data))
+	if font_end > end {
+		return layout, fmt.Errorf("font at %X-%X runs into program at %X", base, font_end-1, end)
+	}
+	const size = stack_top - stack_bottom + 2
+	if base >= stack_top+2 || font_end <= stack_bottom {
+		return layout, nil
+	}
+	if after := (font_end + 1) &^ 1; after+size <= end {
+		layout.stack = uint16(after + size - 2)
+	} else if base >= size {
+		layout.stack = uint16(base - 2)
+	} else {
+		return layout, fmt.Errorf("no room for stack beside font at %X-%X", base, font_end-1)
+	}
+	return layout, nil
+}
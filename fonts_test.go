@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLoadFont(t *testing.T) {
+	for _, name := range font_names() {
+		font, err := load_font(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(font.small) != 80 || len(font.big) != 0 && len(font.big) != 100 && len(font.big) != 160 {
+			t.Errorf("font %s has %d small and %d big bytes", name, len(font.small), len(font.big))
+		}
+	}
+	if font, _ := load_font(""); !bytes.Equal(font.small, chip48_font) {
+		t.Errorf("default font isn't the one chipigo always had")
+	}
+
+	data := append(make([]uint8, 80), bytes.Repeat([]uint8{0xFF}, 160)...)
+	font, err := load_font(write_test_file(t, "hex.bin", data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(font.small) != 80 || len(font.big) != 160 || font.big[0] != 0xFF {
+		t.Errorf("font file has %d small and %d big bytes", len(font.small), len(font.big))
+	}
+	if _, err = load_font(write_test_file(t, "bad.bin", make([]uint8, 81))); err == nil || !strings.Contains(err.Error(), "is 81 bytes") {
+		t.Errorf("font file of 81 bytes: %v", err)
+	}
+	if _, err = load_font("nofont"); err == nil || !strings.Contains(err.Error(), "isn't one of chip48 dream6800") {
+		t.Errorf("unknown font: %v", err)
+	}
+}
+
+func TestPlaceFont(t *testing.T) {
+	octo := chip8_fonts["octo"]
+	tests := []struct {
+		font     *CHIP8Font
+		base     uint32
+		platform Platform
+		big      uint32
+		size     int
+		stack    uint16
+	}{
+		{octo, 0x000, PlatformCHIP8, 0x050, 80, stack_top},
+		{octo, 0x050, PlatformCHIP8, 0x0A0, 80, 0x0C0},                       // Stack after font
+		{octo, 0x000, PlatformMegaChip, 0x050, 240, 0x110},                   // Big font covers stack
+		{chip8_fonts["vip"], 0x080, PlatformMegaChip, 0x0D0, 240, stack_top}, // Octo big glyphs
+		{octo, 0x1B0, PlatformCHIP8, 0x200, 80, stack_top},
+	}
+	for _, test := range tests {
+		layout, err := place_font(test.font, test.base, 0x200, test.platform)
+		if err != nil {
+			t.Errorf("font at %X: %s", test.base, err.Error())
+			continue
+		}
+		if layout.small != test.base || layout.big != test.big || len(layout.data) != test.size || layout.stack != test.stack {
+			t.Errorf("font at %X: big at %X, %d bytes, stack at %X", test.base, layout.big, len(layout.data), layout.stack)
+		}
+	}
+	if layout, err := place_font(octo, 0x40, 0x90, PlatformCHIP8); err != nil || layout.stack != 0x3E {
+		t.Errorf("stack before font: %X, %v", layout.stack, err)
+	}
+	if _, err := place_font(octo, 0x1D0, 0x200, PlatformCHIP8); err == nil || !strings.Contains(err.Error(), "runs into program at 200") {
+		t.Errorf("font over program: %v", err)
+	}
+}
+
+func new_font_console(t *testing.T, rom []uint8, platform_id string, config *CHIP8Config) *CHIP8Console {
+	console, err := start_test_console(t, rom, &CHIP8Console{platform_id: platform_id, flags: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return console
+}
+
+func TestFontBase(t *testing.T) {
+	rom := []uint8{
+		0x60, 0x0A, // LD V0, A
+		0xF0, 0x29, // LD F, V0
+		0x22, 0x08, // CALL 208
+		0x12, 0x06, // JP 206
+		0x00, 0xEE, // RET
+	}
+	font_base := 0x50
+	console := new_font_console(t, rom, "", &CHIP8Config{Font: "vip", FontBase: &font_base})
+	for i := 0; i < 4; i++ {
+		console.tick()
+	}
+	cpu := console.cpu.(*CHIP8CPU)
+	if cpu.i != 0x50+0xA*5 {
+		t.Errorf("I is %X, 82 expected", cpu.i)
+	}
+	expect_mem(t, console, cpu.i, 0xF0, 0x90, 0xF0, 0x90, 0x90)
+	expect_mem(t, console, 0, 0, 0, 0, 0, 0) // Default font is gone
+	if cpu.pc != 0x206 || cpu.stack != 0xC0 {
+		t.Errorf("PC is %X, stack at %X", cpu.pc, cpu.stack)
+	}
+	expect_mem(t, console, 0x50+0xA*5, 0xF0, 0x90, 0xF0, 0x90, 0x90) // Call didn't push over font
+	expect_mem(t, console, 0xC0, 0x02, 0x06)
+}
+
+func TestBigFont(t *testing.T) {
+	rom := []uint8{
+		0x60, 0x03, // LD V0, 3
+		0xF0, 0x30, // LD HF, V0
+	}
+	console := new_font_console(t, rom, "megachip8", &CHIP8Config{Font: "schip"})
+	console.tick()
+	console.tick()
+	cpu := console.cpu.(*CHIP8CPU)
+	if cpu.i != 0x50+3*10 {
+		t.Errorf("I is %X, 6E expected", cpu.i)
+	}
+	expect_mem(t, console, cpu.i, 0x3C, 0x7E, 0xC3, 0x03, 0x0E, 0x0E, 0x03, 0xC3, 0x7E, 0x3C)
+	if asm := disasm_op(0xF030, PlatformMegaChip); asm != "LD HF, V0" {
+		t.Errorf("FX30 disassembles to %q", asm)
+	}
+}
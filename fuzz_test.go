@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+const (
+	fuzz_max_cycles     = 2000               // CHIP-8 instructions per run
+	fuzz_max_vip_cycles = 2 * vip_call_limit // Machine cycles per run, 0NNN code that doesn't return takes a lot of them
+)
+
+// Interpreter prints warnings on every bad opcode, keep fuzzing output clean
+func quiet(t testing.TB) {
+	stdout := os.Stdout
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = null
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		null.Close()
+	})
+}
+
+func check_invariants(t *testing.T, console *CHIP8Console, cpu *CHIP8CPU) {
+	size := console.mem.size()
+	if uint32(cpu.pc) >= size {
+		t.Fatalf("PC = 0x%X is out of memory", cpu.pc)
+	}
+	if cpu.i >= size {
+		t.Fatalf("I = 0x%X is out of memory", cpu.i)
+	}
+	if cpu.sp < stack_bottom-2 || cpu.sp > stack_top {
+		t.Fatalf("SP = 0x%X is out of stack", cpu.sp)
+	}
+	w, h := console.gpu.size()
+	spec := platform_spec(cpu.platform)
+	hires := cpu.platform == PlatformMegaChip && (w == 128 && h == 64 || w == 256 && h == 192)
+	if (w != spec.width || h != spec.height) && !hires {
+		t.Fatalf("screen size changed to %dx%d", w, h)
+	}
+	gpu := console.gpu.(*fake_gpu)
+	if len(gpu.pic) != w || len(gpu.pic[0]) != h || len(gpu.pic[w-1]) != h {
+		t.Fatalf("framebuffer size changed")
+	}
+}
+
+// Platforms fuzzed ROMs run on, picked by fuzzed index
+var fuzz_platforms = []Platform{PlatformCHIP8, PlatformHybridVIP, PlatformHiresVIP, PlatformCHIP8X, PlatformMegaChip, PlatformETI660}
+
+// Runs random ROM with random keypad on random platform. Every two bytes of
+// keys are pressed keys mask for next two CPU cycles
+func FuzzCPU(f *testing.F) {
+	f.Add([]byte{0x00, 0xE0, 0x12, 0x00}, []byte{}, uint8(0))
+	f.Add([]byte{0x22, 0x00}, []byte{}, uint8(0))                                     // Endless recursion
+	f.Add([]byte{0x00, 0xEE}, []byte{}, uint8(0))                                     // Return with empty stack
+	f.Add([]byte{0x6F, 0xFF, 0xAF, 0xFF, 0xFF, 0x1E, 0xFF, 0x65}, []byte{}, uint8(0)) // I out of memory
+	f.Add([]byte{0x60, 0xFF, 0xBF, 0xFF}, []byte{}, uint8(0))                         // Jump out of memory
+	f.Add([]byte{0xF0, 0x0A, 0xD0, 0x05, 0x12, 0x00}, []byte{0x00, 0x01, 0x80, 0x00}, uint8(0))
+	f.Add([]byte{0x00, 0xFF, 0xD0, 0x00, 0x00, 0xC4, 0x00, 0xFB}, []byte{}, uint8(4)) // SCHIP hi-res, scrolling
+	f.Add([]byte{0x00, 0x11, 0x01, 0xFF, 0xFF, 0xFF, 0xD0, 0x01}, []byte{}, uint8(4)) // MegaChip colour mode, I at end of 16M
+	f.Add([]byte{0x60, 0x07, 0xB0, 0x10, 0x02, 0xA0, 0xE0, 0xF2}, []byte{}, uint8(3)) // CHIP-8X colours, second keypad
+	f.Add([]byte{0x00, 0xF8, 0x00, 0xFC, 0xD0, 0x1F}, []byte{}, uint8(5))             // ETI-660 tone and background
+	f.Fuzz(func(t *testing.T, rom []byte, keys []byte, platform_index uint8) {
+		quiet(t)
+		console, cpu, _, input := new_fake_console()
+		platform := fuzz_platforms[int(platform_index)%len(fuzz_platforms)]
+		spec := platform_spec(platform)
+		cpu.set_platform(platform)
+		cpu.set_entry(spec.entry)
+		if spec.memory != 0 {
+			console.mem.resize(spec.memory)
+		}
+		console.gpu.set_size(spec.width, spec.height)
+		console.gpu.set_color_zones(spec.color_zones)
+		console.mem.load_rom(rom, uint32(spec.load))
+		cpu.set_vip_timing(true)
+		for n := 0; n < fuzz_max_cycles && cpu.cycles < fuzz_max_vip_cycles; n++ {
+			if off := 2 * (n / 2); off+1 < len(keys) {
+				input.keys = uint16(keys[off])<<8 | uint16(keys[off+1])
+			}
+			cpu.tick(console)
+			check_invariants(t, console, cpu)
+		}
+	})
+}
+
+// Random accesses must wrap around memory
+func FuzzMemory(f *testing.F) {
+	f.Add(uint32(0xFFF), uint8(1))
+	f.Add(uint32(0x1000), uint8(2))
+	f.Add(uint32(0xFFFFFFFF), uint8(3))
+	f.Fuzz(func(t *testing.T, addr uint32, val uint8) {
+		mem := new(CHIP8Memory)
+		mem.init()
+		mem.write(addr, val)
+		if got := mem.read(addr); got != val {
+			t.Fatalf("read 0x%02X after write 0x%02X", got, val)
+		}
+		if got := mem.read(addr % mem.size()); got != val {
+			t.Fatalf("address 0x%X doesn't wrap", addr)
+		}
+		if got := mem.read2(addr); uint8(got>>8) != val {
+			t.Fatalf("read2 0x%04X after write 0x%02X", got, val)
+		}
+	})
+}
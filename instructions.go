@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Platform is a bit set of CHIP-8 dialects. Every instruction lists the
+// platforms it is valid on, so one table drives all of them.
+type Platform uint32
+
+const (
+	PlatformCHIP8     Platform = 1 << iota // Original COSMAC VIP CHIP-8
+	PlatformHybridVIP                      // CHIP-8 with 0NNN calls of CDP1802 machine code
+	PlatformHiresVIP                       // Two-page hi-res CHIP-8 with 64x64 display
+	PlatformCHIP8X                         // CHIP-8X with colour board and second keypad
+	PlatformMegaChip                       // SCHIP with 256x192 colour mode, 24-bit I and sampled sound
+	PlatformETI660                         // ETI-660 with 64x48 display, tone pitch and background colour
+)
+
+// Platforms sharing the original CHIP-8 instruction set
+const PlatformsClassic = PlatformCHIP8 | PlatformHybridVIP | PlatformHiresVIP | PlatformCHIP8X | PlatformMegaChip | PlatformETI660
+
+// OperandFields is a bit set of the variable parts of an opcode
+type OperandFields uint8
+
+const (
+	FieldX   OperandFields = 1 << iota // -X-- register
+	FieldY                             // --Y- register
+	FieldN                             // ---N nibble
+	FieldNN                            // --NN byte
+	FieldNNN                           // -NNN address
+)
+
+type InstructionHandler func(cpu *CHIP8CPU, op OpCode, console *CHIP8Console)
+
+type CHIP8Instruction struct {
+	pattern   string // Opcode pattern, e.g. "8XY4". Hex digits are fixed, letters are operands
+	mnemonic  string // Assembler mnemonic, e.g. "ADD"
+	syntax    string // Operands syntax. Vx, Vy, n, nn and nnn are replaced with operand values
+	handler   InstructionHandler
+	timing    InstructionTiming // Machine cycles on COSMAC VIP
+	platforms Platform          // Platforms where instruction is valid
+	mask      uint16            // Fixed bits of opcode. Calculated from pattern
+	match     uint16            // Value of fixed bits. Calculated from pattern
+	fields    OperandFields     // Variable parts of opcode. Calculated from pattern
+}
+
+// Order matters: more specific patterns must go before the generic ones
+var chip8_instructions = []*CHIP8Instruction{
+	{pattern: "00E0", mnemonic: "CLS", handler: (*CHIP8CPU).op_00E0, timing: vip_cycles(3078), platforms: PlatformsClassic},
+	{pattern: "00EE", mnemonic: "RET", handler: (*CHIP8CPU).op_00EE, timing: vip_cycles(10), platforms: PlatformsClassic},
+	{pattern: "0230", mnemonic: "HCLS", handler: (*CHIP8CPU).op_00E0, timing: vip_cycles(6156), platforms: PlatformHiresVIP},
+	{pattern: "02A0", mnemonic: "CLR", syntax: "BG", handler: (*CHIP8CPU).op_02A0, timing: vip_cycles(24), platforms: PlatformCHIP8X},
+	{pattern: "0010", mnemonic: "MEGAOFF", handler: (*CHIP8CPU).op_0010, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "0011", mnemonic: "MEGAON", handler: (*CHIP8CPU).op_0011, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "00BN", mnemonic: "SCU", syntax: "n", handler: (*CHIP8CPU).op_00BN, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "00CN", mnemonic: "SCD", syntax: "n", handler: (*CHIP8CPU).op_00CN, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "00FB", mnemonic: "SCR", handler: (*CHIP8CPU).op_00FB, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "00FC", mnemonic: "SCL", handler: (*CHIP8CPU).op_00FC, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "00F8", mnemonic: "TONE", syntax: "V0", handler: (*CHIP8CPU).op_00F8, timing: vip_cycles(0), platforms: PlatformETI660},
+	{pattern: "00FC", mnemonic: "CLR", syntax: "BG", handler: (*CHIP8CPU).op_00FC, timing: vip_cycles(0), platforms: PlatformETI660},
+	{pattern: "00FD", mnemonic: "EXIT", handler: (*CHIP8CPU).op_00FD, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "00FE", mnemonic: "LOW", handler: (*CHIP8CPU).op_00FE, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "00FF", mnemonic: "HIGH", handler: (*CHIP8CPU).op_00FF, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "01NN", mnemonic: "LDHI", syntax: "I, nn", handler: (*CHIP8CPU).op_01NN, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "02NN", mnemonic: "LDPAL", syntax: "nn", handler: (*CHIP8CPU).op_02NN, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "03NN", mnemonic: "SPRW", syntax: "nn", handler: (*CHIP8CPU).op_03NN, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "04NN", mnemonic: "SPRH", syntax: "nn", handler: (*CHIP8CPU).op_04NN, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "05NN", mnemonic: "ALPHA", syntax: "nn", handler: (*CHIP8CPU).op_05NN, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "060N", mnemonic: "DIGISND", syntax: "n", handler: (*CHIP8CPU).op_060N, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "0700", mnemonic: "STOPSND", handler: (*CHIP8CPU).op_0700, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "080N", mnemonic: "BMODE", syntax: "n", handler: (*CHIP8CPU).op_080N, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "09NN", mnemonic: "CCOL", syntax: "nn", handler: (*CHIP8CPU).op_09NN, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "0NNN", mnemonic: "SYS", syntax: "nnn", handler: (*CHIP8CPU).op_0NNN, timing: vip_cycles(0), platforms: PlatformsClassic},
+	{pattern: "1NNN", mnemonic: "JP", syntax: "nnn", handler: (*CHIP8CPU).op_1NNN, timing: vip_cycles(12), platforms: PlatformsClassic},
+	{pattern: "2NNN", mnemonic: "CALL", syntax: "nnn", handler: (*CHIP8CPU).op_2NNN, timing: vip_cycles(26), platforms: PlatformsClassic},
+	{pattern: "3XNN", mnemonic: "SE", syntax: "Vx, nn", handler: (*CHIP8CPU).op_3XNN, timing: vip_3XNN_cycles, platforms: PlatformsClassic},
+	{pattern: "4XNN", mnemonic: "SNE", syntax: "Vx, nn", handler: (*CHIP8CPU).op_4XNN, timing: vip_4XNN_cycles, platforms: PlatformsClassic},
+	{pattern: "5XY0", mnemonic: "SE", syntax: "Vx, Vy", handler: (*CHIP8CPU).op_5XY0, timing: vip_5XY0_cycles, platforms: PlatformsClassic},
+	{pattern: "5XY1", mnemonic: "ADDN", syntax: "Vx, Vy", handler: (*CHIP8CPU).op_5XY1, timing: vip_cycles(44), platforms: PlatformCHIP8X},
+	{pattern: "6XNN", mnemonic: "LD", syntax: "Vx, nn", handler: (*CHIP8CPU).op_6XNN, timing: vip_cycles(6), platforms: PlatformsClassic},
+	{pattern: "7XNN", mnemonic: "ADD", syntax: "Vx, nn", handler: (*CHIP8CPU).op_7XNN, timing: vip_cycles(10), platforms: PlatformsClassic},
+	{pattern: "8XY0", mnemonic: "LD", syntax: "Vx, Vy", handler: (*CHIP8CPU).op_8XY0, timing: vip_cycles(12), platforms: PlatformsClassic},
+	{pattern: "8XY1", mnemonic: "OR", syntax: "Vx, Vy", handler: (*CHIP8CPU).op_8XY1, timing: vip_cycles(44), platforms: PlatformsClassic},
+	{pattern: "8XY2", mnemonic: "AND", syntax: "Vx, Vy", handler: (*CHIP8CPU).op_8XY2, timing: vip_cycles(44), platforms: PlatformsClassic},
+	{pattern: "8XY3", mnemonic: "XOR", syntax: "Vx, Vy", handler: (*CHIP8CPU).op_8XY3, timing: vip_cycles(44), platforms: PlatformsClassic},
+	{pattern: "8XY4", mnemonic: "ADD", syntax: "Vx, Vy", handler: (*CHIP8CPU).op_8XY4, timing: vip_cycles(44), platforms: PlatformsClassic},
+	{pattern: "8XY5", mnemonic: "SUB", syntax: "Vx, Vy", handler: (*CHIP8CPU).op_8XY5, timing: vip_cycles(44), platforms: PlatformsClassic},
+	{pattern: "8XY6", mnemonic: "SHR", syntax: "Vx {, Vy}", handler: (*CHIP8CPU).op_8XY6, timing: vip_cycles(44), platforms: PlatformsClassic},
+	{pattern: "8XY7", mnemonic: "SUBN", syntax: "Vx, Vy", handler: (*CHIP8CPU).op_8XY7, timing: vip_cycles(44), platforms: PlatformsClassic},
+	{pattern: "8XYE", mnemonic: "SHL", syntax: "Vx {, Vy}", handler: (*CHIP8CPU).op_8XYE, timing: vip_cycles(44), platforms: PlatformsClassic},
+	{pattern: "9XY0", mnemonic: "SNE", syntax: "Vx, Vy", handler: (*CHIP8CPU).op_9XY0, timing: vip_9XY0_cycles, platforms: PlatformsClassic},
+	{pattern: "ANNN", mnemonic: "LD", syntax: "I, nnn", handler: (*CHIP8CPU).op_ANNN, timing: vip_cycles(12), platforms: PlatformsClassic},
+	{pattern: "BNNN", mnemonic: "JP", syntax: "V0, nnn", handler: (*CHIP8CPU).op_BNNN, timing: vip_BNNN_cycles, platforms: PlatformsClassic &^ PlatformCHIP8X},
+	{pattern: "BXY0", mnemonic: "CLR", syntax: "Vx, Vy", handler: (*CHIP8CPU).op_BXY0, timing: vip_cycles(180), platforms: PlatformCHIP8X},
+	{pattern: "BXYN", mnemonic: "CLR", syntax: "Vx, Vy, n", handler: (*CHIP8CPU).op_BXYN, timing: vip_cycles(60), platforms: PlatformCHIP8X},
+	{pattern: "CXNN", mnemonic: "RND", syntax: "Vx, nn", handler: (*CHIP8CPU).op_CXNN, timing: vip_cycles(36), platforms: PlatformsClassic},
+	{pattern: "DXYN", mnemonic: "DRW", syntax: "Vx, Vy, n", handler: (*CHIP8CPU).op_DXYN, timing: vip_DXYN_cycles, platforms: PlatformsClassic},
+	{pattern: "EX9E", mnemonic: "SKP", syntax: "Vx", handler: (*CHIP8CPU).op_EX9E, timing: vip_EX9E_cycles, platforms: PlatformsClassic},
+	{pattern: "EXA1", mnemonic: "SKNP", syntax: "Vx", handler: (*CHIP8CPU).op_EXA1, timing: vip_EXA1_cycles, platforms: PlatformsClassic},
+	{pattern: "EXF2", mnemonic: "SKP2", syntax: "Vx", handler: (*CHIP8CPU).op_EXF2, timing: vip_cycles(14), platforms: PlatformCHIP8X},
+	{pattern: "EXF5", mnemonic: "SKNP2", syntax: "Vx", handler: (*CHIP8CPU).op_EXF5, timing: vip_cycles(14), platforms: PlatformCHIP8X},
+	{pattern: "FX07", mnemonic: "LD", syntax: "Vx, DT", handler: (*CHIP8CPU).op_FX07, timing: vip_cycles(10), platforms: PlatformsClassic},
+	{pattern: "FX0A", mnemonic: "LD", syntax: "Vx, K", handler: (*CHIP8CPU).op_FX0A, timing: vip_cycles(10), platforms: PlatformsClassic},
+	{pattern: "FX15", mnemonic: "LD", syntax: "DT, Vx", handler: (*CHIP8CPU).op_FX15, timing: vip_cycles(10), platforms: PlatformsClassic},
+	{pattern: "FX18", mnemonic: "LD", syntax: "ST, Vx", handler: (*CHIP8CPU).op_FX18, timing: vip_cycles(10), platforms: PlatformsClassic},
+	{pattern: "FX1E", mnemonic: "ADD", syntax: "I, Vx", handler: (*CHIP8CPU).op_FX1E, timing: vip_FX1E_cycles, platforms: PlatformsClassic},
+	{pattern: "FX29", mnemonic: "LD", syntax: "F, Vx", handler: (*CHIP8CPU).op_FX29, timing: vip_cycles(16), platforms: PlatformsClassic},
+	{pattern: "FX30", mnemonic: "LD", syntax: "HF, Vx", handler: (*CHIP8CPU).op_FX30, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "FX33", mnemonic: "LD", syntax: "B, Vx", handler: (*CHIP8CPU).op_FX33, timing: vip_FX33_cycles, platforms: PlatformsClassic},
+	{pattern: "FX55", mnemonic: "LD", syntax: "[I], Vx", handler: (*CHIP8CPU).op_FX55, timing: vip_FXN5_cycles, platforms: PlatformsClassic},
+	{pattern: "FX65", mnemonic: "LD", syntax: "Vx, [I]", handler: (*CHIP8CPU).op_FX65, timing: vip_FXN5_cycles, platforms: PlatformsClassic},
+	{pattern: "FX75", mnemonic: "LD", syntax: "R, Vx", handler: (*CHIP8CPU).op_FX75, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "FX85", mnemonic: "LD", syntax: "Vx, R", handler: (*CHIP8CPU).op_FX85, timing: vip_cycles(0), platforms: PlatformMegaChip},
+	{pattern: "FXF8", mnemonic: "OUT", syntax: "Vx", handler: (*CHIP8CPU).op_FXF8, timing: vip_cycles(14), platforms: PlatformCHIP8X},
+	{pattern: "FXFB", mnemonic: "IN", syntax: "Vx", handler: (*CHIP8CPU).op_FXFB, timing: vip_cycles(14), platforms: PlatformCHIP8X},
+}
+
+// Lookup tables from opcode to instruction. Built lazily, one per platform
+var dispatch_tables = make(map[Platform]*[0x10000]*CHIP8Instruction)
+var dispatch_lock sync.Mutex
+
+func init() {
+	for _, ins := range chip8_instructions {
+		ins.compile()
+	}
+}
+
+// Calculates mask, match and operand fields from instruction pattern
+func (ins *CHIP8Instruction) compile() {
+	if len(ins.pattern) != 4 {
+		panic("Bad instruction pattern " + ins.pattern)
+	}
+	for i := 0; i < 4; i++ {
+		shift := uint(12 - 4*i)
+		c := ins.pattern[i]
+		switch {
+		case c >= '0' && c <= '9':
+			ins.mask |= 0xF << shift
+			ins.match |= uint16(c-'0') << shift
+		case c >= 'A' && c <= 'F':
+			ins.mask |= 0xF << shift
+			ins.match |= uint16(c-'A'+0xA) << shift
+		case c == 'X':
+			ins.fields |= FieldX
+		case c == 'Y':
+			ins.fields |= FieldY
+		case c == 'N':
+		default:
+			panic("Bad instruction pattern " + ins.pattern)
+		}
+	}
+	switch {
+	case strings.HasSuffix(ins.pattern, "NNN"):
+		ins.fields |= FieldNNN
+	case strings.HasSuffix(ins.pattern, "NN"):
+		ins.fields |= FieldNN
+	case strings.HasSuffix(ins.pattern, "N"):
+		ins.fields |= FieldN
+	}
+}
+
+func (ins *CHIP8Instruction) matches(op OpCode) bool {
+	return uint16(op)&ins.mask == ins.match
+}
+
+// Returns lookup table of all 64K opcodes for the platform
+func dispatch_table(platform Platform) *[0x10000]*CHIP8Instruction {
+	dispatch_lock.Lock()
+	defer dispatch_lock.Unlock()
+	if table, ok := dispatch_tables[platform]; ok {
+		return table
+	}
+	table := new([0x10000]*CHIP8Instruction)
+	for _, ins := range chip8_instructions {
+		if ins.platforms&platform == 0 {
+			continue
+		}
+		for op := 0; op < 0x10000; op++ {
+			if table[op] == nil && ins.matches(OpCode(op)) {
+				table[op] = ins
+			}
+		}
+	}
+	dispatch_tables[platform] = table
+	return table
+}
+
+// Returns instruction for opcode, or nil if opcode is unknown on the platform
+func decode(op OpCode, platform Platform) *CHIP8Instruction {
+	return dispatch_table(platform)[op]
+}
+
+// Returns assembler text for opcode. Numbers are hex with 0x prefix, the way
+// assembler reads them
+func (ins *CHIP8Instruction) format(op OpCode) string {
+	if ins.syntax == "" {
+		return ins.mnemonic
+	}
+	r := strings.NewReplacer(
+		"Vx", fmt.Sprintf("V%x", (op&0x0F00)>>8),
+		"Vy", fmt.Sprintf("V%x", (op&0x00F0)>>4),
+		"nnn", fmt.Sprintf("0x%X", op&0x0FFF),
+		"nn", fmt.Sprintf("0x%X", op&0x00FF),
+		"n", fmt.Sprintf("0x%X", op&0x000F),
+		"{", "", "}", "", // Optional operands are printed, they are part of opcode
+	)
+	return ins.mnemonic + " " + r.Replace(ins.syntax)
+}
+
+// Returns opcode for operand values. Operands not used by instruction are ignored
+func (ins *CHIP8Instruction) encode(x, y uint8, n uint16) OpCode {
+	op := ins.match
+	if ins.fields&FieldX != 0 {
+		op |= uint16(x&0xF) << 8
+	}
+	if ins.fields&FieldY != 0 {
+		op |= uint16(y&0xF) << 4
+	}
+	switch {
+	case ins.fields&FieldNNN != 0:
+		op |= n & 0x0FFF
+	case ins.fields&FieldNN != 0:
+		op |= n & 0x00FF
+	case ins.fields&FieldN != 0:
+		op |= n & 0x000F
+	}
+	return OpCode(op)
+}
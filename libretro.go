@@ -0,0 +1,334 @@
+//go:build libretro
+
+package main
+
+/*
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+// Part of libretro.h the core uses
+struct retro_system_info {
+	const char *library_name;
+	const char *library_version;
+	const char *valid_extensions;
+	bool need_fullpath;
+	bool block_extract;
+};
+
+struct retro_game_geometry {
+	unsigned base_width;
+	unsigned base_height;
+	unsigned max_width;
+	unsigned max_height;
+	float aspect_ratio;
+};
+
+struct retro_system_timing {
+	double fps;
+	double sample_rate;
+};
+
+struct retro_system_av_info {
+	struct retro_game_geometry geometry;
+	struct retro_system_timing timing;
+};
+
+struct retro_variable {
+	const char *key;
+	const char *value;
+};
+
+struct retro_game_info {
+	const char *path;
+	const void *data;
+	size_t size;
+	const char *meta;
+};
+
+typedef bool (*retro_environment_t)(unsigned cmd, void *data);
+typedef void (*retro_video_refresh_t)(const void *data, unsigned width, unsigned height, size_t pitch);
+typedef void (*retro_audio_sample_t)(int16_t left, int16_t right);
+typedef size_t (*retro_audio_sample_batch_t)(const int16_t *data, size_t frames);
+typedef void (*retro_input_poll_t)(void);
+typedef int16_t (*retro_input_state_t)(unsigned port, unsigned device, unsigned index, unsigned id);
+
+// Go can't call C function pointers itself
+static inline bool call_environment(retro_environment_t f, unsigned cmd, void *data) { return f(cmd, data); }
+static inline void call_video_refresh(retro_video_refresh_t f, const void *data, unsigned w, unsigned h, size_t pitch) { f(data, w, h, pitch); }
+static inline size_t call_audio_sample_batch(retro_audio_sample_batch_t f, const int16_t *data, size_t frames) { return f(data, frames); }
+static inline void call_input_poll(retro_input_poll_t f) { f(); }
+static inline int16_t call_input_state(retro_input_state_t f, unsigned port, unsigned device, unsigned index, unsigned id) { return f(port, device, index, id); }
+*/
+import "C"
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// Build: go build -tags libretro -buildmode=c-shared -o chipigo_libretro.so
+// Frontend calls entry points from one thread, so the core keeps its state in globals
+
+const (
+	retro_environment_set_pixel_format    = 10
+	retro_environment_get_variable        = 15
+	retro_environment_set_variables       = 16
+	retro_environment_get_variable_update = 17
+	retro_environment_set_geometry        = 37
+	retro_pixel_format_xrgb8888           = 1
+	retro_device_joypad                   = 1
+	retro_memory_system_ram               = 2
+	retro_region_ntsc                     = 0
+)
+
+var (
+	retro_core        *CHIP8Retro
+	retro_environment C.retro_environment_t
+	retro_video       C.retro_video_refresh_t
+	retro_audio_batch C.retro_audio_sample_batch_t
+	retro_input_poll  C.retro_input_poll_t
+	retro_input_state C.retro_input_state_t
+	retro_memory      unsafe.Pointer // C copy of memory for frontend cheats and achievements
+	retro_w, retro_h  int            // Size of last picture, geometry is changed with it
+	retro_strings     = map[string]*C.char{}
+)
+
+// C string that lives as long as the core
+func retro_string(s string) *C.char {
+	if cs, ok := retro_strings[s]; ok {
+		return cs
+	}
+	retro_strings[s] = C.CString(s)
+	return retro_strings[s]
+}
+
+func retro_env(cmd C.uint, data unsafe.Pointer) bool {
+	return retro_environment != nil && bool(C.call_environment(retro_environment, cmd, data))
+}
+
+func retro_option_value(key string) string {
+	v := C.struct_retro_variable{key: retro_string(key)}
+	if !retro_env(retro_environment_get_variable, unsafe.Pointer(&v)) || v.value == nil {
+		return ""
+	}
+	return C.GoString(v.value)
+}
+
+func retro_memory_bytes() []uint8 {
+	return unsafe.Slice((*uint8)(retro_memory), retro_core.memory_size())
+}
+
+func retro_geometry(w, h int) C.struct_retro_game_geometry {
+	return C.struct_retro_game_geometry{
+		base_width:   C.uint(w),
+		base_height:  C.uint(h),
+		max_width:    retro_max_width,
+		max_height:   retro_max_height,
+		aspect_ratio: C.float(float64(w) / float64(h)),
+	}
+}
+
+//export retro_api_version
+func retro_api_version() C.uint { return 1 }
+
+//export retro_set_environment
+func retro_set_environment(cb C.retro_environment_t) {
+	retro_environment = cb
+	options := retro_options()
+	size := C.size_t(unsafe.Sizeof(C.struct_retro_variable{}))
+	vars := unsafe.Slice((*C.struct_retro_variable)(C.calloc(C.size_t(len(options)+1), size)), len(options)+1) // Ends with zeroed one
+	for i, option := range options {
+		vars[i].key = retro_string(option.key)
+		vars[i].value = retro_string(option.desc + "; " + strings.Join(option.values, "|"))
+	}
+	retro_env(retro_environment_set_variables, unsafe.Pointer(&vars[0]))
+}
+
+//export retro_set_video_refresh
+func retro_set_video_refresh(cb C.retro_video_refresh_t) { retro_video = cb }
+
+//export retro_set_audio_sample
+func retro_set_audio_sample(cb C.retro_audio_sample_t) {} // Batch is used
+
+//export retro_set_audio_sample_batch
+func retro_set_audio_sample_batch(cb C.retro_audio_sample_batch_t) { retro_audio_batch = cb }
+
+//export retro_set_input_poll
+func retro_set_input_poll(cb C.retro_input_poll_t) { retro_input_poll = cb }
+
+//export retro_set_input_state
+func retro_set_input_state(cb C.retro_input_state_t) { retro_input_state = cb }
+
+//export retro_init
+func retro_init() {}
+
+//export retro_deinit
+func retro_deinit() {}
+
+//export retro_get_system_info
+func retro_get_system_info(info *C.struct_retro_system_info) {
+	info.library_name = retro_string("chipigo")
+	info.library_version = retro_string("1.0")
+	info.valid_extensions = retro_string("ch8|c8x|sc8|xo8|mc8|rom")
+	info.need_fullpath = true // ROM database needs path of ROM for its settings
+	info.block_extract = false
+}
+
+//export retro_get_system_av_info
+func retro_get_system_av_info(info *C.struct_retro_system_av_info) {
+	w, h := 64, 32
+	if retro_core != nil {
+		w, h = retro_core.console.gpu.size()
+	}
+	info.geometry = retro_geometry(w, h)
+	info.timing = C.struct_retro_system_timing{fps: frames_per_second, sample_rate: retro_sample_rate}
+}
+
+//export retro_set_controller_port_device
+func retro_set_controller_port_device(port, device C.uint) {}
+
+//export retro_reset
+func retro_reset() {
+	if retro_core != nil {
+		retro_core.reset()
+		retro_core.read_memory(retro_memory_bytes())
+	}
+}
+
+//export retro_run
+func retro_run() {
+	var updated C.bool
+	if retro_env(retro_environment_get_variable_update, unsafe.Pointer(&updated)) && bool(updated) {
+		retro_core.set_options(retro_option_value)
+	}
+	C.call_input_poll(retro_input_poll)
+	var buttons uint16
+	for id := range retro_buttons {
+		if C.call_input_state(retro_input_state, 0, retro_device_joypad, 0, C.uint(id)) != 0 {
+			buttons |= 1 << uint(id)
+		}
+	}
+	memory := retro_memory_bytes()
+	retro_core.write_memory(memory)
+	retro_core.run(buttons)
+	retro_core.read_memory(memory)
+
+	pixels, w, h := retro_core.frame()
+	if w != retro_w || h != retro_h {
+		retro_w, retro_h = w, h
+		geometry := retro_geometry(w, h)
+		retro_env(retro_environment_set_geometry, unsafe.Pointer(&geometry))
+	}
+	C.call_video_refresh(retro_video, unsafe.Pointer(&pixels[0]), C.uint(w), C.uint(h), C.size_t(4*w))
+	audio := retro_core.audio
+	for sent := 0; sent < len(audio)/2; {
+		n := int(C.call_audio_sample_batch(retro_audio_batch, (*C.int16_t)(unsafe.Pointer(&audio[2*sent])), C.size_t(len(audio)/2-sent)))
+		if n <= 0 {
+			break
+		}
+		sent += n
+	}
+}
+
+//export retro_serialize_size
+func retro_serialize_size() C.size_t {
+	if retro_core == nil {
+		return 0
+	}
+	return C.size_t(retro_core.state_size())
+}
+
+//export retro_serialize
+func retro_serialize(data unsafe.Pointer, size C.size_t) C.bool {
+	if retro_core == nil {
+		return false
+	}
+	return retro_core.save(unsafe.Slice((*uint8)(data), int(size))) == nil
+}
+
+//export retro_unserialize
+func retro_unserialize(data unsafe.Pointer, size C.size_t) C.bool {
+	if retro_core == nil {
+		return false
+	}
+	if err := retro_core.load(unsafe.Slice((*uint8)(data), int(size))); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return false
+	}
+	retro_core.read_memory(retro_memory_bytes())
+	return true
+}
+
+//export retro_cheat_reset
+func retro_cheat_reset() {
+	if retro_core != nil {
+		retro_core.console.cheats = nil
+	}
+}
+
+//export retro_cheat_set
+func retro_cheat_set(index C.uint, enabled C.bool, code *C.char) {
+	if retro_core == nil || !bool(enabled) || code == nil {
+		return
+	}
+	if err := retro_core.cheat(C.GoString(code)); err != nil {
+		fmt.Printf("%s\n", err.Error())
+	}
+}
+
+//export retro_load_game
+func retro_load_game(game *C.struct_retro_game_info) C.bool {
+	if game == nil || game.path == nil {
+		return false
+	}
+	format := C.int(retro_pixel_format_xrgb8888)
+	if !retro_env(retro_environment_set_pixel_format, unsafe.Pointer(&format)) {
+		fmt.Printf("Frontend has no XRGB8888\n")
+		return false
+	}
+	core, err := new_retro(C.GoString(game.path), load_config(config_path()))
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return false
+	}
+	retro_core = core
+	retro_core.set_options(retro_option_value)
+	retro_w, retro_h = retro_core.console.gpu.size()
+	retro_memory = C.calloc(C.size_t(retro_core.memory_size()), 1)
+	retro_core.read_memory(retro_memory_bytes())
+	return true
+}
+
+//export retro_load_game_special
+func retro_load_game_special(game_type C.uint, info *C.struct_retro_game_info, num_info C.size_t) C.bool {
+	return false
+}
+
+//export retro_unload_game
+func retro_unload_game() {
+	retro_core = nil
+	C.free(retro_memory)
+	retro_memory = nil
+}
+
+//export retro_get_region
+func retro_get_region() C.uint { return retro_region_ntsc }
+
+//export retro_get_memory_data
+func retro_get_memory_data(id C.uint) unsafe.Pointer {
+	if id != retro_memory_system_ram || retro_core == nil {
+		return nil
+	}
+	return retro_memory
+}
+
+//export retro_get_memory_size
+func retro_get_memory_size(id C.uint) C.size_t {
+	if id != retro_memory_system_ram || retro_core == nil {
+		return 0
+	}
+	return C.size_t(retro_core.memory_size())
+}
@@ -0,0 +1,175 @@
+/*
+ * Minimal libretro frontend for checking chipigo core without RetroArch.
+ *
+ *   go build -tags libretro -buildmode=c-shared -o chipigo_libretro.so
+ *   cc -o harness libretro/harness.c -ldl
+ *   ./harness ./chipigo_libretro.so maze.ch8 [frames] [buttons]
+ *
+ * Runs frames with RetroPad buttons held (bit mask of ids, B is 1, A is 256),
+ * prints picture size, lit pixels (unlike bottom right one) and audio, and
+ * checks that save state taken midway brings back the same picture.
+ */
+#include <dlfcn.h>
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+struct retro_game_info {
+	const char *path;
+	const void *data;
+	size_t size;
+	const char *meta;
+};
+
+struct retro_variable {
+	const char *key;
+	const char *value;
+};
+
+static unsigned width, height, buttons;
+static uint32_t picture_hash;
+static unsigned lit, audio_frames, audio_loud;
+
+static bool environment(unsigned cmd, void *data)
+{
+	switch (cmd) {
+	case 10: /* SET_PIXEL_FORMAT */
+		return *(int *)data == 1; /* XRGB8888 */
+	case 16: /* SET_VARIABLES */
+		for (struct retro_variable *v = data; v->key; v++)
+			printf("option %s: %s\n", v->key, v->value);
+		return true;
+	case 15: /* GET_VARIABLE, defaults */
+		((struct retro_variable *)data)->value = NULL;
+		return false;
+	case 37: /* SET_GEOMETRY */
+		return true;
+	}
+	return false;
+}
+
+static void video_refresh(const void *data, unsigned w, unsigned h, size_t pitch)
+{
+	width = w;
+	height = h;
+	picture_hash = 2166136261u;
+	lit = 0;
+	uint32_t background = ((const uint32_t *)((const char *)data + (h - 1) * pitch))[w - 1] & 0xFFFFFF;
+	for (unsigned y = 0; y < h; y++) {
+		const uint32_t *row = (const uint32_t *)((const char *)data + y * pitch);
+		for (unsigned x = 0; x < w; x++) {
+			picture_hash = (picture_hash ^ (row[x] & 0xFFFFFF)) * 16777619u;
+			if ((row[x] & 0xFFFFFF) != background)
+				lit++;
+		}
+	}
+}
+
+static void audio_sample(int16_t left, int16_t right) {}
+
+static size_t audio_sample_batch(const int16_t *data, size_t frames)
+{
+	audio_frames += frames;
+	for (size_t i = 0; i < 2 * frames; i++)
+		if (data[i] != 0)
+			audio_loud++;
+	return frames;
+}
+
+static void input_poll(void) {}
+
+static int16_t input_state(unsigned port, unsigned device, unsigned index, unsigned id)
+{
+	return port == 0 && device == 1 && (buttons >> id & 1);
+}
+
+static void *symbol(void *core, const char *name)
+{
+	void *f = dlsym(core, name);
+	if (!f) {
+		fprintf(stderr, "core has no %s\n", name);
+		exit(1);
+	}
+	return f;
+}
+
+int main(int argc, char **argv)
+{
+	if (argc < 3) {
+		fprintf(stderr, "usage: %s core.so rom [frames] [buttons]\n", argv[0]);
+		return 2;
+	}
+	int frames = argc > 3 ? atoi(argv[3]) : 60;
+	buttons = argc > 4 ? strtoul(argv[4], NULL, 0) : 0;
+	void *core = dlopen(argv[1], RTLD_NOW);
+	if (!core) {
+		fprintf(stderr, "%s\n", dlerror());
+		return 1;
+	}
+	unsigned (*api_version)(void) = symbol(core, "retro_api_version");
+	void (*set_environment)(void *) = symbol(core, "retro_set_environment");
+	void (*set_video_refresh)(void *) = symbol(core, "retro_set_video_refresh");
+	void (*set_audio_sample)(void *) = symbol(core, "retro_set_audio_sample");
+	void (*set_audio_sample_batch)(void *) = symbol(core, "retro_set_audio_sample_batch");
+	void (*set_input_poll)(void *) = symbol(core, "retro_set_input_poll");
+	void (*set_input_state)(void *) = symbol(core, "retro_set_input_state");
+	void (*init)(void) = symbol(core, "retro_init");
+	bool (*load_game)(const struct retro_game_info *) = symbol(core, "retro_load_game");
+	void (*run)(void) = symbol(core, "retro_run");
+	size_t (*serialize_size)(void) = symbol(core, "retro_serialize_size");
+	bool (*serialize)(void *, size_t) = symbol(core, "retro_serialize");
+	bool (*unserialize)(const void *, size_t) = symbol(core, "retro_unserialize");
+	size_t (*memory_size)(unsigned) = symbol(core, "retro_get_memory_size");
+	void (*unload_game)(void) = symbol(core, "retro_unload_game");
+	void (*deinit)(void) = symbol(core, "retro_deinit");
+
+	if (api_version() != 1) {
+		fprintf(stderr, "core has API version %u\n", api_version());
+		return 1;
+	}
+	set_environment(environment);
+	set_video_refresh(video_refresh);
+	set_audio_sample(audio_sample);
+	set_audio_sample_batch(audio_sample_batch);
+	set_input_poll(input_poll);
+	set_input_state(input_state);
+	init();
+	struct retro_game_info game = {argv[2], NULL, 0, NULL};
+	if (!load_game(&game)) {
+		fprintf(stderr, "core can't load %s\n", argv[2]);
+		return 1;
+	}
+
+	for (int i = 0; i < frames / 2; i++)
+		run();
+	size_t size = serialize_size();
+	void *state = malloc(size);
+	if (!serialize(state, size)) {
+		fprintf(stderr, "serialize failed\n");
+		return 1;
+	}
+	for (int i = frames / 2; i < frames; i++)
+		run();
+	uint32_t hash = picture_hash;
+	printf("%ux%u picture, %u lit pixels, %u audio frames, %u loud samples, %zu byte state, %zu byte memory\n",
+	       width, height, lit, audio_frames, audio_loud, size, memory_size(2));
+
+	if (!unserialize(state, size)) {
+		fprintf(stderr, "unserialize failed\n");
+		return 1;
+	}
+	for (int i = frames / 2; i < frames; i++)
+		run();
+	free(state);
+	unload_game();
+	deinit();
+	if (picture_hash != hash) {
+		fprintf(stderr, "picture after loading state differs\n");
+		return 1;
+	}
+	printf("ok\n");
+	return 0;
+}
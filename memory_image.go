@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Memory images of EPROM programmers and monitors of hardware builds: Intel
+// HEX and Motorola S-records put bytes at addresses they give, with checksum
+// on every line
+
+// Bytes at address of image
+type mem_chunk struct {
+	addr uint32
+	data []uint8
+}
+
+const mem_record_size = 16 // Data bytes per written line
+
+var (
+	ihex_exts = []string{".hex", ".ihx", ".ihex"}
+	srec_exts = []string{".srec", ".s19", ".s28", ".s37", ".mot"}
+)
+
+func is_ihex(data []uint8) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []uint8(":"))
+}
+
+func is_srec(data []uint8) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 1 && data[0] == 'S' && data[1] >= '0' && data[1] <= '9'
+}
+
+// Bytes of record line after start character, last one is checksum
+func record_bytes(line string, num int) ([]uint8, error) {
+	data, err := hex.DecodeString(line)
+	if err != nil || len(data) < 2 {
+		return nil, fmt.Errorf("line %d: bad record", num)
+	}
+	return data, nil
+}
+
+// Intel HEX: records :LLAAAATT<data>CC. Types 02 and 04 set high bits of
+// addresses, 01 ends file, start address records 03 and 05 are skipped
+func read_ihex(text []uint8) ([]mem_chunk, error) {
+	var chunks []mem_chunk
+	base := uint32(0)
+	for num, line := range strings.Split(string(text), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line[0] != ':' {
+			return nil, fmt.Errorf("line %d: record doesn't start with colon", num+1)
+		}
+		rec, err := record_bytes(line[1:], num+1)
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 5 || int(rec[0]) != len(rec)-5 {
+			return nil, fmt.Errorf("line %d: record size is wrong", num+1)
+		}
+		sum := uint8(0)
+		for _, b := range rec {
+			sum += b
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("line %d: checksum is wrong", num+1)
+		}
+		data := rec[4 : len(rec)-1]
+		switch rec[3] {
+		case 0x00:
+			chunks = append(chunks, mem_chunk{addr: base + uint32(rec[1])<<8 | uint32(rec[2]), data: data})
+		case 0x01:
+			return chunks, nil
+		case 0x02, 0x04:
+			if len(data) != 2 {
+				return nil, fmt.Errorf("line %d: address record size is wrong", num+1)
+			}
+			base = uint32(data[0])<<8 | uint32(data[1])
+			if rec[3] == 0x02 {
+				base <<= 4 // Segment
+			} else {
+				base <<= 16
+			}
+		case 0x03, 0x05:
+		default:
+			return nil, fmt.Errorf("line %d: unknown record type %02X", num+1, rec[3])
+		}
+	}
+	return nil, fmt.Errorf("Intel HEX has no end record")
+}
+
+// S-records: S<type><count><address><data><checksum>. S1, S2 and S3 hold data
+// at 16, 24 and 32 bit addresses, S0 is header, S5 and S6 count records,
+// S7, S8 and S9 end file with start address
+func read_srec(text []uint8) ([]mem_chunk, error) {
+	var chunks []mem_chunk
+	for num, line := range strings.Split(string(text), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if len(line) < 2 || line[0] != 'S' {
+			return nil, fmt.Errorf("line %d: record doesn't start with S", num+1)
+		}
+		rec, err := record_bytes(line[2:], num+1)
+		if err != nil {
+			return nil, err
+		}
+		if int(rec[0]) != len(rec)-1 {
+			return nil, fmt.Errorf("line %d: record size is wrong", num+1)
+		}
+		sum := uint8(0)
+		for _, b := range rec {
+			sum += b
+		}
+		if sum != 0xFF {
+			return nil, fmt.Errorf("line %d: checksum is wrong", num+1)
+		}
+		addr_size := map[byte]int{'0': 2, '1': 2, '2': 3, '3': 4, '5': 2, '6': 3, '7': 4, '8': 3, '9': 2}[line[1]]
+		if addr_size == 0 {
+			return nil, fmt.Errorf("line %d: unknown record type S%c", num+1, line[1])
+		}
+		if len(rec) < addr_size+2 {
+			return nil, fmt.Errorf("line %d: record size is wrong", num+1)
+		}
+		addr := uint32(0)
+		for _, b := range rec[1 : 1+addr_size] {
+			addr = addr<<8 | uint32(b)
+		}
+		switch line[1] {
+		case '1', '2', '3':
+			chunks = append(chunks, mem_chunk{addr: addr, data: rec[1+addr_size : len(rec)-1]})
+		case '7', '8', '9':
+			return chunks, nil
+		}
+	}
+	return chunks, nil
+}
+
+// Program of chunks from lowest address, gaps between them are zero
+func flatten_chunks(chunks []mem_chunk) (uint32, []uint8, error) {
+	if len(chunks) == 0 {
+		return 0, nil, fmt.Errorf("image has no data")
+	}
+	start, end := chunks[0].addr, uint32(0)
+	for _, chunk := range chunks {
+		if chunk.addr < start {
+			start = chunk.addr
+		}
+		if e := chunk.addr + uint32(len(chunk.data)); e > end {
+			end = e
+		}
+	}
+	if end-start > 1<<24 {
+		return 0, nil, fmt.Errorf("image spans %d bytes, more than memory of any platform", end-start)
+	}
+	data := make([]uint8, end-start)
+	for _, chunk := range chunks {
+		copy(data[chunk.addr-start:], chunk.data)
+	}
+	return start, data, nil
+}
+
+func load_ihex(file *CHIP8RomFile, name string, data []uint8) error {
+	chunks, err := read_ihex(data)
+	if err == nil {
+		err = file.set_image(chunks)
+	}
+	return err
+}
+
+func load_srec(file *CHIP8RomFile, name string, data []uint8) error {
+	chunks, err := read_srec(data)
+	if err == nil {
+		err = file.set_image(chunks)
+	}
+	return err
+}
+
+func (file *CHIP8RomFile) set_image(chunks []mem_chunk) error {
+	addr, data, err := flatten_chunks(chunks)
+	if err != nil {
+		return err
+	}
+	file.addr = int(addr)
+	file.original = data
+	return nil
+}
+
+func write_ihex(w io.Writer, addr uint32, data []uint8) error {
+	out := bufio.NewWriter(w)
+	record := func(kind uint8, addr uint16, data []uint8) {
+		rec := append([]uint8{uint8(len(data)), uint8(addr >> 8), uint8(addr), kind}, data...)
+		sum := uint8(0)
+		for _, b := range rec {
+			sum += b
+		}
+		fmt.Fprintf(out, ":%X%02X\n", rec, -sum)
+	}
+	for i := 0; i < len(data); {
+		a := addr + uint32(i)
+		if i == 0 && a > 0xFFFF || i > 0 && a&0xFFFF == 0 { // High bits of addresses
+			record(0x04, 0, []uint8{uint8(a >> 24), uint8(a >> 16)})
+		}
+		n := mem_record_size
+		if n > len(data)-i {
+			n = len(data) - i
+		}
+		if rest := int(0x10000 - a&0xFFFF); n > rest { // Record doesn't cross 64K
+			n = rest
+		}
+		record(0x00, uint16(a), data[i:i+n])
+		i += n
+	}
+	record(0x01, 0, nil)
+	return out.Flush()
+}
+
+func write_srec(w io.Writer, addr uint32, data []uint8) error {
+	out := bufio.NewWriter(w)
+	end := addr + uint32(len(data))
+	addr_size, kind := 2, uint8('1')
+	if end > 0x1000000 {
+		addr_size, kind = 4, '3'
+	} else if end > 0x10000 {
+		addr_size, kind = 3, '2'
+	}
+	record := func(kind uint8, addr_size int, addr uint32, data []uint8) {
+		rec := []uint8{uint8(addr_size + len(data) + 1)}
+		for i := addr_size - 1; i >= 0; i-- {
+			rec = append(rec, uint8(addr>>(8*uint(i))))
+		}
+		rec = append(rec, data...)
+		sum := uint8(0)
+		for _, b := range rec {
+			sum += b
+		}
+		fmt.Fprintf(out, "S%c%X%02X\n", kind, rec, ^sum)
+	}
+	record('0', 2, 0, []uint8("chipigo"))
+	count := 0
+	for i := 0; i < len(data); i += mem_record_size {
+		n := mem_record_size
+		if n > len(data)-i {
+			n = len(data) - i
+		}
+		record(kind, addr_size, addr+uint32(i), data[i:i+n])
+		count++
+	}
+	if count <= 0xFFFF {
+		record('5', 2, uint32(count), nil)
+	}
+	record('9'-(kind-'1'), addr_size, addr, nil) // S9, S8 or S7 ends file with start address
+	return out.Flush()
+}
+
+// Writes memory range to Intel HEX or S-record file, chosen by extension.
+// Size 0 is memory from addr to its end
+func export_memory(path string, mem CHIP8Memory_i, addr, size uint32) error {
+	if addr >= mem.size() || size > mem.size()-addr {
+		return fmt.Errorf("range %X+%X is out of %X bytes of memory", addr, size, mem.size())
+	}
+	if size == 0 {
+		size = mem.size() - addr
+	}
+	data := memory_snapshot(mem)[addr : addr+size]
+	write := write_ihex
+	ext := strings.ToLower(filepath.Ext(path))
+	if contains(srec_exts, ext) {
+		write = write_srec
+	} else if !contains(ihex_exts, ext) {
+		return fmt.Errorf("%s is not Intel HEX (%s) or S-record (%s)", path, strings.Join(ihex_exts, " "), strings.Join(srec_exts, " "))
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = write(file, addr, data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func cmd_export(cmd *cli_command, args []string) int {
+	flags := cmd.flag_set()
+	platform_id := flags.String("platform", "", "Platform id of ROM database instead of found one")
+	addr := flags.Uint("addr", 0, "First address of range")
+	size := flags.Uint("len", 0, "Size of range, 0 is up to end of memory")
+	frames := flags.Int("frames", 0, "Frames to run before export")
+	args, ok := parse_args(flags, args, 2)
+	if !ok {
+		return exit_usage
+	}
+	if *addr > math.MaxUint32 || *size > math.MaxUint32 {
+		fmt.Printf("Range %X+%X is out of 32-bit addresses\n", *addr, *size)
+		return exit_error
+	}
+	console := &CHIP8Console{headless: true, platform_id: *platform_id}
+	err := console.init(args[0])
+	if err == nil {
+		for i := 0; i < *frames; i++ {
+			console.emulate_frame()
+		}
+		err = export_memory(args[1], console.mem, uint32(*addr), uint32(*size))
+	}
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return exit_error
+	}
+	return exit_ok
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIntelHex(t *testing.T) {
+	text := ":0400000012340000B6\n:02000004000AF0\n:010000007788\n:00000001FF\n"
+	chunks, err := read_ihex([]uint8(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 || chunks[0].addr != 0 || chunks[1].addr != 0xA0000 || chunks[1].data[0] != 0x77 {
+		t.Errorf("chunks %+v", chunks)
+	}
+	errors := map[string]string{
+		":0400000012340000B7\n:00000001FF\n": "line 1: checksum is wrong",
+		":0400000012340000B6\n":              "no end record",
+		":05000000123400B6\n:00000001FF\n":   "line 1: record size is wrong",
+		"\n:0000000AF6\n":                    "line 2: unknown record type 0A",
+	}
+	for text, msg := range errors {
+		if _, err := read_ihex([]uint8(text)); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: error %v, %q expected", text, err, msg)
+		}
+	}
+}
+
+func TestSrec(t *testing.T) {
+	text := "S00A0000636869706967six\nS1050200120FD7\nS9030200FA\n"
+	if _, err := read_srec([]uint8(text)); err == nil || !strings.Contains(err.Error(), "line 1: bad record") {
+		t.Errorf("bad header: %v", err)
+	}
+	text = "S1050200120FD7\nS9030200FA\n"
+	chunks, err := read_srec([]uint8(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 1 || chunks[0].addr != 0x200 || !bytes.Equal(chunks[0].data, []uint8{0x12, 0x0F}) {
+		t.Errorf("chunks %+v", chunks)
+	}
+	if _, err := read_srec([]uint8("S1050200120FD9\n")); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("bad checksum: %v", err)
+	}
+}
+
+// Written images read back to the same bytes at the same addresses
+func TestMemoryImageRoundTrip(t *testing.T) {
+	data := make([]uint8, 300)
+	for i := range data {
+		data[i] = uint8(i * 7)
+	}
+	for _, addr := range []uint32{0x200, 0xFFF0, 0x123456} {
+		var ihex, srec bytes.Buffer
+		write_ihex(&ihex, addr, data)
+		write_srec(&srec, addr, data)
+		for name, read := range map[string]func([]uint8) ([]mem_chunk, error){"Intel HEX": read_ihex, "S-record": read_srec} {
+			text := ihex.Bytes()
+			if name == "S-record" {
+				text = srec.Bytes()
+			}
+			chunks, err := read(text)
+			if err != nil {
+				t.Fatalf("%s at %X: %s", name, addr, err.Error())
+			}
+			start, got, _ := flatten_chunks(chunks)
+			if start != addr || !bytes.Equal(got, data) {
+				t.Errorf("%s at %X reads back at %X, %d bytes", name, addr, start, len(got))
+			}
+		}
+	}
+}
+
+func TestLoadMemoryImage(t *testing.T) {
+	path := write_test_file(t, "low.hex", []uint8(":02010000600598\n:020300001200E9\n:00000001FF\n"))
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	console := &CHIP8Console{headless: true}
+	if err := console.init(path); err != nil {
+		t.Fatal(err)
+	}
+	if console.rom_addr != 0x100 || console.mem.read(0x100) != 0x60 || console.mem.read(0x303) != 0x00 || console.mem.read(0x200) != 0 {
+		t.Errorf("image is at %X, byte at 100 is %02X", console.rom_addr, console.mem.read(0x100))
+	}
+
+	out := filepath.Join(t.TempDir(), "out.s19")
+	if err := export_memory(out, console.mem, 0x100, 4); err != nil {
+		t.Fatal(err)
+	}
+	file, err := load_rom_file(out, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.addr != 0x100 || !bytes.Equal(file.data, []uint8{0x60, 0x05, 0x00, 0x00}) {
+		t.Errorf("exported image at %X: % X", file.addr, file.data)
+	}
+	if err := export_memory(filepath.Join(t.TempDir(), "out.bin"), console.mem, 0, 0); err == nil {
+		t.Errorf("image of unknown format is written")
+	}
+	if err := export_memory(out, console.mem, 0xFFF, 2); err == nil {
+		t.Errorf("range past memory is written")
+	}
+	if err := export_memory(out, console.mem, 1, 0xFFFFFFFF); err == nil {
+		t.Errorf("range wrapping around 32 bits is written")
+	}
+
+	high := write_test_file(t, "high.hex", []uint8(":020000040002F8\n:02000000AABB99\n:00000001FF\n"))
+	if err := (&CHIP8Console{headless: true}).init(high); err == nil || !strings.Contains(err.Error(), "load address 20000 is out of 1000 bytes") {
+		t.Errorf("image at 20000 on 4K platform: %v", err)
+	}
+}
+
+func TestControlExport(t *testing.T) {
+	console := new_rom_console(t, counter_rom, "")
+	path := filepath.Join(t.TempDir(), "mem.hex")
+	lines := run_control(t, console, `{"cmd": "export", "path": "`+path+`", "addr": 512, "len": 2}
+{"cmd": "export", "addr": 512}
+{"cmd": "export", "path": "`+path+`", "addr": 1, "len": 4294967295}
+{"cmd": "export", "path": "`+path+`", "addr": 1, "len": 4294967297}
+`)
+	if lines[0]["ok"] != true || lines[1]["ok"] != false || lines[2]["ok"] != false || lines[3]["ok"] != false {
+		t.Fatalf("answers are %v", lines)
+	}
+	file, err := load_rom_file(path, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.addr != 0x200 || !bytes.Equal(file.data, counter_rom[:2]) {
+		t.Errorf("exported image at %X: % X", file.addr, file.data)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/go-gl/glfw/v3.1/glfw"
+)
+
+// Two players on one keypad over TCP. Both consoles run the same frames with
+// keys of both players ORed together. Local keys are sent for frame that is
+// delay frames ahead, so with low latency remote keys come before they are
+// needed. Late remote keys are predicted to stay as they were; when they turn
+// out different, console goes back to save state of that frame and runs the
+// frames again. Hashes of confirmed states are exchanged to find desyncs.
+type CHIP8Netplay struct {
+	console  *CHIP8Console
+	conn     io.ReadWriteCloser
+	out      *json.Encoder
+	incoming chan *netplay_message // Closed when connection ends
+	delay    int                   // Frames between local key press and frame it's used in
+
+	frame       int                 // Next frame to run
+	confirmed   int                 // Frames below are run with real keys of both players
+	local       map[int]uint16      // Keys of local player by frame
+	remote      map[int]uint16      // Keys of remote player by frame, as received
+	predicted   map[int]uint16      // Remote keys used for frames that were run before they came
+	last_remote uint16              // Latest received remote keys, prediction of next ones
+	states      map[int]*CHIP8State // State before frame, for rollback
+	hashes      map[int]string      // Hashes of states after confirmed frames, until peer sends its own
+	peer_hashes map[int]string
+}
+
+const (
+	netplay_port         = ":7800"
+	netplay_max_rollback = 8  // Frames run ahead of confirmed ones before waiting for peer
+	netplay_hash_frames  = 60 // Frames between desync checks
+)
+
+// Message of netplay connection, JSON object per line:
+//
+//	{"type": "hello", "rom": "<SHA-1>", "settings": "...", "seed": 1, "delay": 2}
+//	{"type": "keys", "frame": 10, "keys": 32}
+//	{"type": "hash", "frame": 59, "hash": "<SHA-1 of state after frame>"}
+type netplay_message struct {
+	Type     string `json:"type"`
+	ROM      string `json:"rom,omitempty"`
+	Settings string `json:"settings,omitempty"`
+	Seed     int64  `json:"seed,omitempty"`
+	Delay    int    `json:"delay,omitempty"`
+	Frame    int    `json:"frame"`
+	Keys     uint16 `json:"keys,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// Settings that must be the same on both sides
+func netplay_settings(console *CHIP8Console) string {
+	settings := console.settings
+	return fmt.Sprintf("%s %d %+v vip=%v", settings.platform_id, settings.cycles_per_frame, settings.quirks, console.vip_timing)
+}
+
+// Starts session on connection accepted by host. Host chooses delay and seed
+func netplay_host(console *CHIP8Console, conn io.ReadWriteCloser, delay int) (*CHIP8Netplay, error) {
+	np := new_netplay(console, conn, delay)
+	seed := time.Now().UnixNano()
+	np.out.Encode(&netplay_message{Type: "hello", ROM: console.rom_hash, Settings: netplay_settings(console), Seed: seed, Delay: delay})
+	if _, err := np.hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	np.start(seed)
+	return np, nil
+}
+
+// Starts session on connection to host
+func netplay_join(console *CHIP8Console, conn io.ReadWriteCloser) (*CHIP8Netplay, error) {
+	np := new_netplay(console, conn, 0)
+	hello, err := np.hello()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	np.out.Encode(&netplay_message{Type: "hello", ROM: console.rom_hash, Settings: netplay_settings(console)})
+	np.delay = hello.Delay
+	np.start(hello.Seed)
+	return np, nil
+}
+
+func new_netplay(console *CHIP8Console, conn io.ReadWriteCloser, delay int) *CHIP8Netplay {
+	np := &CHIP8Netplay{
+		console:  console,
+		conn:     conn,
+		out:      json.NewEncoder(conn),
+		incoming: make(chan *netplay_message, 64),
+		delay:    delay,
+	}
+	go func() {
+		decoder := json.NewDecoder(conn)
+		for {
+			msg := &netplay_message{}
+			if err := decoder.Decode(msg); err != nil {
+				close(np.incoming)
+				return
+			}
+			np.incoming <- msg
+		}
+	}()
+	return np
+}
+
+// Waits for hello of peer and checks that it runs the same game the same way
+func (np *CHIP8Netplay) hello() (*netplay_message, error) {
+	select {
+	case msg, ok := <-np.incoming:
+		if !ok || msg.Type != "hello" {
+			return nil, fmt.Errorf("peer is not chipigo netplay")
+		}
+		if msg.ROM != np.console.rom_hash {
+			return nil, fmt.Errorf("peer runs other ROM, SHA-1 %s", msg.ROM)
+		}
+		if settings := netplay_settings(np.console); msg.Settings != settings {
+			return nil, fmt.Errorf("peer settings %q differ from %q", msg.Settings, settings)
+		}
+		return msg, nil
+	case <-time.After(10 * time.Second):
+		return nil, fmt.Errorf("peer doesn't answer")
+	}
+}
+
+// Both consoles start from power-on state of init with the same random numbers
+func (np *CHIP8Netplay) start(seed int64) {
+	np.console.rng = new_random(seed)
+	np.local = make(map[int]uint16)
+	np.remote = make(map[int]uint16)
+	np.predicted = make(map[int]uint16)
+	np.states = make(map[int]*CHIP8State)
+	np.hashes = make(map[int]string)
+	np.peer_hashes = make(map[int]string)
+	for f := 0; f < np.delay; f++ { // Nobody has pressed anything before the first sent keys
+		np.local[f] = 0
+		np.remote[f] = 0
+	}
+}
+
+// Runs next frame with local keys pressed now. Waits for peer when it's
+// too far behind
+func (np *CHIP8Netplay) advance(keys uint16) error {
+	np.local[np.frame+np.delay] = keys
+	if err := np.out.Encode(&netplay_message{Type: "keys", Frame: np.frame + np.delay, Keys: keys}); err != nil {
+		return fmt.Errorf("peer has left")
+	}
+	if err := np.receive(false); err != nil {
+		return err
+	}
+	for np.frame-np.confirmed >= netplay_max_rollback {
+		if err := np.receive(true); err != nil {
+			return err
+		}
+	}
+	np.run_frame()
+	return np.confirm()
+}
+
+// Waits until all frames run so far are confirmed, so both consoles are in the same state
+func (np *CHIP8Netplay) sync() error {
+	for np.confirmed < np.frame {
+		if err := np.receive(true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (np *CHIP8Netplay) close() {
+	np.conn.Close()
+}
+
+// Handles messages of peer, waiting for one if wait is set. Frames run with
+// wrong prediction of remote keys are run again
+func (np *CHIP8Netplay) receive(wait bool) error {
+	rollback := -1
+	for {
+		var msg *netplay_message
+		ok := true
+		if wait {
+			msg, ok = <-np.incoming
+			wait = false
+		} else {
+			select {
+			case msg, ok = <-np.incoming:
+			default:
+			}
+		}
+		if !ok {
+			return fmt.Errorf("peer has left")
+		}
+		if msg == nil {
+			break
+		}
+		switch msg.Type {
+		case "keys":
+			np.remote[msg.Frame] = msg.Keys
+			np.last_remote = msg.Keys
+			if keys, ok := np.predicted[msg.Frame]; ok {
+				if keys != msg.Keys && (rollback < 0 || msg.Frame < rollback) {
+					rollback = msg.Frame
+				}
+				delete(np.predicted, msg.Frame)
+			}
+		case "hash":
+			np.peer_hashes[msg.Frame] = msg.Hash
+			if err := np.check_hash(msg.Frame); err != nil {
+				return err
+			}
+		}
+	}
+	if rollback >= 0 {
+		end := np.frame
+		if err := np.console.load_state(np.states[rollback]); err != nil {
+			return err
+		}
+		for np.frame = rollback; np.frame < end; {
+			np.run_frame()
+		}
+	}
+	return np.confirm()
+}
+
+func (np *CHIP8Netplay) run_frame() {
+	f := np.frame
+	np.states[f], _ = np.console.save_state()
+	keys, ok := np.remote[f]
+	if !ok {
+		keys = np.last_remote
+		np.predicted[f] = keys
+	}
+	np.console.input.set_keys(np.local[f] | keys)
+	np.console.run_frame()
+	np.frame++
+}
+
+// Moves confirmed frames on as far as remote keys have come, sending hashes
+// of their states
+func (np *CHIP8Netplay) confirm() error {
+	for np.confirmed < np.frame {
+		f := np.confirmed
+		if _, ok := np.remote[f]; !ok {
+			break
+		}
+		np.confirmed++
+		if np.confirmed%netplay_hash_frames == 0 {
+			state := np.states[np.confirmed]
+			if np.confirmed == np.frame {
+				state, _ = np.console.save_state()
+			}
+			np.hashes[f] = state_hash(state)
+			np.out.Encode(&netplay_message{Type: "hash", Frame: f, Hash: np.hashes[f]})
+			if err := np.check_hash(f); err != nil {
+				return err
+			}
+		}
+		delete(np.states, f)
+		delete(np.local, f)
+		delete(np.remote, f)
+	}
+	return nil
+}
+
+func (np *CHIP8Netplay) check_hash(f int) error {
+	hash, ok := np.hashes[f]
+	peer, peer_ok := np.peer_hashes[f]
+	if !ok || !peer_ok {
+		return nil
+	}
+	delete(np.hashes, f)
+	delete(np.peer_hashes, f)
+	if hash != peer {
+		return fmt.Errorf("desync: states after frame %d differ", f)
+	}
+	return nil
+}
+
+func state_hash(state *CHIP8State) string {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(state)
+	sum := sha1.Sum(buf.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// Runs session in window at 60 frames per second until window is closed
+func (np *CHIP8Netplay) loop() error {
+	console := np.console
+	defer glfw.Terminate()
+	ticker := time.NewTicker(time.Second / frames_per_second)
+	defer ticker.Stop()
+	for range ticker.C {
+		glfw.PollEvents()
+		if console.window.GetKey(glfw.KeyEscape) == glfw.Press || console.window.ShouldClose() {
+			return nil
+		}
+		console.input.tick()
+		if err := np.advance(console.input.pressed()); err != nil {
+			return err
+		}
+		console.gpu.render()
+	}
+	return nil
+}
+
+func cmd_netplay(cmd *cli_command, args []string) int {
+	flags := cmd.flag_set()
+	f := add_console_flags(flags)
+	listen := flags.String("listen", netplay_port, "Address host waits for peer on")
+	delay := flags.Int("delay", 2, "Input delay of host, frames")
+	if len(args) == 0 || args[0] != "host" && args[0] != "join" {
+		flags.Usage()
+		return exit_usage
+	}
+	role := args[0]
+	nargs := 1
+	if role == "join" {
+		nargs = 2
+	}
+	args, ok := parse_args(flags, args[1:], nargs)
+	if !ok {
+		return exit_usage
+	}
+	if *delay < 0 || *delay >= netplay_max_rollback {
+		fmt.Printf("Delay must be 0-%d frames\n", netplay_max_rollback-1)
+		return exit_usage
+	}
+	console := f.console(false)
+	if err := console.init(args[nargs-1]); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return exit_error
+	}
+	var np *CHIP8Netplay
+	var err error
+	if role == "host" {
+		var listener net.Listener
+		if listener, err = net.Listen("tcp", *listen); err == nil {
+			fmt.Printf("Waiting for peer on %s\n", listener.Addr())
+			var conn net.Conn
+			if conn, err = listener.Accept(); err == nil {
+				np, err = netplay_host(console, conn, *delay)
+			}
+			listener.Close()
+		}
+	} else {
+		var conn net.Conn
+		if conn, err = net.Dial("tcp", args[0]); err == nil {
+			np, err = netplay_join(console, conn)
+		}
+	}
+	if err == nil {
+		err = np.loop()
+		np.close()
+	}
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return exit_error
+	}
+	return exit_ok
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// Adds random numbers into V2, counts frames parts with key 5 in V3 and with key 8 in V4
+var netplay_rom = []uint8{
+	0xC0, 0xFF, // RND V0, FF
+	0x82, 0x04, // ADD V2, V0
+	0x61, 0x05, // LD V1, 5
+	0xE1, 0xA1, // SKNP V1
+	0x73, 0x01, // ADD V3, 1
+	0x61, 0x08, // LD V1, 8
+	0xE1, 0xA1, // SKNP V1
+	0x74, 0x01, // ADD V4, 1
+	0x12, 0x00, // JP 200
+}
+
+// Host and joined sessions on both ends of connection
+func netplay_pair(t *testing.T, host_conn, join_conn net.Conn, delay int) (*CHIP8Netplay, *CHIP8Netplay) {
+	host_console := new_rom_console(t, netplay_rom, "")
+	join_console := new_rom_console(t, netplay_rom, "")
+	var host *CHIP8Netplay
+	var err error
+	done := make(chan bool)
+	go func() {
+		host, err = netplay_host(host_console, host_conn, delay)
+		done <- true
+	}()
+	join, join_err := netplay_join(join_console, join_conn)
+	<-done
+	if err != nil || join_err != nil {
+		t.Fatalf("host: %v, join: %v", err, join_err)
+	}
+	t.Cleanup(func() {
+		host.close()
+		join.close()
+	})
+	return host, join
+}
+
+func expect_same_state(t *testing.T, host, join *CHIP8Netplay) {
+	if err := host.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := join.sync(); err != nil {
+		t.Fatal(err)
+	}
+	host_state, _ := host.console.save_state()
+	join_state, _ := join.console.save_state()
+	if state_hash(host_state) != state_hash(join_state) {
+		t.Errorf("states differ: host V=% X, join V=% X", host_state.CPU.V, join_state.CPU.V)
+	}
+	if v := host_state.CPU.V; v[3] == 0 || v[4] == 0 {
+		t.Errorf("keys of both players aren't used: V=% X", v)
+	}
+}
+
+// Host runs ahead with keys of joined player predicted as not pressed, then
+// rolls back when key 8 of joined player comes
+func TestNetplayRollback(t *testing.T) {
+	host_conn, join_conn := net.Pipe()
+	host, join := netplay_pair(t, host_conn, join_conn, 0)
+	for i := 0; i < 5; i++ {
+		if err := host.advance(1 << 5); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(host.predicted) == 0 {
+		t.Errorf("host doesn't predict keys")
+	}
+	for i := 0; i < 5; i++ {
+		if err := join.advance(1 << 8); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect_same_state(t, host, join)
+}
+
+func TestNetplayDelay(t *testing.T) {
+	host_conn, join_conn := net.Pipe()
+	host, join := netplay_pair(t, host_conn, join_conn, 2)
+	if join.delay != 2 {
+		t.Errorf("delay of joined player is %d", join.delay)
+	}
+	for i := 0; i < 150; i++ {
+		if err := host.advance(uint16(i/10%2) << 5); err != nil {
+			t.Fatal(err)
+		}
+		if err := join.advance(uint16(i/7%2) << 8); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect_same_state(t, host, join)
+}
+
+func TestNetplayDesync(t *testing.T) {
+	host_conn, join_conn := net.Pipe()
+	host, join := netplay_pair(t, host_conn, join_conn, 1)
+	var err error
+	for i := 0; i < 3*netplay_hash_frames && err == nil; i++ {
+		if i == 10 {
+			join.console.mem.write(0x800, 1)
+		}
+		if err = host.advance(0); err == nil {
+			err = join.advance(0)
+		}
+	}
+	if err == nil || !strings.Contains(err.Error(), "desync") {
+		t.Errorf("desync is not found: %v", err)
+	}
+}
+
+// Two sessions over TCP on localhost, running at their own pace
+func TestNetplayTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	join_conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, join := netplay_pair(t, <-accepted, join_conn, 1)
+
+	errs := make(chan error, 2)
+	play := func(np *CHIP8Netplay, key uint) {
+		for i := 0; i < 200; i++ {
+			if err := np.advance(uint16(i/5%2) << key); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}
+	go play(host, 5)
+	go play(join, 8)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect_same_state(t, host, join)
+}
+
+func TestNetplayOtherROM(t *testing.T) {
+	host_conn, join_conn := net.Pipe()
+	host_console := new_rom_console(t, netplay_rom, "")
+	join_console := new_rom_console(t, counter_rom, "")
+	go netplay_host(host_console, host_conn, 0)
+	if _, err := netplay_join(join_console, join_conn); err == nil || !strings.Contains(err.Error(), "other ROM") {
+		t.Errorf("other ROM is joined: %v", err)
+	}
+}
@@ -0,0 +1,827 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/gif"
+	"strconv"
+	"strings"
+)
+
+// Compiler of Octo assembly language, the source Octo cartridges carry.
+// It has labels, :const, :alias, :unpack, :next, :org, :byte, :call, :macro,
+// statements of CHIP-8, SCHIP and XO-CHIP, if then, if begin else end, and
+// loop again with while. :calc, :stringmode and :assert aren't supported
+type octo_compiler struct {
+	tokens   []octo_token
+	pos      int
+	rom      []uint8 // Program from 0x200
+	here     int     // Address of next byte
+	labels   map[string]int
+	consts   map[string]int
+	aliases  map[string]int
+	macros   map[string]*octo_macro
+	fixups   []octo_fixup // Uses of labels defined later
+	loops    []octo_loop
+	branches []int // Addresses of jumps of if begin and else, to be set by else and end
+}
+
+type octo_token struct {
+	text string
+	line int
+}
+
+type octo_macro struct {
+	args []string
+	body []octo_token
+}
+
+type octo_loop struct {
+	start  int
+	whiles []int // Addresses of jumps out of loop
+}
+
+// Kinds of label uses
+const (
+	octo_addr12    = iota // NNN of instruction
+	octo_addr16           // Address word after F000
+	octo_unpack_hi        // Nibble and high bits of address, in 6XNN
+	octo_unpack_lo        // Low byte of address, in 6XNN
+)
+
+type octo_fixup struct {
+	addr   int // Instruction or word the address goes to
+	label  string
+	kind   int
+	nibble int // High nibble of :unpack
+	line   int
+}
+
+const octo_origin = 0x200
+
+// Compiles source into ROM loaded at 0x200
+func octo_compile(src string) ([]uint8, error) {
+	c := &octo_compiler{
+		here:    octo_origin,
+		labels:  make(map[string]int),
+		consts:  make(map[string]int),
+		aliases: make(map[string]int),
+		macros:  make(map[string]*octo_macro),
+	}
+	for num, line := range strings.Split(src, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, text := range strings.Fields(line) {
+			c.tokens = append(c.tokens, octo_token{text: text, line: num + 1})
+		}
+	}
+	// Execution starts at 0x200: program that doesn't begin with main jumps there
+	has_jump := len(c.tokens) < 2 || c.tokens[0].text != ":" || c.tokens[1].text != "main"
+	if has_jump {
+		c.emit(0x10, 0x00)
+		c.fixups = append(c.fixups, octo_fixup{addr: octo_origin, label: "main", kind: octo_addr12})
+	}
+	for c.pos < len(c.tokens) {
+		if err := c.statement(); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.loops) > 0 {
+		return nil, fmt.Errorf("loop has no again")
+	}
+	if len(c.branches) > 0 {
+		return nil, fmt.Errorf("begin has no end")
+	}
+	for _, fixup := range c.fixups {
+		addr, ok := c.labels[fixup.label]
+		if !ok {
+			if fixup.label == "main" && has_jump {
+				return nil, fmt.Errorf("program has no main")
+			}
+			return nil, fmt.Errorf("line %d: undefined name %s", fixup.line, fixup.label)
+		}
+		if err := c.resolve(fixup, addr); err != nil {
+			return nil, err
+		}
+	}
+	return c.rom, nil
+}
+
+func (c *octo_compiler) resolve(fixup octo_fixup, addr int) error {
+	i := fixup.addr - octo_origin
+	switch fixup.kind {
+	case octo_addr12:
+		if addr > 0xFFF {
+			return fmt.Errorf("line %d: address %X of %s doesn't fit 12 bits", fixup.line, addr, fixup.label)
+		}
+		c.rom[i] |= uint8(addr >> 8)
+		c.rom[i+1] = uint8(addr)
+	case octo_addr16:
+		c.rom[i], c.rom[i+1] = uint8(addr>>8), uint8(addr)
+	case octo_unpack_hi:
+		c.rom[i+1] = uint8(fixup.nibble<<4 | addr>>8&0xF)
+	case octo_unpack_lo:
+		c.rom[i+1] = uint8(addr)
+	}
+	return nil
+}
+
+func (c *octo_compiler) emit(bytes ...uint8) {
+	for _, b := range bytes {
+		i := c.here - octo_origin
+		if i >= len(c.rom) {
+			c.rom = append(c.rom, make([]uint8, i+1-len(c.rom))...)
+		}
+		c.rom[i] = b
+		c.here++
+	}
+}
+
+func (c *octo_compiler) inst(op int) {
+	c.emit(uint8(op>>8), uint8(op))
+}
+
+func (c *octo_compiler) next() (octo_token, error) {
+	if c.pos >= len(c.tokens) {
+		return octo_token{}, fmt.Errorf("unexpected end of program")
+	}
+	c.pos++
+	return c.tokens[c.pos-1], nil
+}
+
+func (c *octo_compiler) peek() string {
+	if c.pos >= len(c.tokens) {
+		return ""
+	}
+	return c.tokens[c.pos].text
+}
+
+// Next token must be text
+func (c *octo_compiler) expect(text string) error {
+	tok, err := c.next()
+	if err == nil && tok.text != text {
+		err = fmt.Errorf("line %d: %s expected, got %s", tok.line, text, tok.text)
+	}
+	return err
+}
+
+func (c *octo_compiler) register() (int, error) {
+	tok, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	if reg, ok := octo_register(tok.text); ok {
+		return reg, nil
+	}
+	if reg, ok := c.aliases[tok.text]; ok {
+		return reg, nil
+	}
+	return 0, fmt.Errorf("line %d: register expected, got %s", tok.line, tok.text)
+}
+
+func octo_register(text string) (int, bool) {
+	if len(text) != 2 || text[0] != 'v' && text[0] != 'V' {
+		return 0, false
+	}
+	reg, err := strconv.ParseUint(text[1:], 16, 4)
+	return int(reg), err == nil
+}
+
+func (c *octo_compiler) is_register(text string) bool {
+	_, ok := octo_register(text)
+	_, alias := c.aliases[text]
+	return ok || alias
+}
+
+func octo_number(text string) (int, bool) {
+	neg := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(text, "-")
+	var n int64
+	var err error
+	switch {
+	case strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X"):
+		n, err = strconv.ParseInt(text[2:], 16, 32)
+	case strings.HasPrefix(text, "0b") || strings.HasPrefix(text, "0B"):
+		n, err = strconv.ParseInt(text[2:], 2, 32)
+	default:
+		n, err = strconv.ParseInt(text, 10, 32)
+	}
+	if neg {
+		n = -n
+	}
+	return int(n), err == nil
+}
+
+// Number, constant or defined label
+func (c *octo_compiler) value(tok octo_token) (int, error) {
+	if n, ok := octo_number(tok.text); ok {
+		return n, nil
+	}
+	if n, ok := c.consts[tok.text]; ok {
+		return n, nil
+	}
+	if n, ok := c.labels[tok.text]; ok {
+		return n, nil
+	}
+	return 0, fmt.Errorf("line %d: undefined name %s", tok.line, tok.text)
+}
+
+// Value that must fit byte, negative ones are two's complement
+func (c *octo_compiler) byte_value() (int, error) {
+	tok, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.value(tok)
+	if err == nil && (n < -128 || n > 0xFF) {
+		err = fmt.Errorf("line %d: %s doesn't fit byte", tok.line, tok.text)
+	}
+	return n & 0xFF, err
+}
+
+func (c *octo_compiler) nibble_value() (int, error) {
+	tok, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.value(tok)
+	if err == nil && (n < 0 || n > 0xF) {
+		err = fmt.Errorf("line %d: %s doesn't fit nibble", tok.line, tok.text)
+	}
+	return n, err
+}
+
+// Instruction with address operand, label may be defined later
+func (c *octo_compiler) addr_inst(op, kind int) error {
+	tok, err := c.next()
+	if err != nil {
+		return err
+	}
+	at := c.here
+	if kind == octo_addr16 {
+		c.inst(op)
+		at = c.here
+		op = 0
+	}
+	c.inst(op)
+	fixup := octo_fixup{addr: at, label: tok.text, kind: kind, line: tok.line}
+	if n, ok := octo_number(tok.text); ok {
+		return c.resolve(fixup, n)
+	}
+	if n, ok := c.consts[tok.text]; ok {
+		return c.resolve(fixup, n)
+	}
+	if n, ok := c.labels[tok.text]; ok {
+		return c.resolve(fixup, n)
+	}
+	c.fixups = append(c.fixups, fixup)
+	return nil
+}
+
+func (c *octo_compiler) define(tok octo_token, addr int) error {
+	if _, ok := c.labels[tok.text]; ok {
+		return fmt.Errorf("line %d: %s is already defined", tok.line, tok.text)
+	}
+	c.labels[tok.text] = addr
+	return nil
+}
+
+func (c *octo_compiler) statement() error {
+	tok, err := c.next()
+	if err != nil {
+		return err
+	}
+	simple := map[string]int{
+		";": 0x00EE, "return": 0x00EE, "clear": 0x00E0, "exit": 0x00FD, "hires": 0x00FF, "lores": 0x00FE,
+		"scroll-right": 0x00FB, "scroll-left": 0x00FC, "audio": 0xF002,
+	}
+	if op, ok := simple[tok.text]; ok {
+		c.inst(op)
+		return nil
+	}
+	if macro, ok := c.macros[tok.text]; ok {
+		return c.expand(macro)
+	}
+	if c.is_register(tok.text) {
+		c.pos--
+		return c.assignment()
+	}
+	switch tok.text {
+	case ":":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		return c.define(name, c.here)
+	case ":next": // Label of second byte of next instruction, for self-modifying code
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		return c.define(name, c.here+1)
+	case ":const":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		val, err := c.next()
+		if err != nil {
+			return err
+		}
+		n, err := c.value(val)
+		c.consts[name.text] = n
+		return err
+	case ":alias":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		reg, err := c.register()
+		c.aliases[name.text] = reg
+		return err
+	case ":unpack": // v0 := nibble and high bits of address, v1 := low byte
+		nibble, err := c.nibble_value()
+		if err != nil {
+			return err
+		}
+		label, err := c.next()
+		if err != nil {
+			return err
+		}
+		hi := octo_fixup{addr: c.here, label: label.text, kind: octo_unpack_hi, nibble: nibble, line: label.line}
+		c.inst(0x6000)
+		lo := octo_fixup{addr: c.here, label: label.text, kind: octo_unpack_lo, line: label.line}
+		c.inst(0x6100)
+		if addr, ok := c.labels[label.text]; ok {
+			c.resolve(hi, addr)
+			return c.resolve(lo, addr)
+		}
+		c.fixups = append(c.fixups, hi, lo)
+		return nil
+	case ":org":
+		val, err := c.next()
+		if err != nil {
+			return err
+		}
+		addr, err := c.value(val)
+		if err == nil && (addr < octo_origin || addr > 0xFFFF) {
+			err = fmt.Errorf("line %d: :org %s is out of program", val.line, val.text)
+		}
+		c.here = addr
+		return err
+	case ":byte":
+		n, err := c.byte_value()
+		c.emit(uint8(n))
+		return err
+	case ":call":
+		return c.addr_inst(0x2000, octo_addr12)
+	case ":breakpoint":
+		_, err := c.next()
+		return err
+	case ":monitor":
+		c.pos += 2
+		return nil
+	case ":macro":
+		return c.define_macro()
+	case "jump":
+		return c.addr_inst(0x1000, octo_addr12)
+	case "jump0":
+		return c.addr_inst(0xB000, octo_addr12)
+	case "native":
+		return c.addr_inst(0x0000, octo_addr12)
+	case "bcd", "saveflags", "loadflags":
+		x, err := c.register()
+		c.inst(map[string]int{"bcd": 0xF033, "saveflags": 0xF075, "loadflags": 0xF085}[tok.text] | x<<8)
+		return err
+	case "save", "load":
+		x, err := c.register()
+		if err != nil {
+			return err
+		}
+		if c.peek() == "-" { // XO-CHIP range
+			c.pos++
+			y, err := c.register()
+			c.inst(map[string]int{"save": 0x5002, "load": 0x5003}[tok.text] | x<<8 | y<<4)
+			return err
+		}
+		c.inst(map[string]int{"save": 0xF055, "load": 0xF065}[tok.text] | x<<8)
+		return nil
+	case "sprite":
+		x, err := c.register()
+		if err != nil {
+			return err
+		}
+		y, err := c.register()
+		if err != nil {
+			return err
+		}
+		n, err := c.nibble_value()
+		c.inst(0xD000 | x<<8 | y<<4 | n)
+		return err
+	case "scroll-down", "scroll-up":
+		n, err := c.nibble_value()
+		c.inst(map[string]int{"scroll-down": 0x00C0, "scroll-up": 0x00D0}[tok.text] | n)
+		return err
+	case "plane":
+		n, err := c.nibble_value()
+		c.inst(0xF001 | n<<8)
+		return err
+	case "delay", "buzzer", "pitch":
+		if err := c.expect(":="); err != nil {
+			return err
+		}
+		x, err := c.register()
+		c.inst(map[string]int{"delay": 0xF015, "buzzer": 0xF018, "pitch": 0xF03A}[tok.text] | x<<8)
+		return err
+	case "i", "I":
+		return c.index()
+	case "if":
+		return c.conditional()
+	case "else":
+		if len(c.branches) == 0 {
+			return fmt.Errorf("line %d: else without if begin", tok.line)
+		}
+		at := c.here
+		c.inst(0x1000) // Jump over else part, set by end
+		c.resolve(octo_fixup{addr: c.branches[len(c.branches)-1], kind: octo_addr12}, c.here)
+		c.branches[len(c.branches)-1] = at
+		return nil
+	case "end":
+		if len(c.branches) == 0 {
+			return fmt.Errorf("line %d: end without if begin", tok.line)
+		}
+		c.resolve(octo_fixup{addr: c.branches[len(c.branches)-1], kind: octo_addr12}, c.here)
+		c.branches = c.branches[:len(c.branches)-1]
+		return nil
+	case "loop":
+		c.loops = append(c.loops, octo_loop{start: c.here})
+		return nil
+	case "while":
+		if len(c.loops) == 0 {
+			return fmt.Errorf("line %d: while outside of loop", tok.line)
+		}
+		if err := c.condition(true); err != nil {
+			return err
+		}
+		loop := &c.loops[len(c.loops)-1]
+		loop.whiles = append(loop.whiles, c.here)
+		c.inst(0x1000)
+		return nil
+	case "again":
+		if len(c.loops) == 0 {
+			return fmt.Errorf("line %d: again without loop", tok.line)
+		}
+		loop := c.loops[len(c.loops)-1]
+		c.loops = c.loops[:len(c.loops)-1]
+		c.inst(0x1000 | loop.start)
+		for _, at := range loop.whiles {
+			c.resolve(octo_fixup{addr: at, kind: octo_addr12}, c.here)
+		}
+		return nil
+	case ":calc", ":stringmode", ":assert", ":pointer":
+		return fmt.Errorf("line %d: %s isn't supported", tok.line, tok.text)
+	}
+	if n, ok := octo_number(tok.text); ok {
+		if n < -128 || n > 0xFF {
+			return fmt.Errorf("line %d: %s doesn't fit byte", tok.line, tok.text)
+		}
+		c.emit(uint8(n))
+		return nil
+	}
+	if strings.HasPrefix(tok.text, ":") {
+		return fmt.Errorf("line %d: unknown directive %s", tok.line, tok.text)
+	}
+	c.pos-- // Call of subroutine
+	return c.addr_inst(0x2000, octo_addr12)
+}
+
+// vx := ..., vx += ... and other operations on register
+func (c *octo_compiler) assignment() error {
+	x, _ := c.register()
+	op, err := c.next()
+	if err != nil {
+		return err
+	}
+	rhs := c.peek()
+	if c.is_register(rhs) {
+		ops := map[string]int{":=": 0x0, "|=": 0x1, "&=": 0x2, "^=": 0x3, "+=": 0x4, "-=": 0x5, ">>=": 0x6, "=-": 0x7, "<<=": 0xE}
+		code, ok := ops[op.text]
+		if !ok {
+			return fmt.Errorf("line %d: unknown operation %s", op.line, op.text)
+		}
+		y, _ := c.register()
+		c.inst(0x8000 | x<<8 | y<<4 | code)
+		return nil
+	}
+	switch op.text {
+	case ":=":
+		switch rhs {
+		case "key":
+			c.pos++
+			c.inst(0xF00A | x<<8)
+			return nil
+		case "delay":
+			c.pos++
+			c.inst(0xF007 | x<<8)
+			return nil
+		case "random":
+			c.pos++
+			n, err := c.byte_value()
+			c.inst(0xC000 | x<<8 | n)
+			return err
+		}
+		n, err := c.byte_value()
+		c.inst(0x6000 | x<<8 | n)
+		return err
+	case "+=":
+		n, err := c.byte_value()
+		c.inst(0x7000 | x<<8 | n)
+		return err
+	case "-=":
+		n, err := c.byte_value()
+		c.inst(0x7000 | x<<8 | -n&0xFF)
+		return err
+	}
+	return fmt.Errorf("line %d: operation %s needs register", op.line, op.text)
+}
+
+func (c *octo_compiler) index() error {
+	op, err := c.next()
+	if err != nil {
+		return err
+	}
+	if op.text == "+=" {
+		x, err := c.register()
+		c.inst(0xF01E | x<<8)
+		return err
+	}
+	if op.text != ":=" {
+		return fmt.Errorf("line %d: unknown operation i %s", op.line, op.text)
+	}
+	switch c.peek() {
+	case "hex", "bighex":
+		kind, _ := c.next()
+		x, err := c.register()
+		c.inst(map[string]int{"hex": 0xF029, "bighex": 0xF030}[kind.text] | x<<8)
+		return err
+	case "long":
+		c.pos++
+		return c.addr_inst(0xF000, octo_addr16)
+	}
+	return c.addr_inst(0xA000, octo_addr12)
+}
+
+// if ... then statement, or if ... begin ... else ... end
+func (c *octo_compiler) conditional() error {
+	start := c.pos
+	for c.pos < len(c.tokens) && c.peek() != "then" && c.peek() != "begin" {
+		c.pos++
+	}
+	kind, err := c.next()
+	if err != nil {
+		return fmt.Errorf("if has no then or begin")
+	}
+	end := c.pos
+	c.pos = start
+	if kind.text == "then" { // Skip statement when condition is false
+		if err = c.condition(false); err != nil {
+			return err
+		}
+		c.pos = end
+		return c.statement()
+	}
+	if err = c.condition(true); err != nil {
+		return err
+	}
+	c.pos = end
+	c.branches = append(c.branches, c.here)
+	c.inst(0x1000) // Jump to else or end
+	return nil
+}
+
+// Emits instructions that skip next one when condition is skip_when
+func (c *octo_compiler) condition(skip_when bool) error {
+	x, err := c.register()
+	if err != nil {
+		return err
+	}
+	op, err := c.next()
+	if err != nil {
+		return err
+	}
+	switch op.text {
+	case "key", "-key":
+		if (op.text == "key") == skip_when {
+			c.inst(0xE09E | x<<8)
+		} else {
+			c.inst(0xE0A1 | x<<8)
+		}
+		return nil
+	case "==", "!=":
+		if (op.text == "==") != skip_when {
+			return c.compare(x, 0x4000, 0x9000)
+		}
+		return c.compare(x, 0x3000, 0x5000)
+	case "<", ">=", ">", "<=":
+		// VF := 1 when left >= right of subtraction, as it has no borrow
+		left, right := x, -1
+		if c.is_register(c.peek()) {
+			right, _ = c.register()
+		} else {
+			n, err := c.byte_value()
+			if err != nil {
+				return err
+			}
+			c.inst(0x6F00 | n)
+		}
+		if op.text == ">" || op.text == "<=" { // right >= x
+			if right >= 0 {
+				c.inst(0x8F00 | right<<4)
+			}
+			c.inst(0x8F05 | x<<4)
+		} else if right >= 0 { // x >= right
+			c.inst(0x8F00 | left<<4)
+			c.inst(0x8F05 | right<<4)
+		} else {
+			c.inst(0x8F07 | x<<4)
+		}
+		holds := 0 // VF when condition holds
+		if op.text == ">=" || op.text == "<=" {
+			holds = 1
+		}
+		if skip_when {
+			c.inst(0x3F00 | holds)
+		} else {
+			c.inst(0x4F00 | holds)
+		}
+		return nil
+	}
+	return fmt.Errorf("line %d: unknown condition %s", op.line, op.text)
+}
+
+// Skip of register compared with register or byte
+func (c *octo_compiler) compare(x, byte_op, reg_op int) error {
+	if c.is_register(c.peek()) {
+		y, _ := c.register()
+		c.inst(reg_op | x<<8 | y<<4)
+		return nil
+	}
+	n, err := c.byte_value()
+	c.inst(byte_op | x<<8 | n)
+	return err
+}
+
+// :macro name args { body }
+func (c *octo_compiler) define_macro() error {
+	name, err := c.next()
+	if err != nil {
+		return err
+	}
+	macro := &octo_macro{}
+	for {
+		tok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if tok.text == "{" {
+			break
+		}
+		macro.args = append(macro.args, tok.text)
+	}
+	for depth := 1; ; {
+		tok, err := c.next()
+		if err != nil {
+			return fmt.Errorf("line %d: macro %s has no }", name.line, name.text)
+		}
+		if tok.text == "{" {
+			depth++
+		} else if tok.text == "}" {
+			if depth--; depth == 0 {
+				break
+			}
+		}
+		macro.body = append(macro.body, tok)
+	}
+	c.macros[name.text] = macro
+	return nil
+}
+
+// Replaces call of macro with its body, arguments substituted
+func (c *octo_compiler) expand(macro *octo_macro) error {
+	args := make(map[string]string)
+	for _, arg := range macro.args {
+		tok, err := c.next()
+		if err != nil {
+			return err
+		}
+		args[arg] = tok.text
+	}
+	body := make([]octo_token, len(macro.body))
+	for i, tok := range macro.body {
+		if val, ok := args[tok.text]; ok {
+			tok.text = val
+		}
+		body[i] = tok
+	}
+	rest := append(body, c.tokens[c.pos:]...)
+	c.tokens = append(c.tokens[:c.pos], rest...)
+	return nil
+}
+
+// Cartridge of Octo: GIF image whose first frame carries program in low
+// nibbles of palette indexes, two pixels per byte. First four bytes are big
+// endian size of JSON payload: {"program": "<Octo source>", "options": {...}}
+type octo_cartridge struct {
+	Program string       `json:"program"`
+	Options octo_options `json:"options"`
+}
+
+// Octo options that chipigo has settings for
+type octo_options struct {
+	Tickrate        int    `json:"tickrate"`
+	FillColor       string `json:"fillColor"`
+	BackgroundColor string `json:"backgroundColor"`
+	ShiftQuirks     bool   `json:"shiftQuirks"`
+	LoadStoreQuirks bool   `json:"loadStoreQuirks"`
+	ClipQuirks      bool   `json:"clipQuirks"`
+	JumpQuirks      bool   `json:"jumpQuirks"`
+	VBlankQuirks    bool   `json:"vBlankQuirks"`
+	LogicQuirks     bool   `json:"logicQuirks"`
+	MaxSize         int    `json:"maxSize"` // 3215 is CHIP-8, 3583 SCHIP, 65024 XO-CHIP
+	FontStyle       string `json:"fontStyle"`
+}
+
+func is_gif(data []uint8) bool {
+	return bytes.HasPrefix(data, []uint8("GIF87a")) || bytes.HasPrefix(data, []uint8("GIF89a"))
+}
+
+func read_cartridge(data []uint8) (*octo_cartridge, error) {
+	img, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var payload []uint8
+	if len(img.Image) > 0 {
+		payload = cartridge_bytes(img.Image[0])
+	}
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("GIF is not Octo cartridge")
+	}
+	size := int(payload[0])<<24 | int(payload[1])<<16 | int(payload[2])<<8 | int(payload[3])
+	cart := &octo_cartridge{}
+	if size > len(payload)-4 || json.Unmarshal(payload[4:4+size], cart) != nil {
+		return nil, fmt.Errorf("GIF is not Octo cartridge")
+	}
+	return cart, nil
+}
+
+func cartridge_bytes(frame *image.Paletted) []uint8 {
+	bounds := frame.Bounds()
+	var nibbles []uint8
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			nibbles = append(nibbles, frame.ColorIndexAt(x, y)&0xF)
+		}
+	}
+	data := make([]uint8, len(nibbles)/2)
+	for i := range data {
+		data[i] = nibbles[2*i]<<4 | nibbles[2*i+1]
+	}
+	return data
+}
+
+// Sets platform, speed, colours, font and quirks of cartridge. SCHIP and
+// XO-CHIP cartridges are errors: chipigo has neither platform
+func (options *octo_options) apply(settings *CHIP8Settings, db *ROMDatabase) error {
+	switch {
+	case options.MaxSize > 3583:
+		return fmt.Errorf("Octo cartridge is for XO-CHIP (maxSize %d), chipigo doesn't have XO-CHIP", options.MaxSize)
+	case options.MaxSize > 3215:
+		return fmt.Errorf("Octo cartridge is for SCHIP (maxSize %d), chipigo doesn't have SCHIP; -platform megachip8 runs SCHIP instructions", options.MaxSize)
+	}
+	db.set_platform(settings, "modernChip8")
+	if options.Tickrate > 0 {
+		settings.cycles_per_frame = options.Tickrate
+	}
+	if color, err := parse_color(options.FillColor); err == nil {
+		settings.foreground = color
+	}
+	if color, err := parse_color(options.BackgroundColor); err == nil {
+		settings.background = color
+	}
+	if _, ok := chip8_fonts[options.FontStyle]; ok {
+		settings.font = options.FontStyle
+	}
+	settings.quirks = CHIP8Quirks{
+		Shift:                 options.ShiftQuirks,
+		MemoryLeaveIUnchanged: options.LoadStoreQuirks,
+		Wrap:                  !options.ClipQuirks,
+		Jump:                  options.JumpQuirks,
+		VBlank:                options.VBlankQuirks,
+		Logic:                 options.LogicQuirks,
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"strings"
+	"testing"
+)
+
+func TestOctoCompile(t *testing.T) {
+	tests := []struct {
+		src string
+		rom []uint8
+	}{
+		{": main v0 := 5 v0 += 1 loop again", []uint8{0x60, 0x05, 0x70, 0x01, 0x12, 0x04}},
+		{": main vf := key i := hex v3 sprite v1 v2 5 ;", []uint8{0xFF, 0x0A, 0xF3, 0x29, 0xD1, 0x25, 0x00, 0xEE}},
+		{"  : f ; : main f jump f", []uint8{0x12, 0x04, 0x00, 0xEE, 0x22, 0x02, 0x12, 0x02}}, // Jump to main
+		{": main i := data :const N 3 v0 := N : data 1 2 0xFF", []uint8{0xA2, 0x04, 0x60, 0x03, 0x01, 0x02, 0xFF}},
+		{":alias x v4 : main x -= 1 x <<= x if x == 2 then clear", []uint8{0x12, 0x02, 0x74, 0xFF, 0x84, 0x4E, 0x44, 0x02, 0x00, 0xE0}},
+		{": main if v1 != v2 begin v0 := 1 else v0 := 2 end", []uint8{0x91, 0x20, 0x12, 0x08, 0x60, 0x01, 0x12, 0x0A, 0x60, 0x02}},
+		{": main loop while v0 key v0 += 1 again", []uint8{0xE0, 0x9E, 0x12, 0x08, 0x70, 0x01, 0x12, 0x00}},
+		{": main if v1 < 9 then return", []uint8{0x6F, 0x09, 0x8F, 0x17, 0x4F, 0x00, 0x00, 0xEE}},
+		{": main :unpack 0xA data : data 7", []uint8{0x60, 0xA2, 0x61, 0x04, 0x07}},
+		{":macro twice r { r += r } : main twice v2", []uint8{0x12, 0x02, 0x82, 0x24}},
+		{": main :next target v0 := 0 save v3 load v1 - v2 bcd v9 # comment", []uint8{0x60, 0x00, 0xF3, 0x55, 0x51, 0x23, 0xF9, 0x33}},
+		{": main i := long far :org 0x1000 : far 1", nil},
+	}
+	for _, test := range tests {
+		rom, err := octo_compile(test.src)
+		if err != nil {
+			t.Errorf("%s: %s", test.src, err.Error())
+			continue
+		}
+		if test.rom != nil && !bytes.Equal(rom, test.rom) {
+			t.Errorf("%s: % X, % X expected", test.src, rom, test.rom)
+		}
+	}
+	rom, _ := octo_compile(": main i := long far :org 0x1000 : far 1")
+	if !bytes.Equal(rom[:4], []uint8{0xF0, 0x00, 0x10, 0x00}) || len(rom) != 0xE01 {
+		t.Errorf("i := long: % X, %d bytes", rom[:4], len(rom))
+	}
+}
+
+func TestOctoCompileErrors(t *testing.T) {
+	tests := map[string]string{
+		"v0 := 1":                "no main",
+		": main jump nowhere":    "line 1: undefined name nowhere",
+		": main\nv0 := 300":      "line 2: 300 doesn't fit byte",
+		": main : main":          "already defined",
+		": main loop":            "loop has no again",
+		": main :calc x { 1 }":   ":calc isn't supported",
+		": main if v0 == 1 then": "unexpected end",
+		": main i -= v0":         "unknown operation",
+	}
+	for src, msg := range tests {
+		if _, err := octo_compile(src); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: error %v, %q expected", src, err, msg)
+		}
+	}
+}
+
+// Runs compiled conditions: VA counts conditions that hold
+func TestOctoConditions(t *testing.T) {
+	src := `: main
+		v1 := 5 v2 := 7 va := 0
+		if v1 < v2 then va += 1
+		if v1 <= 5 then va += 1
+		if v2 > v1 then va += 1
+		if v2 >= 8 then va += 0x10
+		if v1 > 5 then va += 0x10
+		if v1 != 5 then va += 0x10
+		loop again`
+	rom, err := octo_compile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	console := new_rom_console(t, rom, "modernChip8")
+	for i := 0; i < 40; i++ {
+		console.tick()
+	}
+	if va := console.cpu.(*CHIP8CPU).v[0xA]; va != 3 {
+		t.Errorf("VA is %02X, 3 expected", va)
+	}
+}
+
+// GIF with payload in low nibbles of pixels, the way Octo saves cartridges
+func make_cartridge(t *testing.T, payload string) []uint8 {
+	data := append([]uint8{uint8(len(payload) >> 24), uint8(len(payload) >> 16), uint8(len(payload) >> 8), uint8(len(payload))}, payload...)
+	palette := make(color.Palette, 16)
+	for i := range palette {
+		palette[i] = color.Gray{uint8(i * 16)}
+	}
+	img := image.NewPaletted(image.Rect(0, 0, 64, len(data)/32+1), palette)
+	for i, b := range data {
+		img.Pix[2*i], img.Pix[2*i+1] = b>>4, b&0xF
+	}
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -2,11 +2,129 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
 )
 
 func (cpu *CHIP8CPU) op_0NNN(op OpCode, console *CHIP8Console) { // 0NNN - Calls RCA 1802 program at address NNN.
-	fmt.Printf("0NNN is not supported\n")
+	if cpu.platform&PlatformHybridVIP == 0 {
+		fmt.Printf("0NNN is not supported\n")
+		return
+	}
+	cycles := console.get_vip().call(cpu, op, uint16(op&0x0FFF))
+	if cpu.vip_timing {
+		cpu.cycles += cycles
+	}
+}
+
+func (cpu *CHIP8CPU) op_0010(op OpCode, console *CHIP8Console) { // 0010 - MegaChip. Turns colour mode off, display is 64x32 again.
+	cpu.mega = false
+	console.gpu.set_mega(false)
+}
+
+func (cpu *CHIP8CPU) op_0011(op OpCode, console *CHIP8Console) { // 0011 - MegaChip. Turns 256x192 colour mode on.
+	cpu.mega = true
+	cpu.sprite_w = 0
+	cpu.sprite_h = 0
+	console.gpu.set_mega(true)
+}
+
+func (cpu *CHIP8CPU) op_00BN(op OpCode, console *CHIP8Console) { // 00BN - MegaChip. Scrolls display N lines up.
+	console.gpu.scroll(0, -int(op&0x000F))
+}
+
+func (cpu *CHIP8CPU) op_00CN(op OpCode, console *CHIP8Console) { // 00CN - SCHIP. Scrolls display N lines down.
+	console.gpu.scroll(0, int(op&0x000F))
+}
+
+func (cpu *CHIP8CPU) op_00FB(op OpCode, console *CHIP8Console) { // 00FB - SCHIP. Scrolls display 4 pixels right.
+	console.gpu.scroll(4, 0)
+}
+
+func (cpu *CHIP8CPU) op_00F8(op OpCode, console *CHIP8Console) { // 00F8 - ETI-660. Sets tone pitch to V0.
+	console.sound.set_pitch(uint8(cpu.v[0]))
+}
+
+func (cpu *CHIP8CPU) op_00FC(op OpCode, console *CHIP8Console) { // 00FC - SCHIP. Scrolls display 4 pixels left. ETI-660. Cycles background colour.
+	if cpu.platform&PlatformETI660 != 0 {
+		console.gpu.cycle_background()
+		return
+	}
+	console.gpu.scroll(-4, 0)
+}
+
+func (cpu *CHIP8CPU) op_00FD(op OpCode, console *CHIP8Console) { // 00FD - SCHIP. Exits interpreter: program stops here.
+	cpu.pc -= 2
+}
+
+func (cpu *CHIP8CPU) op_00FE(op OpCode, console *CHIP8Console) { // 00FE - SCHIP. Low resolution, 64x32.
+	cpu.mega = false
+	console.gpu.set_size(64, 32)
+}
+
+func (cpu *CHIP8CPU) op_00FF(op OpCode, console *CHIP8Console) { // 00FF - SCHIP. High resolution, 128x64.
+	cpu.mega = false
+	console.gpu.set_size(128, 64)
+}
+
+func (cpu *CHIP8CPU) op_01NN(op OpCode, console *CHIP8Console) { // 01NN NNNN - MegaChip. Sets I to 24-bit address NN and the next word.
+	cpu.i = uint32(op&0x00FF)<<16 | uint32(console.mem.read2(uint32(cpu.pc)))
+	cpu.pc += 2
+}
+
+func (cpu *CHIP8CPU) op_02NN(op OpCode, console *CHIP8Console) { // 02NN - MegaChip. Loads NN ARGB colours from I into palette from index 1.
+	n := uint32(op & 0x00FF)
+	var i uint32
+	for i = 0; i < n; i++ {
+		addr := cpu.i + 4*i
+		argb := uint32(console.mem.read2(addr))<<16 | uint32(console.mem.read2(addr+2))
+		console.gpu.set_palette(uint8(i+1), argb)
+	}
+}
+
+func (cpu *CHIP8CPU) op_03NN(op OpCode, console *CHIP8Console) { // 03NN - MegaChip. Sets sprite width to NN, 0 is 256.
+	cpu.sprite_w = int(op & 0x00FF)
+	if cpu.sprite_w == 0 {
+		cpu.sprite_w = 256
+	}
+}
+
+func (cpu *CHIP8CPU) op_04NN(op OpCode, console *CHIP8Console) { // 04NN - MegaChip. Sets sprite height to NN, 0 is 256.
+	cpu.sprite_h = int(op & 0x00FF)
+	if cpu.sprite_h == 0 {
+		cpu.sprite_h = 256
+	}
+}
+
+func (cpu *CHIP8CPU) op_05NN(op OpCode, console *CHIP8Console) { // 05NN - MegaChip. Sets screen alpha to NN.
+	console.gpu.set_alpha(uint8(op & 0x00FF))
+}
+
+// Sound at I has 6 bytes header: 2 bytes sample rate, 3 bytes length and
+// reserved byte. 8-bit unsigned samples follow it
+func (cpu *CHIP8CPU) op_060N(op OpCode, console *CHIP8Console) { // 060N - MegaChip. Plays digitised sound at I, looped if N is 0.
+	mem := console.mem
+	rate := int(mem.read2(cpu.i))
+	length := uint32(mem.read(cpu.i+2))<<16 | uint32(mem.read2(cpu.i+3))
+	data := make([]uint8, length)
+	for i := range data {
+		data[i] = mem.read(cpu.i + 6 + uint32(i))
+	}
+	console.sound.play_sample(data, rate, op&0x000F == 0)
+}
+
+func (cpu *CHIP8CPU) op_0700(op OpCode, console *CHIP8Console) { // 0700 - MegaChip. Stops digitised sound.
+	console.sound.stop_sample()
+}
+
+func (cpu *CHIP8CPU) op_080N(op OpCode, console *CHIP8Console) { // 080N - MegaChip. Sets sprite blending: normal, 25%, 50%, 75%, add, multiply.
+	console.gpu.set_blend(uint8(op & 0x000F))
+}
+
+func (cpu *CHIP8CPU) op_09NN(op OpCode, console *CHIP8Console) { // 09NN - MegaChip. Sets collision colour to palette index NN.
+	cpu.collision = uint8(op & 0x00FF)
+}
+
+func (cpu *CHIP8CPU) op_02A0(op OpCode, console *CHIP8Console) { // 02A0 - CHIP-8X. Cycles background colour: blue, black, green, red.
+	console.gpu.cycle_background()
 }
 
 func (cpu *CHIP8CPU) op_00E0(op OpCode, console *CHIP8Console) { // 00E0 - Clears the screen.
@@ -14,14 +132,12 @@ func (cpu *CHIP8CPU) op_00E0(op OpCode, console *CHIP8Console) { // 00E0 - Clear
 }
 
 func (cpu *CHIP8CPU) op_00EE(op OpCode, console *CHIP8Console) { // 00EE - Returns from a subroutine.
-	var addr uint16
-	cpu.sp += 2
-	if cpu.sp > 0x70 {
+	if cpu.sp >= cpu.stack {
 		fmt.Printf("Stack underflow\n")
+		return
 	}
-	addr = 0
-	addr |= console.mem.read2(uint32(cpu.sp))
-	cpu.pc = addr
+	cpu.sp += 2
+	cpu.pc = console.mem.read2(uint32(cpu.sp))
 }
 
 func (cpu *CHIP8CPU) op_1NNN(op OpCode, console *CHIP8Console) { // 1NNN - Jumps to address NNN.
@@ -29,12 +145,13 @@ func (cpu *CHIP8CPU) op_1NNN(op OpCode, console *CHIP8Console) { // 1NNN - Jumps
 }
 
 func (cpu *CHIP8CPU) op_2NNN(op OpCode, console *CHIP8Console) { // 2NNN - Calls subroutine at NNN.
+	if cpu.sp < cpu.stack-(stack_top-stack_bottom) {
+		fmt.Printf("Stack overflow\n")
+		return
+	}
 	console.mem.write(uint32(cpu.sp), uint8(cpu.pc&0xFF00>>8))
 	console.mem.write(uint32(cpu.sp+1), uint8(cpu.pc&0x00FF))
 	cpu.sp -= 2
-	if cpu.sp < 0x50 {
-		fmt.Printf("Stack overflow\n")
-	}
 	cpu.pc = uint16(op & 0x0FFF)
 }
 
@@ -62,6 +179,12 @@ func (cpu *CHIP8CPU) op_5XY0(op OpCode, console *CHIP8Console) { // 5XY0 - Skips
 	}
 }
 
+func (cpu *CHIP8CPU) op_5XY1(op OpCode, console *CHIP8Console) { // 5XY1 - CHIP-8X. Adds VY to VX, each nibble separately in 3 bits.
+	x := uint16((op & 0x0F00) >> 8)
+	y := uint16((op & 0x00F0) >> 4)
+	cpu.v[x] = ((cpu.v[x] & 0x77) + (cpu.v[y] & 0x77)) & 0x77
+}
+
 func (cpu *CHIP8CPU) op_6XNN(op OpCode, console *CHIP8Console) { // 6XNN - Sets VX to NN.
 	x := uint16((op & 0x0F00) >> 8)
 	n := Registr(op & 0x00FF)
@@ -84,18 +207,27 @@ func (cpu *CHIP8CPU) op_8XY1(op OpCode, console *CHIP8Console) { // 8XY1 - Sets
 	x := uint16((op & 0x0F00) >> 8)
 	y := uint16((op & 0x00F0) >> 4)
 	cpu.v[x] = cpu.v[x] | cpu.v[y]
+	if cpu.quirks.Logic {
+		cpu.v[0xF] = 0
+	}
 }
 
 func (cpu *CHIP8CPU) op_8XY2(op OpCode, console *CHIP8Console) { // 8XY2 - Sets VX to VX and VY.
 	x := uint16((op & 0x0F00) >> 8)
 	y := uint16((op & 0x00F0) >> 4)
 	cpu.v[x] = cpu.v[x] & cpu.v[y]
+	if cpu.quirks.Logic {
+		cpu.v[0xF] = 0
+	}
 }
 
 func (cpu *CHIP8CPU) op_8XY3(op OpCode, console *CHIP8Console) { // 8XY3 - Sets VX to VX xor VY.
 	x := uint16((op & 0x0F00) >> 8)
 	y := uint16((op & 0x00F0) >> 4)
 	cpu.v[x] = cpu.v[x] ^ cpu.v[y]
+	if cpu.quirks.Logic {
+		cpu.v[0xF] = 0
+	}
 }
 
 func (cpu *CHIP8CPU) op_8XY4(op OpCode, console *CHIP8Console) { // 8XY4 - Adds VY to VX. VF is set to 1 when there's a carry, and to 0 when there isn't.
@@ -103,46 +235,54 @@ func (cpu *CHIP8CPU) op_8XY4(op OpCode, console *CHIP8Console) { // 8XY4 - Adds
 	y := uint16((op & 0x00F0) >> 4)
 	var sum uint16
 	sum = uint16(cpu.v[x]) + uint16(cpu.v[y])
-	if sum > 0xFF {
-		cpu.v[0xF] = 1
-	} else {
-		cpu.v[0xF] = 0
-	}
 	cpu.v[x] = Registr(sum & 0xFF)
+	cpu.v[0xF] = Registr(sum >> 8) // Flag is written last, VF as VX holds it
 }
 
 func (cpu *CHIP8CPU) op_8XY5(op OpCode, console *CHIP8Console) { // 8XY5 - VY is subtracted from VX. VF is set to 0 when there's a borrow, and 1 when there isn't.
 	x := uint16((op & 0x0F00) >> 8)
 	y := uint16((op & 0x00F0) >> 4)
-	if cpu.v[x] > cpu.v[y] {
-		cpu.v[0xF] = 1
-	} else {
-		cpu.v[0xF] = 0
-	}
+	flag := borrow_flag(cpu.v[x], cpu.v[y])
 	cpu.v[x] = cpu.v[x] - cpu.v[y]
+	cpu.v[0xF] = flag
 }
 
 func (cpu *CHIP8CPU) op_8XY6(op OpCode, console *CHIP8Console) { // 8XY6 - Shifts VX right by one. VF is set to the value of the least significant bit of VX before the shift.[2]
 	x := uint16((op & 0x0F00) >> 8)
-	cpu.v[0xF] = cpu.v[x] & 0x0001
+	y := uint16((op & 0x00F0) >> 4)
+	if !cpu.quirks.Shift { // Original interpreter shifts VY
+		cpu.v[x] = cpu.v[y]
+	}
+	flag := cpu.v[x] & 0x0001
 	cpu.v[x] = cpu.v[x] >> 1
+	cpu.v[0xF] = flag
 }
 
 func (cpu *CHIP8CPU) op_8XY7(op OpCode, console *CHIP8Console) { // 8XY7 - Sets VX to VY minus VX. VF is set to 0 when there's a borrow, and 1 when there isn't.
 	x := uint16((op & 0x0F00) >> 8)
 	y := uint16((op & 0x00F0) >> 4)
-	if cpu.v[y] > cpu.v[x] {
-		cpu.v[0xF] = 1
-	} else {
-		cpu.v[0xF] = 0
-	}
+	flag := borrow_flag(cpu.v[y], cpu.v[x])
 	cpu.v[x] = cpu.v[y] - cpu.v[x]
+	cpu.v[0xF] = flag
+}
+
+// 1 when a - b has no borrow
+func borrow_flag(a, b Registr) Registr {
+	if a >= b {
+		return 1
+	}
+	return 0
 }
 
 func (cpu *CHIP8CPU) op_8XYE(op OpCode, console *CHIP8Console) { // 8XYE -  Shifts VX left by one. VF is set to the value of the most significant bit of VX before the shift.[2]
 	x := uint16((op & 0x0F00) >> 8)
-	cpu.v[0xF] = (cpu.v[x] >> 7) & 1
+	y := uint16((op & 0x00F0) >> 4)
+	if !cpu.quirks.Shift { // Original interpreter shifts VY
+		cpu.v[x] = cpu.v[y]
+	}
+	flag := (cpu.v[x] >> 7) & 1
 	cpu.v[x] = cpu.v[x] << 1
+	cpu.v[0xF] = flag
 }
 
 func (cpu *CHIP8CPU) op_9XY0(op OpCode, console *CHIP8Console) { // 9XY0 -  Skips the next instruction if VX doesn't equal VY.
@@ -154,31 +294,102 @@ func (cpu *CHIP8CPU) op_9XY0(op OpCode, console *CHIP8Console) { // 9XY0 -  Skip
 }
 
 func (cpu *CHIP8CPU) op_ANNN(op OpCode, console *CHIP8Console) { // ANNN -  Sets I to the address NNN.
-	cpu.i = uint16(op) & 0x0FFF
+	cpu.i = uint32(op & 0x0FFF)
 }
 
 func (cpu *CHIP8CPU) op_BNNN(op OpCode, console *CHIP8Console) { // BNNN -  Jumps to the address NNN plus V0.
+	if cpu.quirks.Jump { // CHIP-48 reads it as BXNN and adds VX
+		cpu.pc = uint16(op&0x0FFF) + uint16(cpu.v[(op&0x0F00)>>8])
+		return
+	}
 	cpu.pc = uint16(op&0x0FFF) + uint16(cpu.v[0x0])
 }
 
+// Colour board divides screen into zones of 8x4 pixels. VX is horizontal:
+// low nibble is first zone, high nibble is count of zones more to the right.
+// VX+1 is vertical the same way. Colour is low 3 bits of VY
+func (cpu *CHIP8CPU) op_BXY0(op OpCode, console *CHIP8Console) { // BXY0 - CHIP-8X. Colours zones given by VX and VX+1 with colour VY.
+	x := uint16((op & 0x0F00) >> 8)
+	y := uint16((op & 0x00F0) >> 4)
+	h, v := cpu.v[x], cpu.v[(x+1)&0xF]
+	console.gpu.color_zones(int(h&0xF), int(v&0xF)*4, int(h>>4)+1, (int(v>>4)+1)*4, uint8(cpu.v[y]&7))
+}
+
+// Colours N rows of 8 pixels wide zone at pixel (VX, VX+1)
+func (cpu *CHIP8CPU) op_BXYN(op OpCode, console *CHIP8Console) { // BXYN - CHIP-8X. Colours N rows at VX, VX+1 with colour VY.
+	x := uint16((op & 0x0F00) >> 8)
+	y := uint16((op & 0x00F0) >> 4)
+	n := int(op & 0x000F)
+	console.gpu.color_zones(int(cpu.v[x])/8, int(cpu.v[(x+1)&0xF]), 1, n, uint8(cpu.v[y]&7))
+}
+
 func (cpu *CHIP8CPU) op_CXNN(op OpCode, console *CHIP8Console) { // CXNN -  Sets VX to a random number and NN.
 	x := uint16((op & 0x0F00) >> 8)
 	n := uint16(op & 0x00FF)
-	cpu.v[x] = Registr(uint16(rand.Int()) & n)
+	cpu.v[x] = Registr(uint16(console.random()) & n)
 }
 
 func (cpu *CHIP8CPU) op_DXYN(op OpCode, console *CHIP8Console) { // DXYN -  Draws a sprite at coordinate (VX, VY) that has a width of 8 pixels and a height of N pixels. Each row of 8 pixels is read as bit-coded (with the most significant bit of each byte displayed on the left) starting from memory location I; I value doesn't change after the execution of this instruction. As described above, VF is set to 1 if any screen pixels are flipped from set to unset when the sprite is drawn, and to 0 if that doesn't happen.
 	x := uint16((op & 0x0F00) >> 8)
 	y := uint16((op & 0x00F0) >> 4)
 	n := uint16(op & 0x000F)
-	var vx, vy int8
-	var i uint16
-	vx = int8(cpu.v[x])
-	vy = int8(cpu.v[y])
+	if cpu.quirks.VBlank && !cpu.vblanked { // Wait for display interrupt
+		cpu.pc -= 2
+		if cpu.vip_timing { // CPU idles till the end of frame
+			cpu.cycles = vip_frame_cycles
+		}
+		return
+	}
+	cpu.vblanked = false
+	if cpu.mega {
+		cpu.draw_mega_sprite(int(cpu.v[x]), int(cpu.v[y]), console)
+		return
+	}
+	rows, cols := int(n), 1
+	if n == 0 && cpu.platform&PlatformMegaChip != 0 { // SCHIP 16x16 sprite, 2 bytes per row
+		rows, cols = 16, 2
+	}
+	// Sprite starts on screen, then it wraps around or is clipped by its edges.
+	// Display sizes like 48 aren't divisors of 256, so VX and VY can't wrap as int8
+	w, h := console.gpu.size()
+	vx := int8(int(cpu.v[x]) % w)
+	vy := int8(int(cpu.v[y]) % h)
 	cpu.v[0xF] = 0
-	for i = 0; i < n; i++ {
-		if console.gpu.draw_line8(vx, vy+int8(i), console.mem.read(uint32(cpu.i+i))) == 1 {
-			cpu.v[0xF] = 1
+	for i := 0; i < rows; i++ {
+		if !cpu.quirks.Wrap && int(vy)+i >= h {
+			break
+		}
+		for c := 0; c < cols; c++ {
+			line := console.mem.read(cpu.i + uint32(i*cols+c))
+			cx := int(vx) + 8*c
+			if !cpu.quirks.Wrap {
+				if cx >= w {
+					break
+				}
+				if cx+8 > w {
+					line &= 0xFF << uint(cx+8-w)
+				}
+			}
+			if console.gpu.draw_line8(int8(cx), vy+int8(i), line) == 1 {
+				cpu.v[0xF] = 1
+			}
+		}
+	}
+}
+
+// MegaChip sprite of sprite_w x sprite_h palette indexes at I, index 0 is
+// transparent. VF is set when sprite covers a pixel of collision colour
+func (cpu *CHIP8CPU) draw_mega_sprite(x, y int, console *CHIP8Console) {
+	cpu.v[0xF] = 0
+	for row := 0; row < cpu.sprite_h; row++ {
+		for col := 0; col < cpu.sprite_w; col++ {
+			index := console.mem.read(cpu.i + uint32(row*cpu.sprite_w+col))
+			if index == 0 {
+				continue
+			}
+			if old := console.gpu.draw_mega(x+col, y+row, index); old != 0 && old == cpu.collision {
+				cpu.v[0xF] = 1
+			}
 		}
 	}
 }
@@ -197,6 +408,20 @@ func (cpu *CHIP8CPU) op_EXA1(op OpCode, console *CHIP8Console) { // EXA1 -  Skip
 	}
 }
 
+func (cpu *CHIP8CPU) op_EXF2(op OpCode, console *CHIP8Console) { // EXF2 - CHIP-8X. Skips the next instruction if the key stored in VX is pressed on second keypad.
+	x := uint16((op & 0x0F00) >> 8)
+	if console.input.is_pressed2(uint8(cpu.v[x])) {
+		cpu.pc += 2
+	}
+}
+
+func (cpu *CHIP8CPU) op_EXF5(op OpCode, console *CHIP8Console) { // EXF5 - CHIP-8X. Skips the next instruction if the key stored in VX isn't pressed on second keypad.
+	x := uint16((op & 0x0F00) >> 8)
+	if !console.input.is_pressed2(uint8(cpu.v[x])) {
+		cpu.pc += 2
+	}
+}
+
 func (cpu *CHIP8CPU) op_FX07(op OpCode, console *CHIP8Console) { // FX07 -  Sets VX to the value of the delay timer.
 	x := uint16((op & 0x0F00) >> 8)
 	cpu.v[x] = Registr(cpu.dt)
@@ -226,8 +451,12 @@ func (cpu *CHIP8CPU) op_FX18(op OpCode, console *CHIP8Console) { // FX18 -  Sets
 func (cpu *CHIP8CPU) op_FX1E(op OpCode, console *CHIP8Console) { // FX1E -  Adds VX to I.[3]
 	// Note: VF is set to 1 when range overflow (I+VX>0xFFF), and 0 when there isn't.
 	// This is undocumented feature of the Chip-8 and used by Spacefight 2019! game.
+	// Platforms with more than 4K of memory, MegaChip's 24-bit I, leave VF alone.
 	x := uint16((op & 0x0F00) >> 8)
-	cpu.i += uint16(cpu.v[x])
+	cpu.i += uint32(cpu.v[x])
+	if platform_spec(cpu.platform).memory != 0 {
+		return
+	}
 	if cpu.i > 0xFFF {
 		cpu.v[0xF] = 1
 	} else {
@@ -237,7 +466,12 @@ func (cpu *CHIP8CPU) op_FX1E(op OpCode, console *CHIP8Console) { // FX1E -  Adds
 
 func (cpu *CHIP8CPU) op_FX29(op OpCode, console *CHIP8Console) { // FX29 -  Sets I to the location of the sprite for the character in VX. Characters 0-F (in hexadecimal) are represented by a 4x5 font.
 	x := uint16((op & 0x0F00) >> 8)
-	cpu.i = uint16(cpu.v[x]) * 0x5 // 0x5 == size of one char in bytes
+	cpu.i = cpu.font + uint32(cpu.v[x])*small_glyph_size
+}
+
+func (cpu *CHIP8CPU) op_FX30(op OpCode, console *CHIP8Console) { // FX30 - SCHIP. Sets I to the location of the 8x10 sprite for the character in VX.
+	x := uint16((op & 0x0F00) >> 8)
+	cpu.i = cpu.big_font + uint32(cpu.v[x])*big_glyph_size
 }
 
 func (cpu *CHIP8CPU) op_FX33(op OpCode, console *CHIP8Console) { // FX33 -  Stores the Binary-coded decimal representation of VX, with the most significant of three digits at the address in I, the middle digit at I plus 1, and the least significant digit at I plus 2. (In other words, take the decimal representation of VX, place the hundreds digit in memory at location in I, the tens digit at location I+1, and the ones digit at location I+2.)
@@ -246,16 +480,23 @@ func (cpu *CHIP8CPU) op_FX33(op OpCode, console *CHIP8Console) { // FX33 -  Stor
 	a = uint16(cpu.v[x]) % 10
 	b = uint16(cpu.v[x]) / 10 % 10
 	c = uint16(cpu.v[x]) / 100
-	console.mem.write(uint32(cpu.i), uint8(c))
-	console.mem.write(uint32(cpu.i)+1, uint8(b))
-	console.mem.write(uint32(cpu.i)+2, uint8(a))
+	console.mem.write(cpu.i, uint8(c))
+	console.mem.write(cpu.i+1, uint8(b))
+	console.mem.write(cpu.i+2, uint8(a))
 }
 
 func (cpu *CHIP8CPU) op_FX55(op OpCode, console *CHIP8Console) { // FX55 -  Stores V0 to VX in memory starting at address I.[4]
 	x := uint16((op & 0x0F00) >> 8)
 	var i uint16
 	for i = 0; i <= x; i++ {
-		console.mem.write(uint32(cpu.i+i), uint8(cpu.v[i]))
+		console.mem.write(cpu.i+uint32(i), uint8(cpu.v[i]))
+	}
+	if !cpu.quirks.MemoryLeaveIUnchanged {
+		if cpu.quirks.MemoryIncrementByX {
+			cpu.i += uint32(x)
+		} else {
+			cpu.i += uint32(x) + 1
+		}
 	}
 }
 
@@ -263,8 +504,50 @@ func (cpu *CHIP8CPU) op_FX65(op OpCode, console *CHIP8Console) { // FX65 -  Fill
 	x := uint16((op & 0x0F00) >> 8)
 	var i uint16
 	for i = 0; i <= x; i++ {
-		cpu.v[i] = Registr(console.mem.read(uint32(cpu.i + i)))
+		cpu.v[i] = Registr(console.mem.read(cpu.i + uint32(i)))
+	}
+	if !cpu.quirks.MemoryLeaveIUnchanged {
+		if cpu.quirks.MemoryIncrementByX {
+			cpu.i += uint32(x)
+		} else {
+			cpu.i += uint32(x) + 1
+		}
+	}
+}
+
+func (cpu *CHIP8CPU) op_FX75(op OpCode, console *CHIP8Console) { // FX75 - SCHIP. Stores V0 to VX in RPL flags (X <= 7).
+	x := int((op & 0x0F00) >> 8)
+	for i := 0; i <= x && i < len(cpu.rpl); i++ {
+		cpu.rpl[i] = cpu.v[i]
+	}
+}
+
+func (cpu *CHIP8CPU) op_FX85(op OpCode, console *CHIP8Console) { // FX85 - SCHIP. Fills V0 to VX from RPL flags (X <= 7).
+	x := int((op & 0x0F00) >> 8)
+	for i := 0; i <= x && i < len(cpu.rpl); i++ {
+		cpu.v[i] = cpu.rpl[i]
+	}
+}
+
+func (cpu *CHIP8CPU) op_FXF8(op OpCode, console *CHIP8Console) { // FXF8 - CHIP-8X. Outputs VX to I/O port.
+	x := uint16((op & 0x0F00) >> 8)
+	if console.port != nil {
+		console.port.out(uint8(cpu.v[x]))
+	}
+}
+
+func (cpu *CHIP8CPU) op_FXFB(op OpCode, console *CHIP8Console) { // FXFB - CHIP-8X. Waits for input from I/O port and stores it in VX.
+	x := uint16((op & 0x0F00) >> 8)
+	if console.port == nil { // Nothing is connected, input is 0
+		cpu.v[x] = 0
+		return
+	}
+	val, ready := console.port.in()
+	if !ready {
+		cpu.pc -= 2 // Wait like FX0A
+		return
 	}
+	cpu.v[x] = Registr(val)
 }
 
 /*
@@ -275,38 +558,70 @@ Legend:
 [B] - implemented with bugs
 
        Opcode   Explanation
-[\]    00E0     Clears the screen.
-[\]    00EE     Returns from a subroutine.
-[\]    1NNN     Jumps to address NNN.
-[\]    2NNN     Calls subroutine at NNN.
-[\]    3XNN     Skips the next instruction if VX equals NN.
-[\]    4XNN     Skips the next instruction if VX doesn't equal NN.
-[\]    5XY0     Skips the next instruction if VX equals VY.
-[\]    6XNN     Sets VX to NN.
-[\]    7XNN     Adds NN to VX.
-[\]    8XY0     Sets VX to the value of VY.
-[\]    8XY1     Sets VX to VX or VY.
-[\]    8XY2     Sets VX to VX and VY.
-[\]    8XY3     Sets VX to VX xor VY.
-[\]    8XY4     Adds VY to VX. VF is set to 1 when there's a carry, and to 0 when there isn't.
-[\]    8XY5     VY is subtracted from VX. VF is set to 0 when there's a borrow, and 1 when there isn't.
-[\]    8XY6     Shifts VX right by one. VF is set to the value of the least significant bit of VX before the shift.[2]
-[\]    8XY7     Sets VX to VY minus VX. VF is set to 0 when there's a borrow, and 1 when there isn't.
-[\]    8XYE     Shifts VX left by one. VF is set to the value of the most significant bit of VX before the shift.[2]
-[\]    9XY0     Skips the next instruction if VX doesn't equal VY.
-[\]    ANNN     Sets I to the address NNN.
-[\]    BNNN     Jumps to the address NNN plus V0.
-[\]    CXNN     Sets VX to a random number and NN.
-[\]    DXYN     Draws a sprite at coordinate (VX, VY) that has a width of 8 pixels and a height of N pixels. Each row of 8 pixels is read as bit-coded (with the most significant bit of each byte displayed on the left) starting from memory location I; I value doesn't change after the execution of this instruction. As described above, VF is set to 1 if any screen pixels are flipped from set to unset when the sprite is drawn, and to 0 if that doesn't happen.
-[\]    EX9E     Skips the next instruction if the key stored in VX is pressed.
-[\]    EXA1     Skips the next instruction if the key stored in VX isn't pressed.
-[\]    FX07     Sets VX to the value of the delay timer.
-[\]    FX0A     A key press is awaited, and then stored in VX.
-[\]    FX15     Sets the delay timer to VX.
-[\]    FX18     Sets the sound timer to VX.
-[\]    FX1E     Adds VX to I.[3]
-[\]    FX29     Sets I to the location of the sprite for the character in VX. Characters 0-F (in hexadecimal) are represented by a 4x5 font.
-[\]    FX33     Stores the Binary-coded decimal representation of VX, with the most significant of three digits at the address in I, the middle digit at I plus 1, and the least significant digit at I plus 2. (In other words, take the decimal representation of VX, place the hundreds digit in memory at location in I, the tens digit at location I+1, and the ones digit at location I+2.)
-[\]    FX55     Stores V0 to VX in memory starting at address I.[4]
-[\]    FX65     Fills V0 to VX with values from memory starting at address I.[4]
+[V]    0NNN     Calls RCA 1802 program at address NNN. Only on hybrid VIP platform.
+[V]    0010     Turns colour mode off. Only on MegaChip.
+[V]    0011     Turns 256x192 colour mode on. Only on MegaChip.
+[V]    00BN     Scrolls display N lines up. Only on MegaChip.
+[V]    00CN     Scrolls display N lines down. Only on MegaChip.
+[V]    00FB     Scrolls display 4 pixels right. Only on MegaChip.
+[V]    00F8     Sets tone pitch to V0. Only on ETI-660.
+[V]    00FC     Scrolls display 4 pixels left on MegaChip, cycles background colour on ETI-660.
+[\]    00FD     Exits interpreter. Only on MegaChip.
+[V]    00FE     Low resolution, 64x32. Only on MegaChip.
+[V]    00FF     High resolution, 128x64. Only on MegaChip.
+[V]    01NN     Sets I to 24-bit address NN and the next word. Only on MegaChip.
+[V]    02NN     Loads NN ARGB colours from I into palette. Only on MegaChip.
+[V]    03NN     Sets sprite width to NN. Only on MegaChip.
+[V]    04NN     Sets sprite height to NN. Only on MegaChip.
+[\]    05NN     Sets screen alpha to NN. Only on MegaChip.
+[V]    060N     Plays digitised sound at I. Only on MegaChip.
+[V]    0700     Stops digitised sound. Only on MegaChip.
+[\]    080N     Sets sprite blending mode. Only on MegaChip.
+[V]    09NN     Sets collision colour. Only on MegaChip.
+[V]    00E0     Clears the screen.
+[V]    0230     Clears the screen. Only on hi-res platform.
+[V]    02A0     Cycles background colour. Only on CHIP-8X.
+[V]    00EE     Returns from a subroutine.
+[V]    1NNN     Jumps to address NNN.
+[V]    2NNN     Calls subroutine at NNN.
+[V]    3XNN     Skips the next instruction if VX equals NN.
+[V]    4XNN     Skips the next instruction if VX doesn't equal NN.
+[V]    5XY0     Skips the next instruction if VX equals VY.
+[V]    5XY1     Adds VY to VX, each nibble separately in 3 bits. Only on CHIP-8X.
+[V]    6XNN     Sets VX to NN.
+[V]    7XNN     Adds NN to VX.
+[V]    8XY0     Sets VX to the value of VY.
+[V]    8XY1     Sets VX to VX or VY.
+[V]    8XY2     Sets VX to VX and VY.
+[V]    8XY3     Sets VX to VX xor VY.
+[V]    8XY4     Adds VY to VX. VF is set to 1 when there's a carry, and to 0 when there isn't.
+[V]    8XY5     VY is subtracted from VX. VF is set to 0 when there's a borrow, and 1 when there isn't.
+[V]    8XY6     Shifts VX right by one. VF is set to the value of the least significant bit of VX before the shift.[2]
+[V]    8XY7     Sets VX to VY minus VX. VF is set to 0 when there's a borrow, and 1 when there isn't.
+[V]    8XYE     Shifts VX left by one. VF is set to the value of the most significant bit of VX before the shift.[2]
+[V]    9XY0     Skips the next instruction if VX doesn't equal VY.
+[V]    ANNN     Sets I to the address NNN.
+[V]    BNNN     Jumps to the address NNN plus V0. Not on CHIP-8X.
+[V]    BXY0     Colours zones given by VX and VX+1 with colour VY. Only on CHIP-8X.
+[V]    BXYN     Colours N rows at VX, VX+1 with colour VY. Only on CHIP-8X.
+[V]    CXNN     Sets VX to a random number and NN.
+[V]    DXYN     Draws a sprite at coordinate (VX, VY) that has a width of 8 pixels and a height of N pixels. Each row of 8 pixels is read as bit-coded (with the most significant bit of each byte displayed on the left) starting from memory location I; I value doesn't change after the execution of this instruction. As described above, VF is set to 1 if any screen pixels are flipped from set to unset when the sprite is drawn, and to 0 if that doesn't happen.
+[V]    EX9E     Skips the next instruction if the key stored in VX is pressed.
+[V]    EXA1     Skips the next instruction if the key stored in VX isn't pressed.
+[V]    EXF2     Skips the next instruction if the key stored in VX is pressed on second keypad. Only on CHIP-8X.
+[V]    EXF5     Skips the next instruction if the key stored in VX isn't pressed on second keypad. Only on CHIP-8X.
+[V]    FX07     Sets VX to the value of the delay timer.
+[V]    FX0A     A key press is awaited, and then stored in VX.
+[V]    FX15     Sets the delay timer to VX.
+[V]    FX18     Sets the sound timer to VX.
+[V]    FX1E     Adds VX to I.[3]
+[V]    FX29     Sets I to the location of the sprite for the character in VX. Characters 0-F (in hexadecimal) are represented by a 4x5 font.
+[V]    FX30     Sets I to the location of the 8x10 sprite for the character in VX. Only on MegaChip.
+[V]    FX33     Stores the Binary-coded decimal representation of VX, with the most significant of three digits at the address in I, the middle digit at I plus 1, and the least significant digit at I plus 2. (In other words, take the decimal representation of VX, place the hundreds digit in memory at location in I, the tens digit at location I+1, and the ones digit at location I+2.)
+[V]    FX55     Stores V0 to VX in memory starting at address I.[4]
+[V]    FX65     Fills V0 to VX with values from memory starting at address I.[4]
+[V]    FX75     Stores V0 to VX in RPL flags. Only on MegaChip.
+[V]    FX85     Fills V0 to VX from RPL flags. Only on MegaChip.
+[V]    FXF8     Outputs VX to I/O port. Only on CHIP-8X.
+[V]    FXFB     Waits for input from I/O port and stores it in VX. Only on CHIP-8X.
 */
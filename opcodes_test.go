@@ -0,0 +1,543 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-gl/glfw/v3.1/glfw"
+)
+
+// In-memory display: real CHIP8GPU drawing code without window
+type fake_gpu struct {
+	CHIP8GPU
+}
+
+func (gpu *fake_gpu) init() *glfw.Window {
+	gpu.w = 64
+	gpu.h = 32
+	gpu.pic = make([][]uint8, gpu.w)
+	for x := 0; x < gpu.w; x++ {
+		gpu.pic[x] = make([]uint8, gpu.h)
+	}
+	return nil
+}
+
+func (gpu *fake_gpu) render() {}
+
+// Keypad with keys pressed by test
+type fake_input struct {
+	keys  uint16 // Bit per key
+	keys2 uint16 // Bit per key of second keypad
+}
+
+func (input *fake_input) init(*glfw.Window) {}
+
+func (input *fake_input) is_pressed(key uint8) bool {
+	return key < 16 && input.keys&(1<<key) != 0
+}
+
+func (input *fake_input) is_pressed2(key uint8) bool {
+	return key < 16 && input.keys2&(1<<key) != 0
+}
+
+func (input *fake_input) set_keys(keys uint16) {
+	input.keys = keys
+}
+
+func (input *fake_input) set_keys2(keys uint16) {
+	input.keys2 = keys
+}
+
+func (input *fake_input) pressed() uint16 {
+	return input.keys
+}
+
+func (input *fake_input) hold(keys uint16) {
+	input.keys = keys
+}
+
+func (input *fake_input) bind(host glfw.Key, key uint8) {}
+
+func (input *fake_input) remap(key uint8, host glfw.Key) {}
+
+func (input *fake_input) tick() {}
+
+func new_fake_console() (*CHIP8Console, *CHIP8CPU, *fake_gpu, *fake_input) {
+	cpu := new(CHIP8CPU)
+	gpu := new(fake_gpu)
+	input := new(fake_input)
+	console := &CHIP8Console{cpu: cpu, mem: new(CHIP8Memory), gpu: gpu, input: input, sound: new(CHIP8Sound)}
+	console.cpu.init()
+	console.mem.init()
+	console.gpu.init()
+	console.input.init(nil)
+	console.sound.init()
+	return console, cpu, gpu, input
+}
+
+type op_case struct {
+	name    string
+	pattern string // Instruction expected to be decoded
+	op      OpCode
+	setup   func(cpu *CHIP8CPU, console *CHIP8Console)
+	check   func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console)
+}
+
+func expect_v(t *testing.T, cpu *CHIP8CPU, x int, val Registr) {
+	if cpu.v[x] != val {
+		t.Errorf("V%X = 0x%02X, want 0x%02X", x, cpu.v[x], val)
+	}
+}
+
+func expect_vf(t *testing.T, cpu *CHIP8CPU, x int, val, vf Registr) {
+	expect_v(t, cpu, x, val)
+	expect_v(t, cpu, 0xF, vf)
+}
+
+func expect_pc(t *testing.T, cpu *CHIP8CPU, pc uint16) {
+	if cpu.pc != pc {
+		t.Errorf("PC = 0x%03X, want 0x%03X", cpu.pc, pc)
+	}
+}
+
+func expect_i(t *testing.T, cpu *CHIP8CPU, i uint32) {
+	if cpu.i != i {
+		t.Errorf("I = 0x%03X, want 0x%03X", cpu.i, i)
+	}
+}
+
+func expect_mem(t *testing.T, console *CHIP8Console, addr uint32, vals ...uint8) {
+	for n, val := range vals {
+		if got := console.mem.read(addr + uint32(n)); got != val {
+			t.Errorf("mem[0x%03X] = 0x%02X, want 0x%02X", addr+uint32(n), got, val)
+		}
+	}
+}
+
+func set_v(vals ...Registr) func(cpu *CHIP8CPU, console *CHIP8Console) {
+	return func(cpu *CHIP8CPU, console *CHIP8Console) {
+		copy(cpu.v, vals)
+	}
+}
+
+var op_cases = []op_case{
+	{"clear screen", "00E0", 0x00E0,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			console.gpu.draw_line8(0, 0, 0xFF)
+			console.gpu.draw_line8(56, 31, 0xFF)
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			gpu := console.gpu.(*fake_gpu)
+			for x := 0; x < gpu.w; x++ {
+				for y := 0; y < gpu.h; y++ {
+					if gpu.pic[x][y] != 0 {
+						t.Fatalf("pixel %d,%d is not cleared", x, y)
+					}
+				}
+			}
+		}},
+	{"call", "2NNN", 0x2345, nil,
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			expect_pc(t, cpu, 0x345)
+			if cpu.sp != 0x6E {
+				t.Errorf("SP = 0x%X, want 0x6E", cpu.sp)
+			}
+			expect_mem(t, console, 0x70, 0x02, 0x02)
+		}},
+	{"return", "00EE", 0x00EE,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			cpu.op_2NNN(0x2456, console)
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			expect_pc(t, cpu, 0x200)
+			if cpu.sp != 0x70 {
+				t.Errorf("SP = 0x%X, want 0x70", cpu.sp)
+			}
+		}},
+	{"jump", "1NNN", 0x1ABC, nil,
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0xABC) }},
+	{"skip if equal", "3XNN", 0x3342, set_v(0, 0, 0, 0x42),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x204) }},
+	{"no skip if not equal", "3XNN", 0x3341, set_v(0, 0, 0, 0x42),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x202) }},
+	{"skip if not equal", "4XNN", 0x4341, set_v(0, 0, 0, 0x42),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x204) }},
+	{"no skip if equal", "4XNN", 0x4342, set_v(0, 0, 0, 0x42),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x202) }},
+	{"skip if registers equal", "5XY0", 0x5120, set_v(0, 7, 7),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x204) }},
+	{"no skip if registers differ", "5XY0", 0x5120, set_v(0, 7, 8),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x202) }},
+	{"load byte", "6XNN", 0x6A5C, nil,
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_v(t, cpu, 0xA, 0x5C) }},
+	{"add byte wraps without carry", "7XNN", 0x7102, set_v(0, 0xFF, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x33),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0x01, 0x33) }},
+	{"load register", "8XY0", 0x8120, set_v(0, 1, 2),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_v(t, cpu, 1, 2) }},
+	{"or", "8XY1", 0x8121, set_v(0, 0xF0, 0x0F),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_v(t, cpu, 1, 0xFF) }},
+	{"and", "8XY2", 0x8122, set_v(0, 0xFC, 0x3F),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_v(t, cpu, 1, 0x3C) }},
+	{"xor", "8XY3", 0x8123, set_v(0, 0xFC, 0x3F),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_v(t, cpu, 1, 0xC3) }},
+	{"add without carry", "8XY4", 0x8124, set_v(0, 0x7F, 0x80),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0xFF, 0) }},
+	{"add with carry", "8XY4", 0x8124, set_v(0, 0x80, 0x80),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0x00, 1) }},
+	{"add with carry and remainder", "8XY4", 0x8124, set_v(0, 0xFF, 0xFF),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0xFE, 1) }},
+	{"sub without borrow", "8XY5", 0x8125, set_v(0, 5, 3),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 2, 1) }},
+	{"sub of equal values has no borrow", "8XY5", 0x8125, set_v(0, 5, 5),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0, 1) }},
+	{"sub with borrow", "8XY5", 0x8125, set_v(0, 3, 5),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0xFE, 0) }},
+	{"sub into VF keeps flag", "8XY5", 0x8F15, set_v(0, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 5),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_v(t, cpu, 0xF, 1) }},
+	{"add into VF keeps carry", "8XY4", 0x8F14, set_v(0, 0x90, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x80),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_v(t, cpu, 0xF, 1) }},
+	{"shift right", "8XY6", 0x8106, set_v(0, 0x05),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0x02, 1) }},
+	{"shift right even", "8XY6", 0x8106, set_v(0, 0x80),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0x40, 0) }},
+	{"shift right into VF keeps flag", "8XY6", 0x8F16, set_v(0, 0x03, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x03),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_v(t, cpu, 0xF, 1) }},
+	{"subn without borrow", "8XY7", 0x8127, set_v(0, 3, 5),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 2, 1) }},
+	{"subn of equal values has no borrow", "8XY7", 0x8127, set_v(0, 5, 5),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0, 1) }},
+	{"subn with borrow", "8XY7", 0x8127, set_v(0, 5, 3),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0xFE, 0) }},
+	{"subn into VF keeps flag", "8XY7", 0x8F17, set_v(0, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_v(t, cpu, 0xF, 1) }},
+	{"shift left", "8XYE", 0x810E, set_v(0, 0x81),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0x02, 1) }},
+	{"shift left without overflow", "8XYE", 0x810E, set_v(0, 0x41),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0x82, 0) }},
+	{"shift left into VF keeps flag", "8XYE", 0x8F1E, set_v(0, 0x81, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x81),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_v(t, cpu, 0xF, 1) }},
+	{"skip if registers differ", "9XY0", 0x9120, set_v(0, 7, 8),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x204) }},
+	{"no skip if registers equal", "9XY0", 0x9120, set_v(0, 7, 7),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x202) }},
+	{"load I", "ANNN", 0xA123, nil,
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_i(t, cpu, 0x123) }},
+	{"jump with offset", "BNNN", 0xB300, set_v(0x10),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x310) }},
+	{"random with zero mask", "CXNN", 0xC100, set_v(0, 0xAA),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_v(t, cpu, 1, 0) }},
+	{"random is masked", "CXNN", 0xC10F, nil,
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			if cpu.v[1]&0xF0 != 0 {
+				t.Errorf("V1 = 0x%02X is not masked with 0x0F", cpu.v[1])
+			}
+		}},
+	{"draw sprite", "DXYN", 0xD012,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(2, 3)(cpu, console)
+			cpu.i = 0x300
+			console.mem.write(0x300, 0xC0)
+			console.mem.write(0x301, 0x81)
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			gpu := console.gpu.(*fake_gpu)
+			on := [][2]int{{2, 3}, {3, 3}, {2, 4}, {9, 4}}
+			count := 0
+			for x := 0; x < gpu.w; x++ {
+				for y := 0; y < gpu.h; y++ {
+					count += int(gpu.pic[x][y])
+				}
+			}
+			for _, p := range on {
+				if gpu.pic[p[0]][p[1]] != 1 {
+					t.Errorf("pixel %d,%d is not set", p[0], p[1])
+				}
+			}
+			if count != len(on) {
+				t.Errorf("%d pixels set, want %d", count, len(on))
+			}
+			expect_v(t, cpu, 0xF, 0)
+			expect_i(t, cpu, 0x300)
+		}},
+	{"draw sprite collision", "DXYN", 0xD011,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(2, 3)(cpu, console)
+			cpu.i = 0x300
+			console.mem.write(0x300, 0xC0)
+			console.gpu.draw_line8(2, 3, 0x80)
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			gpu := console.gpu.(*fake_gpu)
+			if gpu.pic[2][3] != 0 || gpu.pic[3][3] != 1 {
+				t.Errorf("sprite is not XORed: %d %d", gpu.pic[2][3], gpu.pic[3][3])
+			}
+			expect_v(t, cpu, 0xF, 1)
+		}},
+	{"draw sprite wraps", "DXYN", 0xD012,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(62, 31)(cpu, console)
+			cpu.i = 0x300
+			console.mem.write(0x300, 0xF0)
+			console.mem.write(0x301, 0xF0)
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			gpu := console.gpu.(*fake_gpu)
+			for _, p := range [][2]int{{62, 31}, {63, 31}, {0, 31}, {1, 31}, {62, 0}, {1, 0}} {
+				if gpu.pic[p[0]][p[1]] != 1 {
+					t.Errorf("pixel %d,%d is not set", p[0], p[1])
+				}
+			}
+			expect_v(t, cpu, 0xF, 0)
+		}},
+	{"skip if key pressed", "EX9E", 0xE19E,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(0, 0xA)(cpu, console)
+			console.input.(*fake_input).keys = 1 << 0xA
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x204) }},
+	{"no skip if key released", "EX9E", 0xE19E, set_v(0, 0xA),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x202) }},
+	{"skip if key released", "EXA1", 0xE1A1, set_v(0, 0xA),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x204) }},
+	{"no skip if key pressed", "EXA1", 0xE1A1,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(0, 0xA)(cpu, console)
+			console.input.(*fake_input).keys = 1 << 0xA
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x202) }},
+	{"load delay timer", "FX07", 0xF107,
+		func(cpu *CHIP8CPU, console *CHIP8Console) { cpu.dt = 0x42 },
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_v(t, cpu, 1, 0x42) }},
+	{"wait key", "FX0A", 0xF10A, nil,
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x200) }},
+	{"wait key pressed", "FX0A", 0xF10A,
+		func(cpu *CHIP8CPU, console *CHIP8Console) { console.input.(*fake_input).keys = 1 << 0xC },
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			expect_pc(t, cpu, 0x202)
+			expect_v(t, cpu, 1, 0xC)
+		}},
+	{"set delay timer", "FX15", 0xF115, set_v(0, 0x42),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			if cpu.dt != 0x42 {
+				t.Errorf("DT = 0x%02X, want 0x42", cpu.dt)
+			}
+		}},
+	{"set sound timer", "FX18", 0xF118, set_v(0, 0x42),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			if cpu.st != 0x42 {
+				t.Errorf("ST = 0x%02X, want 0x42", cpu.st)
+			}
+		}},
+	{"add to I", "FX1E", 0xF11E,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(0, 0x10)(cpu, console)
+			cpu.i = 0x100
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			expect_i(t, cpu, 0x110)
+			expect_v(t, cpu, 0xF, 0)
+		}},
+	{"add to I overflows", "FX1E", 0xF11E,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(0, 0x10)(cpu, console)
+			cpu.i = 0xFF8
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			expect_i(t, cpu, 0x1008)
+			expect_v(t, cpu, 0xF, 1)
+		}},
+	{"add to 24-bit I keeps VF on MegaChip", "FX1E", 0xF11E,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			cpu.set_platform(PlatformMegaChip)
+			set_v(0, 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x55)(cpu, console)
+			cpu.i = 0x12345
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			expect_i(t, cpu, 0x12355)
+			expect_v(t, cpu, 0xF, 0x55)
+		}},
+	{"font character", "FX29", 0xF129, set_v(0, 0xA),
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			expect_i(t, cpu, 0x32)
+			expect_mem(t, console, uint32(cpu.i), 0xF0, 0x90, 0xF0, 0x90, 0x90)
+		}},
+	{"bcd", "FX33", 0xF133,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(0, 254)(cpu, console)
+			cpu.i = 0x300
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_mem(t, console, 0x300, 2, 5, 4) }},
+	{"bcd of zero", "FX33", 0xF133,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			cpu.i = 0x300
+			console.mem.write(0x300, 9)
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_mem(t, console, 0x300, 0, 0, 0) }},
+	{"store registers", "FX55", 0xF255,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(1, 2, 3, 4)(cpu, console)
+			cpu.i = 0x300
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			expect_mem(t, console, 0x300, 1, 2, 3, 0)
+			expect_i(t, cpu, 0x300)
+		}},
+	{"load registers", "FX65", 0xF265,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(9, 9, 9, 9)(cpu, console)
+			cpu.i = 0x300
+			for n := uint32(0); n < 4; n++ {
+				console.mem.write(0x300+n, uint8(n+1))
+			}
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			for x, val := range []Registr{1, 2, 3, 9} {
+				expect_v(t, cpu, x, val)
+			}
+			expect_i(t, cpu, 0x300)
+		}},
+
+	// Quirks
+	{"shift quirk off shifts VY", "8XY6", 0x8126,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(0, 0xFF, 0x05)(cpu, console)
+			cpu.quirks.Shift = false
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0x02, 1) }},
+	{"shift quirk off shifts VY left", "8XYE", 0x812E,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(0, 0x01, 0x81)(cpu, console)
+			cpu.quirks.Shift = false
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0x02, 1) }},
+	{"logic quirk resets VF", "8XY1", 0x8121,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(0, 0xF0, 0x0F, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1)(cpu, console)
+			cpu.quirks.Logic = true
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_vf(t, cpu, 1, 0xFF, 0) }},
+	{"jump quirk adds VX", "BNNN", 0xB220,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(0x10, 0, 0x04)(cpu, console)
+			cpu.quirks.Jump = true
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_pc(t, cpu, 0x224) }},
+	{"store increments I", "FX55", 0xF255,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			cpu.i = 0x300
+			cpu.quirks.MemoryLeaveIUnchanged = false
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_i(t, cpu, 0x303) }},
+	{"load increments I by X", "FX65", 0xF265,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			cpu.i = 0x300
+			cpu.quirks.MemoryLeaveIUnchanged = false
+			cpu.quirks.MemoryIncrementByX = true
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) { expect_i(t, cpu, 0x302) }},
+	{"draw sprite clipped", "DXYN", 0xD012,
+		func(cpu *CHIP8CPU, console *CHIP8Console) {
+			set_v(62+64, 31)(cpu, console)
+			cpu.i = 0x300
+			console.mem.write(0x300, 0xF0)
+			console.mem.write(0x301, 0xF0)
+			cpu.quirks.Wrap = false
+		},
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			gpu := console.gpu.(*fake_gpu)
+			count := 0
+			for x := 0; x < gpu.w; x++ {
+				for y := 0; y < gpu.h; y++ {
+					count += int(gpu.pic[x][y])
+				}
+			}
+			if count != 2 || gpu.pic[62][31] != 1 || gpu.pic[63][31] != 1 {
+				t.Errorf("sprite is not clipped, %d pixels set", count)
+			}
+		}},
+	{"draw waits for display interrupt", "DXYN", 0xD011,
+		func(cpu *CHIP8CPU, console *CHIP8Console) { cpu.quirks.VBlank = true },
+		func(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console) {
+			expect_pc(t, cpu, 0x200)
+			cpu.vblank()
+			cpu.op_DXYN(0xD011, console)
+			expect_pc(t, cpu, 0x200)
+			if cpu.vblanked {
+				t.Errorf("sprite is not drawn after display interrupt")
+			}
+		}},
+}
+
+func TestOpcodes(t *testing.T) {
+	for _, c := range op_cases {
+		t.Run(c.pattern+" "+c.name, func(t *testing.T) {
+			console, cpu, _, _ := new_fake_console()
+			ins := decode(c.op, PlatformCHIP8)
+			if ins == nil || ins.pattern != c.pattern {
+				t.Fatalf("opcode %04X is not decoded as %s", c.op, c.pattern)
+			}
+			if c.setup != nil {
+				c.setup(cpu, console)
+			}
+			cpu.pc += 2 // As after fetch in tick
+			ins.handler(cpu, c.op, console)
+			c.check(t, cpu, console)
+		})
+	}
+}
+
+// Every instruction of the table must be covered by some case
+func TestOpcodesCoverage(t *testing.T) {
+	tested := make(map[string]bool)
+	for _, c := range op_cases {
+		tested[c.pattern] = true
+	}
+	for _, ins := range chip8_instructions {
+		if ins.platforms&PlatformCHIP8 != 0 && ins.pattern != "0NNN" && !tested[ins.pattern] {
+			t.Errorf("no test for %s", ins.pattern)
+		}
+	}
+}
+
+func TestTickDispatch(t *testing.T) {
+	console, cpu, _, _ := new_fake_console()
+	program := []uint8{0x61, 0x05, 0x71, 0x03, 0x12, 0x00}
+	for n, b := range program {
+		console.mem.write(uint32(0x200+n), b)
+	}
+	for n := 0; n < len(program)/2; n++ {
+		cpu.tick(console)
+	}
+	expect_v(t, cpu, 1, 8)
+	expect_pc(t, cpu, 0x200)
+}
+
+func TestVIPTiming(t *testing.T) {
+	if vip_cycles_per_frame != 3668 {
+		t.Errorf("VIP frame is %v machine cycles, 3668 expected", vip_cycles_per_frame)
+	}
+	console, cpu, _, _ := new_fake_console()
+	cpu.set_vip_timing(true)
+	cpu.quirks.VBlank = true
+	// Aligned sprite is faster than unaligned one
+	program := []uint8{0x60, 0x08, 0xD0, 0x05, 0x60, 0x09, 0xD0, 0x05}
+	for n, b := range program {
+		console.mem.write(uint32(0x200+n), b)
+	}
+	cpu.vblank()
+	cpu.tick(console)
+	cpu.tick(console)
+	aligned := cpu.cycles
+	if aligned != 2*vip_fetch_cycles+6+26+5*34 {
+		t.Errorf("aligned sprite takes %d cycles", aligned)
+	}
+	cpu.tick(console)
+	cpu.tick(console) // Waits for display interrupt
+	if !cpu.frame_done() {
+		t.Fatalf("frame is not finished by waiting sprite, %d cycles", cpu.cycles)
+	}
+	cpu.vblank()
+	cpu.tick(console)
+	if cpu.cycles != vip_fetch_cycles+26+5*(46+8) {
+		t.Errorf("unaligned sprite takes %d cycles", cpu.cycles)
+	}
+}
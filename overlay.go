@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/go-gl/gl/v2.1/gl"
+)
+
+// Overlay text drawn over display by scripts. Glyphs are 3x5 pixels, top row
+// in bits 14-12. Lower case letters are drawn as capitals, unknown characters
+// as spaces
+var overlay_font = map[rune]uint16{
+	'0': 0x7B6F,
+	'1': 0x2C97,
+	'2': 0x73E7,
+	'3': 0x73CF,
+	'4': 0x5BC9,
+	'5': 0x79CF,
+	'6': 0x79EF,
+	'7': 0x7292,
+	'8': 0x7BEF,
+	'9': 0x7BCF,
+	'A': 0x2BED,
+	'B': 0x6BAE,
+	'C': 0x3923,
+	'D': 0x6B6E,
+	'E': 0x79A7,
+	'F': 0x79A4,
+	'G': 0x396B,
+	'H': 0x5BED,
+	'I': 0x7497,
+	'J': 0x126A,
+	'K': 0x5BAD,
+	'L': 0x4927,
+	'M': 0x5FED,
+	'N': 0x6B6D,
+	'O': 0x2B6A,
+	'P': 0x6BA4,
+	'Q': 0x2B73,
+	'R': 0x6BAD,
+	'S': 0x388E,
+	'T': 0x7492,
+	'U': 0x5B6F,
+	'V': 0x5B6A,
+	'W': 0x5BFD,
+	'X': 0x5AAD,
+	'Y': 0x5A92,
+	'Z': 0x72A7,
+	'.': 0x0002,
+	',': 0x0014,
+	':': 0x0410,
+	'-': 0x01C0,
+	'+': 0x05D0,
+	'=': 0x0E38,
+	'/': 0x12A4,
+	'!': 0x2482,
+	'?': 0x6282,
+	'%': 0x52A5,
+	'(': 0x1491,
+	')': 0x4494,
+}
+
+// Overlay pixels across display, whatever its resolution is
+const overlay_columns = 160
+
+type overlay_text struct {
+	x, y float64 // Display pixels
+	text string
+}
+
+func (gpu *CHIP8GPU) draw_text(x, y float64, text string) {
+	gpu.overlay = append(gpu.overlay, overlay_text{x: x, y: y, text: text})
+}
+
+func (gpu *CHIP8GPU) clear_text() {
+	gpu.overlay = nil
+}
+
+// Calls fill for every lit pixel of overlay with its corner and size in display pixels
+func (gpu *CHIP8GPU) overlay_pixels(fill func(x, y, size float64)) {
+	size := float64(gpu.w) / overlay_columns
+	for _, text := range gpu.overlay {
+		x := text.x
+		for _, ch := range strings.ToUpper(text.text) {
+			glyph := overlay_font[ch]
+			for bit := 0; bit < 15; bit++ {
+				if glyph&(0x4000>>uint(bit)) != 0 {
+					fill(x+float64(bit%3)*size, text.y+float64(bit/3)*size, size)
+				}
+			}
+			x += 4 * size
+		}
+	}
+}
+
+func (gpu *CHIP8GPU) render_overlay() {
+	gl.Color3ub(0xFF, 0xFF, 0x00)
+	gl.Begin(gl.QUADS)
+	gpu.overlay_pixels(func(x, y, size float64) {
+		gl.Vertex2f(float32(x), float32(y))
+		gl.Vertex2f(float32(x+size), float32(y))
+		gl.Vertex2f(float32(x+size), float32(y+size))
+		gl.Vertex2f(float32(x), float32(y+size))
+	})
+	gl.End()
+}
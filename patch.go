@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// ROM patches of fan fixes and hacks: IPS records of bytes at offsets, or
+// BPS copy actions with CRC32 of source, target and patch. Patches are
+// applied to ROM in memory, files are never changed
+
+var (
+	ips_magic = []uint8("PATCH")
+	ips_eof   = []uint8("EOF")
+	bps_magic = []uint8("BPS1")
+)
+
+const (
+	ips_max_offset = 1<<24 - 1
+	ips_max_size   = 0xFFFF
+)
+
+// Applies IPS or BPS patch, found by header
+func apply_patch(rom, patch []uint8) ([]uint8, error) {
+	switch {
+	case bytes.HasPrefix(patch, ips_magic):
+		return apply_ips(rom, patch)
+	case bytes.HasPrefix(patch, bps_magic):
+		return apply_bps(rom, patch)
+	}
+	return nil, fmt.Errorf("patch is not IPS or BPS")
+}
+
+// Reads patch files and applies them in order
+func apply_patch_files(rom []uint8, paths []string) ([]uint8, error) {
+	for _, path := range paths {
+		patch, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if rom, err = apply_patch(rom, patch); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+	}
+	return rom, nil
+}
+
+// IPS: records of 3 byte offset, 2 byte size and data, size 0 is run of
+// 2 byte count and byte. 3 bytes after EOF truncate ROM to their size
+func apply_ips(rom, patch []uint8) ([]uint8, error) {
+	out := append([]uint8(nil), rom...)
+	p := patch[len(ips_magic):]
+	for {
+		if len(p) < 3 {
+			return nil, fmt.Errorf("IPS patch is cut")
+		}
+		if bytes.Equal(p[:3], ips_eof) {
+			p = p[3:]
+			break
+		}
+		if len(p) < 5 {
+			return nil, fmt.Errorf("IPS patch is cut")
+		}
+		offset := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		size := int(p[3])<<8 | int(p[4])
+		p = p[5:]
+		var data []uint8
+		if size == 0 {
+			if len(p) < 3 {
+				return nil, fmt.Errorf("IPS patch is cut")
+			}
+			data = bytes.Repeat(p[2:3], int(p[0])<<8|int(p[1]))
+			p = p[3:]
+		} else {
+			if len(p) < size {
+				return nil, fmt.Errorf("IPS patch is cut")
+			}
+			data, p = p[:size], p[size:]
+		}
+		if end := offset + len(data); end > len(out) {
+			out = append(out, make([]uint8, end-len(out))...)
+		}
+		copy(out[offset:], data)
+	}
+	if len(p) >= 3 {
+		if size := int(p[0])<<16 | int(p[1])<<8 | int(p[2]); size < len(out) {
+			out = out[:size]
+		}
+	}
+	return out, nil
+}
+
+// BPS: sizes and metadata, then actions that build target from source, patch
+// data and target itself, then CRC32 of source, target and patch
+func apply_bps(rom, patch []uint8) ([]uint8, error) {
+	if len(patch) < len(bps_magic)+12 {
+		return nil, fmt.Errorf("BPS patch is cut")
+	}
+	footer := patch[len(patch)-12:]
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != binary.LittleEndian.Uint32(footer[8:]) {
+		return nil, fmt.Errorf("BPS patch is damaged, its checksum differs")
+	}
+	if crc32.ChecksumIEEE(rom) != binary.LittleEndian.Uint32(footer) {
+		return nil, fmt.Errorf("BPS patch is for other ROM, checksum of ROM differs")
+	}
+	r := &bps_reader{data: patch[:len(patch)-12], pos: len(bps_magic)}
+	source_size, target_size, meta_size := r.number(), r.number(), r.number()
+	r.pos += meta_size
+	if source_size != len(rom) || target_size > 1<<24 || r.err != nil || r.pos > len(r.data) {
+		return nil, fmt.Errorf("BPS patch is for other ROM or damaged")
+	}
+	out := make([]uint8, 0, target_size)
+	source_pos, target_pos := 0, 0 // Relative offsets of copies
+	for r.pos < len(r.data) && r.err == nil {
+		action := r.number()
+		length := action>>2 + 1
+		if len(out)+length > target_size {
+			return nil, fmt.Errorf("BPS patch writes past target")
+		}
+		switch action & 3 {
+		case 0: // Source read: bytes of source at the same offset
+			if len(out)+length > len(rom) {
+				return nil, fmt.Errorf("BPS patch reads past ROM")
+			}
+			out = append(out, rom[len(out):len(out)+length]...)
+		case 1: // Target read: bytes of patch
+			if r.pos+length > len(r.data) {
+				return nil, fmt.Errorf("BPS patch is cut")
+			}
+			out = append(out, r.data[r.pos:r.pos+length]...)
+			r.pos += length
+		case 2: // Source copy
+			source_pos += r.offset()
+			if source_pos < 0 || source_pos+length > len(rom) {
+				return nil, fmt.Errorf("BPS patch reads past ROM")
+			}
+			out = append(out, rom[source_pos:source_pos+length]...)
+			source_pos += length
+		case 3: // Target copy, byte by byte as it may overlap output
+			target_pos += r.offset()
+			if target_pos < 0 || target_pos >= len(out) {
+				return nil, fmt.Errorf("BPS patch reads past target")
+			}
+			for i := 0; i < length; i++ {
+				out = append(out, out[target_pos])
+				target_pos++
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(out) != target_size || crc32.ChecksumIEEE(out) != binary.LittleEndian.Uint32(footer[4:]) {
+		return nil, fmt.Errorf("BPS patch result has wrong checksum")
+	}
+	return out, nil
+}
+
+type bps_reader struct {
+	data []uint8
+	pos  int
+	err  error
+}
+
+// Variable length number, 7 bits per byte, high bit ends it
+func (r *bps_reader) number() int {
+	value, shift := 0, 1
+	for {
+		if r.pos >= len(r.data) || shift > 1<<42 {
+			r.err = fmt.Errorf("BPS patch is cut")
+			return 0
+		}
+		b := int(r.data[r.pos])
+		r.pos++
+		value += (b & 0x7F) * shift
+		if b&0x80 != 0 {
+			return value
+		}
+		shift <<= 7
+		value += shift
+	}
+}
+
+// Relative offset of copy: low bit is sign
+func (r *bps_reader) offset() int {
+	n := r.number()
+	if n&1 != 0 {
+		return -(n >> 1)
+	}
+	return n >> 1
+}
+
+func bps_number(buf *bytes.Buffer, value int) {
+	for {
+		b := uint8(value & 0x7F)
+		value >>= 7
+		if value == 0 {
+			buf.WriteByte(0x80 | b)
+			return
+		}
+		buf.WriteByte(b)
+		value--
+	}
+}
+
+// IPS patch with record per run of changed bytes
+func make_ips(source, target []uint8) ([]uint8, error) {
+	if len(target) > ips_max_offset {
+		return nil, fmt.Errorf("IPS can't patch ROM over 16 MB")
+	}
+	var buf bytes.Buffer
+	buf.Write(ips_magic)
+	for i := 0; i < len(target); {
+		if i < len(source) && source[i] == target[i] {
+			i++
+			continue
+		}
+		start := i
+		if start == 0x454F46 { // Offset would read as EOF, record starts a byte earlier
+			start--
+		}
+		for i < len(target) && i-start < ips_max_size && (i >= len(source) || source[i] != target[i]) {
+			i++
+		}
+		buf.Write([]uint8{uint8(start >> 16), uint8(start >> 8), uint8(start), uint8((i - start) >> 8), uint8(i - start)})
+		buf.Write(target[start:i])
+	}
+	buf.Write(ips_eof)
+	if len(target) < len(source) {
+		size := len(target)
+		buf.Write([]uint8{uint8(size >> 16), uint8(size >> 8), uint8(size)})
+	}
+	return buf.Bytes(), nil
+}
+
+// BPS patch of source reads where bytes are the same and target reads elsewhere
+func make_bps(source, target []uint8) []uint8 {
+	var buf bytes.Buffer
+	buf.Write(bps_magic)
+	bps_number(&buf, len(source))
+	bps_number(&buf, len(target))
+	bps_number(&buf, 0) // No metadata
+	for i := 0; i < len(target); {
+		same := i < len(source) && source[i] == target[i]
+		start := i
+		for i < len(target) && (i < len(source) && source[i] == target[i]) == same {
+			i++
+		}
+		if same {
+			bps_number(&buf, (i-start-1)<<2)
+		} else {
+			bps_number(&buf, (i-start-1)<<2|1)
+			buf.Write(target[start:i])
+		}
+	}
+	var footer [12]uint8
+	binary.LittleEndian.PutUint32(footer[0:], crc32.ChecksumIEEE(source))
+	binary.LittleEndian.PutUint32(footer[4:], crc32.ChecksumIEEE(target))
+	buf.Write(footer[:8])
+	binary.LittleEndian.PutUint32(footer[8:], crc32.ChecksumIEEE(buf.Bytes()))
+	buf.Write(footer[8:])
+	return buf.Bytes()
+}
+
+func cmd_mkpatch(cmd *cli_command, args []string) int {
+	flags := cmd.flag_set()
+	out := flags.String("o", "", "Patch file, .ips or .bps. Default is new ROM with .bps")
+	args, ok := parse_args(flags, args, 2)
+	if !ok {
+		return exit_usage
+	}
+	path := *out
+	if path == "" {
+		path = strings.TrimSuffix(args[1], filepath.Ext(args[1])) + ".bps"
+	}
+	source, err := ioutil.ReadFile(args[0])
+	var target, patch []uint8
+	if err == nil {
+		target, err = ioutil.ReadFile(args[1])
+	}
+	if err == nil {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ips":
+			patch, err = make_ips(source, target)
+		case ".bps":
+			patch = make_bps(source, target)
+		default:
+			err = fmt.Errorf("patch %s is not .ips or .bps", path)
+		}
+	}
+	if err == nil {
+		err = ioutil.WriteFile(path, patch, 0644)
+	}
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return exit_error
+	}
+	fmt.Printf("%s: %d bytes\n", path, len(patch))
+	return exit_ok
+}
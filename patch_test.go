@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var patch_cases = []struct {
+	name           string
+	source, target []uint8
+}{
+	{"change", []uint8{1, 2, 3, 4, 5, 6}, []uint8{1, 9, 9, 4, 5, 7}},
+	{"grow", []uint8{1, 2, 3}, []uint8{1, 2, 3, 4, 5}},
+	{"shrink", []uint8{1, 2, 3, 4, 5}, []uint8{1, 8}},
+	{"same", []uint8{1, 2, 3}, []uint8{1, 2, 3}},
+	{"empty source", nil, []uint8{7, 7, 7}},
+}
+
+func TestPatchRoundTrip(t *testing.T) {
+	for _, c := range patch_cases {
+		ips, err := make_ips(c.source, c.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for format, patch := range map[string][]uint8{"IPS": ips, "BPS": make_bps(c.source, c.target)} {
+			out, err := apply_patch(c.source, patch)
+			if err != nil || !bytes.Equal(out, c.target) {
+				t.Errorf("%s %s: %v, %v", format, c.name, out, err)
+			}
+		}
+	}
+}
+
+func TestIPSRun(t *testing.T) {
+	patch := []uint8("PATCH")
+	patch = append(patch, 0, 0, 2, 0, 0, 0, 4, 0xAA) // Run of 4 AA at 2
+	patch = append(patch, []uint8("EOF")...)
+	out, err := apply_patch([]uint8{1, 2, 3}, patch)
+	if err != nil || !bytes.Equal(out, []uint8{1, 2, 0xAA, 0xAA, 0xAA, 0xAA}) {
+		t.Errorf("run gives %v, %v", out, err)
+	}
+	if _, err = apply_patch([]uint8{1}, patch[:10]); err == nil {
+		t.Errorf("cut patch is applied")
+	}
+}
+
+// Offset 454F46 reads as EOF, so record can't start there
+func TestIPSOffsetEOF(t *testing.T) {
+	source := make([]uint8, 0x454F50)
+	target := append([]uint8(nil), source...)
+	target[0x454F46] = 1
+	patch, _ := make_ips(source, target)
+	out, err := apply_ips(source, patch)
+	if err != nil || !bytes.Equal(out, target) {
+		t.Errorf("patch at EOF offset is applied wrong: %v", err)
+	}
+}
+
+func TestBPSChecksums(t *testing.T) {
+	source, target := []uint8{1, 2, 3}, []uint8{1, 5, 3}
+	patch := make_bps(source, target)
+	if _, err := apply_patch([]uint8{1, 2, 4}, patch); err == nil {
+		t.Errorf("patch is applied to other ROM")
+	}
+	damaged := append([]uint8(nil), patch...)
+	damaged[len(bps_magic)+4] ^= 0xFF
+	if _, err := apply_patch(source, damaged); err == nil {
+		t.Errorf("damaged patch is applied")
+	}
+	if _, err := apply_patch(source, []uint8("NOPE")); err == nil {
+		t.Errorf("unknown patch is applied")
+	}
+}
+
+// Patches of -patch go in order before ROM is loaded
+func TestConsolePatches(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	rom := append([]uint8(nil), score_rom...)
+	fixed := append([]uint8(nil), rom...)
+	fixed[1] = 2 // ADD V1, 2
+	twice := append([]uint8(nil), fixed...)
+	twice[7] = 6 // SE V1, 6
+	paths := []string{filepath.Join(dir, "a.ips"), filepath.Join(dir, "b.bps")}
+	ips, _ := make_ips(rom, fixed)
+	ioutil.WriteFile(paths[0], ips, 0644)
+	ioutil.WriteFile(paths[1], make_bps(fixed, twice), 0644)
+
+	path := filepath.Join(dir, "score.ch8")
+	ioutil.WriteFile(path, rom, 0644)
+	console := &CHIP8Console{headless: true, patches: paths}
+	if err := console.init(path); err != nil {
+		t.Fatal(err)
+	}
+	if op := console.mem.read2(0x200); op != 0x7102 {
+		t.Errorf("patched instruction is %04X", op)
+	}
+	if op := console.mem.read2(0x206); op != 0x3106 {
+		t.Errorf("second patch gives %04X", op)
+	}
+	if console.rom_hash != rom_hash(twice) {
+		t.Errorf("hash isn't of patched ROM")
+	}
+	console.mem.write(0x200, 0)
+	console.hard_reset()
+	if op := console.mem.read2(0x200); op != 0x7102 {
+		t.Errorf("patch isn't applied on reload: %04X", op)
+	}
+
+	console = &CHIP8Console{headless: true, patches: paths[1:]}
+	if err := console.init(path); err == nil {
+		t.Errorf("BPS patch of other ROM is applied")
+	}
+}
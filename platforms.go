@@ -0,0 +1,52 @@
+package main
+
+// Machine properties of platform besides instruction set
+type PlatformSpec struct {
+	width, height int    // Display size in pixels
+	load          uint16 // Address ROM is loaded at
+	entry         uint16 // Address of first instruction
+	color_zones   bool   // CHIP-8X colour board
+	memory        uint32 // Memory size, 4K if zero
+}
+
+var platform_specs = map[Platform]PlatformSpec{
+	PlatformCHIP8:     {width: 64, height: 32, load: 0x200, entry: 0x200},
+	PlatformHybridVIP: {width: 64, height: 32, load: 0x200, entry: 0x200},
+	// Program starts with 1260 jump into the patched interpreter, which
+	// sets up two display pages and runs the program from 0x2C0
+	PlatformHiresVIP: {width: 64, height: 64, load: 0x200, entry: 0x2C0},
+	// CHIP-8X interpreter takes 0x000-0x2FF
+	PlatformCHIP8X: {width: 64, height: 32, load: 0x300, entry: 0x300, color_zones: true},
+	// MegaChip addresses 16M with 24-bit I. It starts in SCHIP low resolution
+	PlatformMegaChip: {width: 64, height: 32, load: 0x200, entry: 0x200, memory: 0x1000000},
+	// ETI-660 monitor and interpreter take memory below 0x600
+	PlatformETI660: {width: 64, height: 48, load: 0x600, entry: 0x600},
+}
+
+// Where ROM is loaded and CPU starts, over addresses of platform
+type CHIP8Boot struct {
+	load      uint32 // Address ROM is loaded at
+	entry     uint32 // Address of first instruction
+	has_load  bool   // Load is given, 0 included. Platform's is used otherwise
+	has_entry bool   // Entry is given, 0 included
+	image     bool   // ROM is image of whole 4K or 64K memory with interpreter area, loaded at 0
+}
+
+// Sizes of memory images
+var boot_image_sizes = []int{0x1000, 0x10000}
+
+func platform_spec(platform Platform) PlatformSpec {
+	if spec, ok := platform_specs[platform]; ok {
+		return spec
+	}
+	return platform_specs[PlatformCHIP8]
+}
+
+// Guesses platform from ROM when database doesn't know it.
+// Hi-res programs start with 1260 jump
+func detect_platform(rom []uint8) (Platform, string, bool) {
+	if len(rom) >= 2 && rom[0] == 0x12 && rom[1] == 0x60 {
+		return PlatformHiresVIP, "chip8HiRes", true
+	}
+	return 0, "", false
+}
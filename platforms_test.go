@@ -0,0 +1,502 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Writes ROM to temporary file; user config and database are isolated
+func write_test_rom(t *testing.T, name string, rom []uint8) string {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	return write_test_file(t, name, rom)
+}
+
+// Starts console headless with ROM, keeping its other fields
+func start_test_console(t *testing.T, rom []uint8, console *CHIP8Console) (*CHIP8Console, error) {
+	console.headless = true
+	return console, console.init(write_test_rom(t, "test.ch8", rom))
+}
+
+// Starts headless console with ROM on platform
+func new_rom_console(t *testing.T, rom []uint8, platform_id string) *CHIP8Console {
+	console, err := start_test_console(t, rom, &CHIP8Console{platform_id: platform_id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return console
+}
+
+// Hi-res ROM starts with 1260; program itself is at 0x2C0
+func TestHiresDetection(t *testing.T) {
+	rom := make([]uint8, 0xD0)
+	rom[0], rom[1] = 0x12, 0x60
+	copy(rom[0xC0:], []uint8{
+		0x60, 0x38, // LD V0, 38
+		0x61, 0x08, // LD V1, 8
+		0xF1, 0x29, // LD F, V1
+		0xD0, 0x05, // DRW V0, V0, 5: at 38,38
+	})
+	console := new_rom_console(t, rom, "")
+	if console.settings.platform != PlatformHiresVIP {
+		t.Fatalf("hi-res ROM is detected as %s", console.settings.platform_id)
+	}
+	if w, h := console.gpu.size(); w != 64 || h != 64 {
+		t.Errorf("display is %dx%d, want 64x64", w, h)
+	}
+	cpu := console.cpu.(*CHIP8CPU)
+	expect_pc(t, cpu, 0x2C0)
+	for i := 0; i < 4; i++ {
+		console.tick()
+	}
+	if console.gpu.pixel(0x38, 0x38) != 1 || console.gpu.pixel(0x38, 0x3C) != 1 {
+		t.Errorf("sprite is not drawn on lower half of display")
+	}
+}
+
+func TestHiresClear(t *testing.T) {
+	console := new_rom_console(t, []uint8{0x02, 0x30}, "chip8HiRes")
+	console.gpu.draw_line8(0, 63, 0xFF)
+	ins := decode(0x0230, PlatformHiresVIP)
+	if ins == nil || ins.pattern != "0230" {
+		t.Fatalf("0230 is not decoded on hi-res platform")
+	}
+	console.cpu.set_entry(0x200)
+	console.tick()
+	if console.gpu.pixel(0, 63) != 0 {
+		t.Errorf("0230 doesn't clear lower half of display")
+	}
+	if ins := decode(0x0230, PlatformCHIP8); ins == nil || ins.pattern != "0NNN" {
+		t.Errorf("0230 is not SYS on CHIP-8")
+	}
+}
+
+func TestPlatformDetectionNeedsJump(t *testing.T) {
+	console := new_rom_console(t, []uint8{0x12, 0x00}, "")
+	if console.settings.platform != PlatformCHIP8 {
+		t.Errorf("plain ROM is detected as %s", console.settings.platform_id)
+	}
+	if w, h := console.gpu.size(); w != 64 || h != 32 {
+		t.Errorf("display is %dx%d, want 64x32", w, h)
+	}
+}
+
+// Port device remembering output and giving input when it has some
+type fake_port struct {
+	output []uint8
+	input  []uint8
+}
+
+func (port *fake_port) out(val uint8) {
+	port.output = append(port.output, val)
+}
+
+func (port *fake_port) in() (uint8, bool) {
+	if len(port.input) == 0 {
+		return 0, false
+	}
+	val := port.input[0]
+	port.input = port.input[1:]
+	return val, true
+}
+
+func new_chip8x_console() (*CHIP8Console, *CHIP8CPU, *fake_gpu, *fake_input) {
+	console, cpu, gpu, input := new_fake_console()
+	cpu.set_platform(PlatformCHIP8X)
+	gpu.set_color_zones(true)
+	return console, cpu, gpu, input
+}
+
+// Runs one instruction of platform as tick does after fetch
+func run_op(t *testing.T, cpu *CHIP8CPU, console *CHIP8Console, op OpCode, pattern string) {
+	ins := decode(op, cpu.platform)
+	if ins == nil || ins.pattern != pattern {
+		t.Fatalf("opcode %04X is not decoded as %s", op, pattern)
+	}
+	cpu.pc += 2
+	ins.handler(cpu, op, console)
+}
+
+func expect_zone(t *testing.T, gpu *fake_gpu, x, y int, color uint8) {
+	if gpu.zones[x][y] != color {
+		t.Errorf("zone %d,%d has colour %d, want %d", x, y, gpu.zones[x][y], color)
+	}
+}
+
+func TestCHIP8XNibbleAdd(t *testing.T) {
+	console, cpu, _, _ := new_chip8x_console()
+	cpu.v[1], cpu.v[2] = 0x37, 0x15
+	run_op(t, cpu, console, 0x5121, "5XY1")
+	expect_v(t, cpu, 1, 0x44)
+}
+
+func TestCHIP8XColorZones(t *testing.T) {
+	console, cpu, gpu, _ := new_chip8x_console()
+	cpu.v[1], cpu.v[2], cpu.v[3] = 0x12, 0x01, 4 // Zones 2-3, rows 4-7, green
+	run_op(t, cpu, console, 0xB130, "BXY0")
+	expect_zone(t, gpu, 2, 4, 4)
+	expect_zone(t, gpu, 3, 7, 4)
+	expect_zone(t, gpu, 4, 4, chip8x_default_color)
+	expect_zone(t, gpu, 2, 8, chip8x_default_color)
+	expect_zone(t, gpu, 2, 3, chip8x_default_color)
+
+	cpu.v[1], cpu.v[2], cpu.v[3] = 20, 10, 6 // Pixel 20,10: zone 2, aqua
+	run_op(t, cpu, console, 0xB133, "BXYN")
+	expect_zone(t, gpu, 2, 10, 6)
+	expect_zone(t, gpu, 2, 12, 6)
+	expect_zone(t, gpu, 2, 13, chip8x_default_color)
+	expect_zone(t, gpu, 3, 10, chip8x_default_color)
+}
+
+func TestCHIP8XBackground(t *testing.T) {
+	console, cpu, gpu, _ := new_chip8x_console()
+	if gpu.background != chip8x_background[0] {
+		t.Errorf("background is %06X, want blue", gpu.background)
+	}
+	for i := 1; i <= len(chip8x_background); i++ {
+		run_op(t, cpu, console, 0x02A0, "02A0")
+		if want := chip8x_background[i%len(chip8x_background)]; gpu.background != want {
+			t.Errorf("background is %06X after %d cycles, want %06X", gpu.background, i, want)
+		}
+	}
+}
+
+func TestCHIP8XSecondKeypad(t *testing.T) {
+	console, cpu, _, input := new_chip8x_console()
+	cpu.v[1] = 0xA
+	input.set_keys(1 << 0xA) // First keypad doesn't count
+	run_op(t, cpu, console, 0xE1F2, "EXF2")
+	expect_pc(t, cpu, 0x202)
+	run_op(t, cpu, console, 0xE1F5, "EXF5")
+	expect_pc(t, cpu, 0x206)
+	input.set_keys2(1 << 0xA)
+	run_op(t, cpu, console, 0xE1F2, "EXF2")
+	expect_pc(t, cpu, 0x20A)
+}
+
+func TestCHIP8XPort(t *testing.T) {
+	console, cpu, _, _ := new_chip8x_console()
+	cpu.v[2] = 0x5A
+	run_op(t, cpu, console, 0xF2F8, "FXF8") // Nothing connected
+	run_op(t, cpu, console, 0xF3FB, "FXFB")
+	expect_v(t, cpu, 3, 0)
+
+	port := &fake_port{}
+	console.port = port
+	run_op(t, cpu, console, 0xF2F8, "FXF8")
+	if len(port.output) != 1 || port.output[0] != 0x5A {
+		t.Errorf("port output is %v, want [5A]", port.output)
+	}
+	cpu.pc = 0x300
+	run_op(t, cpu, console, 0xF3FB, "FXFB")
+	expect_pc(t, cpu, 0x300) // Waits for input
+	port.input = []uint8{0x21}
+	run_op(t, cpu, console, 0xF3FB, "FXFB")
+	expect_v(t, cpu, 3, 0x21)
+	expect_pc(t, cpu, 0x302)
+}
+
+func TestCHIP8XLoad(t *testing.T) {
+	console := new_rom_console(t, []uint8{0x61, 0x07}, "chip8x")
+	cpu := console.cpu.(*CHIP8CPU)
+	expect_pc(t, cpu, 0x300)
+	if console.mem.read2(0x300) != 0x6107 {
+		t.Fatalf("ROM is not loaded at 0x300")
+	}
+	if ins := decode(0xB123, PlatformCHIP8X); ins == nil || ins.pattern != "BXYN" {
+		t.Errorf("B123 is not BXYN on CHIP-8X")
+	}
+}
+
+func new_megachip_console() (*CHIP8Console, *CHIP8CPU, *fake_gpu) {
+	console, cpu, gpu, _ := new_fake_console()
+	cpu.set_platform(PlatformMegaChip)
+	console.mem.resize(platform_spec(PlatformMegaChip).memory)
+	return console, cpu, gpu
+}
+
+func TestMegaChipLoad(t *testing.T) {
+	console := new_rom_console(t, []uint8{0x01, 0x12, 0x34, 0x56}, "megachip8")
+	if size := console.mem.size(); size != 0x1000000 {
+		t.Fatalf("memory is %X bytes, want 16M", size)
+	}
+	cpu := console.cpu.(*CHIP8CPU)
+	console.mem.write(0x123456, 0xAB)
+	console.tick()
+	expect_i(t, cpu, 0x123456)
+	expect_pc(t, cpu, 0x204)
+	if console.mem.read(cpu.i) != 0xAB {
+		t.Errorf("24-bit address doesn't reach memory")
+	}
+}
+
+// Sprite indexes are drawn with palette colours, index 0 leaves screen as it is
+func TestMegaChipColorSprite(t *testing.T) {
+	console, cpu, gpu := new_megachip_console()
+	run_op(t, cpu, console, 0x0011, "0011")
+	if w, h := gpu.size(); w != 256 || h != 192 {
+		t.Fatalf("display is %dx%d, want 256x192", w, h)
+	}
+	load_1802(console, 0x1000,
+		0xFF, 0xFF, 0x00, 0x00, // Red
+		0xFF, 0x00, 0x00, 0xFF) // Blue
+	cpu.i = 0x1000
+	run_op(t, cpu, console, 0x0202, "02NN")
+	load_1802(console, 0x1100, 1, 0, 2, 1) // 2x2 sprite
+	cpu.i = 0x1100
+	run_op(t, cpu, console, 0x0302, "03NN")
+	run_op(t, cpu, console, 0x0402, "04NN")
+	cpu.v[1], cpu.v[2] = 200, 150
+	run_op(t, cpu, console, 0xD120, "DXYN")
+	expect_v(t, cpu, 0xF, 0)
+	if gpu.pixel(200, 150) != 1 || gpu.rgb[200][150] != 0xFF0000 {
+		t.Errorf("pixel 200,150 is %d %06X, want red", gpu.pixel(200, 150), gpu.rgb[200][150])
+	}
+	if gpu.pixel(201, 150) != 0 {
+		t.Errorf("transparent pixel is drawn")
+	}
+	if gpu.pixel(200, 151) != 2 || gpu.rgb[200][151] != 0x0000FF {
+		t.Errorf("pixel 200,151 is %d %06X, want blue", gpu.pixel(200, 151), gpu.rgb[200][151])
+	}
+
+	run_op(t, cpu, console, 0x0902, "09NN") // Collision with blue only
+	cpu.v[1] = 201
+	run_op(t, cpu, console, 0xD120, "DXYN")
+	expect_v(t, cpu, 0xF, 0)
+	cpu.v[1] = 200
+	run_op(t, cpu, console, 0xD120, "DXYN")
+	expect_v(t, cpu, 0xF, 1)
+}
+
+func TestMegaChipBlend(t *testing.T) {
+	if c := mega_blend(0x204080, 0x406080, mega_blend_50); c != 0x305080 {
+		t.Errorf("50%% blend is %06X, want 305080", c)
+	}
+	if c := mega_blend(0xF01000, 0x202020, mega_blend_add); c != 0xFF3020 {
+		t.Errorf("add blend is %06X, want FF3020", c)
+	}
+}
+
+func TestMegaChipScroll(t *testing.T) {
+	console, cpu, gpu := new_megachip_console()
+	gpu.pic[10][10] = 1
+	run_op(t, cpu, console, 0x00C3, "00CN")
+	run_op(t, cpu, console, 0x00FB, "00FB")
+	if gpu.pixel(14, 13) != 1 || gpu.pixel(10, 10) != 0 {
+		t.Errorf("pixel is not scrolled down and right")
+	}
+	run_op(t, cpu, console, 0x00B3, "00BN")
+	run_op(t, cpu, console, 0x00FC, "00FC")
+	if gpu.pixel(10, 10) != 1 || gpu.pixel(14, 13) != 0 {
+		t.Errorf("pixel is not scrolled up and left")
+	}
+	run_op(t, cpu, console, 0x00CF, "00CN")
+	run_op(t, cpu, console, 0x00CF, "00CN")
+	w, h := gpu.size()
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			if gpu.pixel(x, y) != 0 {
+				t.Fatalf("pixel scrolled out of screen comes back at %d,%d", x, y)
+			}
+		}
+	}
+}
+
+// SCHIP hi-res mode draws 16x16 sprites with DXY0
+func TestMegaChipHiresSprite(t *testing.T) {
+	console, cpu, gpu := new_megachip_console()
+	run_op(t, cpu, console, 0x00FF, "00FF")
+	if w, h := gpu.size(); w != 128 || h != 64 {
+		t.Fatalf("display is %dx%d, want 128x64", w, h)
+	}
+	for i := 0; i < 32; i++ {
+		console.mem.write(uint32(0x300+i), 0xFF)
+	}
+	cpu.i = 0x300
+	cpu.quirks.Wrap = false // SCHIP clips sprites
+	cpu.v[1], cpu.v[2] = 120, 40
+	run_op(t, cpu, console, 0xD120, "DXYN")
+	if gpu.pixel(127, 55) != 1 || gpu.pixel(120, 40) != 1 {
+		t.Errorf("16x16 sprite is not drawn")
+	}
+	if gpu.pixel(0, 40) != 0 {
+		t.Errorf("sprite is not clipped at right edge")
+	}
+	run_op(t, cpu, console, 0x00FE, "00FE")
+	if w, h := gpu.size(); w != 64 || h != 32 {
+		t.Errorf("display is %dx%d, want 64x32", w, h)
+	}
+}
+
+func TestMegaChipRPL(t *testing.T) {
+	console, cpu, _ := new_megachip_console()
+	for i := range cpu.v {
+		cpu.v[i] = Registr(i + 1)
+	}
+	run_op(t, cpu, console, 0xFF75, "FX75") // Only 8 flags
+	for i := range cpu.v {
+		cpu.v[i] = 0
+	}
+	run_op(t, cpu, console, 0xF385, "FX85")
+	expect_v(t, cpu, 3, 4)
+	expect_v(t, cpu, 4, 0)
+	run_op(t, cpu, console, 0xFF85, "FX85")
+	expect_v(t, cpu, 7, 8)
+	expect_v(t, cpu, 8, 0)
+}
+
+func TestMegaChipSample(t *testing.T) {
+	console, cpu, _ := new_megachip_console()
+	load_1802(console, 0x10000,
+		0x1F, 0x40, // 8000 Hz
+		0x00, 0x00, 0x03, // 3 samples
+		0x00,
+		0x80, 0xFF, 0x00)
+	cpu.i = 0x10000
+	run_op(t, cpu, console, 0x0601, "060N")
+	sound := console.sound.(*CHIP8Sound)
+	if sound.sample_rate != 8000 || sound.sample_loop || len(sound.sample) != 3 || sound.sample[1] != 0xFF {
+		t.Errorf("sample is %d Hz, loop %v, %v", sound.sample_rate, sound.sample_loop, sound.sample)
+	}
+	run_op(t, cpu, console, 0x0700, "0700")
+	if sound.sample != nil {
+		t.Errorf("sample is not stopped")
+	}
+}
+
+func TestETI660Load(t *testing.T) {
+	console := new_rom_console(t, []uint8{
+		0x60, 0x05, // LD V0, 5
+		0x61, 0xC8, // LD V1, 200: wraps to line 8 of 48
+		0xF0, 0x29, // LD F, V0
+		0xD0, 0x15, // DRW V0, V1, 5
+	}, "eti660")
+	cpu := console.cpu.(*CHIP8CPU)
+	expect_pc(t, cpu, 0x600)
+	if w, h := console.gpu.size(); w != 64 || h != 48 {
+		t.Fatalf("display is %dx%d, want 64x48", w, h)
+	}
+	for i := 0; i < 4; i++ {
+		console.tick()
+	}
+	if console.gpu.pixel(5, 8) != 1 || console.gpu.pixel(5, 12) != 1 {
+		t.Errorf("sprite doesn't wrap to line 8")
+	}
+}
+
+func TestETI660ToneAndColor(t *testing.T) {
+	console, cpu, gpu, _ := new_fake_console()
+	cpu.set_platform(PlatformETI660)
+	cpu.v[0] = 0x40
+	run_op(t, cpu, console, 0x00F8, "00F8")
+	if pitch := console.sound.(*CHIP8Sound).pitch; pitch != 0x40 {
+		t.Errorf("pitch is %02X, want 40", pitch)
+	}
+	gpu.pic[10][10] = 1
+	run_op(t, cpu, console, 0x00FC, "00FC")
+	if gpu.background != chip8x_background[1] {
+		t.Errorf("background is %06X, want %06X", gpu.background, chip8x_background[1])
+	}
+	if gpu.pixel(10, 10) != 1 {
+		t.Errorf("00FC scrolls display on ETI-660")
+	}
+	if ins := decode(0x00FC, PlatformCHIP8); ins == nil || ins.pattern != "0NNN" {
+		t.Errorf("00FC is not SYS on CHIP-8")
+	}
+}
+
+func new_boot_console(t *testing.T, rom []uint8, boot CHIP8Boot) (*CHIP8Console, error) {
+	return start_test_console(t, rom, &CHIP8Console{boot: boot})
+}
+
+func TestBootLoadAndEntry(t *testing.T) {
+	rom := []uint8{
+		0x00, 0x00, // Data before code
+		0x60, 0x07, // LD V0, 7
+		0x11, 0x04, // JP 104
+	}
+	console, err := new_boot_console(t, rom, CHIP8Boot{load: 0x100, entry: 0x102, has_load: true, has_entry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if console.mem.read(0x102) != 0x60 || console.mem.read(0x202) != 0 {
+		t.Errorf("ROM isn't loaded at 100")
+	}
+	if pc := console.cpu.program_counter(); pc != 0x102 {
+		t.Fatalf("PC is %03X", pc)
+	}
+	console.tick()
+	console.soft_reset()
+	if pc := console.cpu.program_counter(); pc != 0x102 {
+		t.Errorf("PC is %03X after reset", pc)
+	}
+}
+
+// Test images put code at 0, below font and interpreter area
+func TestBootAtZero(t *testing.T) {
+	rom := []uint8{
+		0x60, 0x07, // LD V0, 7
+		0x10, 0x02, // JP 002
+	}
+	console, err := new_boot_console(t, rom, CHIP8Boot{has_load: true, has_entry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc := console.cpu.program_counter(); pc != 0 || console.mem.read(0) != 0x60 {
+		t.Fatalf("PC is %03X, byte at 0 is %02X", pc, console.mem.read(0))
+	}
+	console.tick()
+	if v0 := console.cpu.(*CHIP8CPU).v[0]; v0 != 7 {
+		t.Errorf("V0 is %02X", v0)
+	}
+
+	image := make([]uint8, 0x1000)
+	copy(image, rom)
+	if console, err = new_boot_console(t, image, CHIP8Boot{image: true, has_entry: true}); err != nil {
+		t.Fatal(err)
+	}
+	if pc := console.cpu.program_counter(); pc != 0 {
+		t.Errorf("image starts at %03X, entry 0 expected", pc)
+	}
+}
+
+func TestBootImage(t *testing.T) {
+	image := make([]uint8, 0x10000)
+	image[0] = 0xAA // Own font
+	copy(image[0x200:], []uint8{
+		0xA0, 0x00, // LD I, 0
+		0xF0, 0x65, // LD V0, [I]
+		0x12, 0x04, // JP 204
+	})
+	console, err := new_boot_console(t, image, CHIP8Boot{image: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := console.mem.size(); size != 0x10000 {
+		t.Errorf("memory is %X bytes", size)
+	}
+	console.tick()
+	console.tick()
+	if v0 := console.cpu.(*CHIP8CPU).v[0]; v0 != 0xAA {
+		t.Errorf("V0 is %02X, byte of image expected", v0)
+	}
+
+	errors := map[string]CHIP8Boot{
+		"memory image is 6 bytes":           {image: true},
+		"load address 1000 is out of 1000":  {load: 0x1000, has_load: true},
+		"ROM of 6 bytes at FFC doesn't fit": {load: 0xFFC, has_load: true},
+		"entry 10000 is out of 10000 bytes": {image: true, entry: 0x10000, has_entry: true},
+	}
+	for msg, boot := range errors {
+		rom := image[0x200:0x206]
+		if boot.has_entry {
+			rom = image
+		}
+		if _, err := new_boot_console(t, rom, boot); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%+v: error %v, %q expected", boot, err, msg)
+		}
+	}
+	if _, err := new_boot_console(t, make([]uint8, 5000), CHIP8Boot{}); err == nil || !strings.Contains(err.Error(), "ROM of 5000 bytes at 200 doesn't fit 1000 bytes") {
+		t.Errorf("ROM bigger than memory: %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Console as libretro core: frontend calls run every 1/60 second with
+// RetroPad buttons, and takes XRGB8888 picture and stereo PCM of the frame.
+// libretro.go exports it as C entry points with -tags libretro
+type CHIP8Retro struct {
+	console *CHIP8Console
+	quirks  CHIP8Quirks // Quirks of ROM database or detection, core options go over them
+	cycles  int         // Instructions per frame of ROM database
+	speed   float64     // Emulated frames per run
+	due     float64     // Frames owed by speed, run when they make whole frame
+	keys    [16]uint8   // CHIP-8 key of RetroPad button
+	video   []uint32
+	audio   []int16
+}
+
+const (
+	retro_sample_rate  = 44100
+	retro_audio_frames = retro_sample_rate / frames_per_second // Stereo samples per run
+	retro_max_width    = 256
+	retro_max_height   = 192
+	retro_memory_max   = 0x10000 // Memory frontend sees, MegaChip has more
+)
+
+// RetroPad buttons in order of libretro.h ids
+var retro_buttons = []string{"b", "y", "select", "start", "up", "down", "left", "right", "a", "x", "l", "r", "l2", "r2", "l3", "r3"}
+
+// Every button has own key. Buttons of ROM database go over them
+var retro_default_keys = [16]uint8{0x0, 0xA, 0xC, 0xF, 0x2, 0x8, 0x4, 0x6, 0x5, 0xB, 0x1, 0x3, 0x7, 0x9, 0xD, 0xE}
+
+// Core option, shown by frontend. First value is default
+type retro_option struct {
+	key    string
+	desc   string
+	values []string
+}
+
+// Quirks by chip-8-database names, with descriptions of options
+var retro_quirks = [][2]string{
+	{"shift", "8XY6/8XYE shift VX"},
+	{"memoryIncrementByX", "FX55/FX65 increment I by X"},
+	{"memoryLeaveIUnchanged", "FX55/FX65 leave I unchanged"},
+	{"wrap", "Sprites wrap around edges"},
+	{"jump", "BXNN jumps to XNN+VX"},
+	{"vblank", "DXYN waits for display interrupt"},
+	{"logic", "8XY1/8XY2/8XY3 reset VF"},
+}
+
+func retro_options() []retro_option {
+	options := []retro_option{
+		{"chipigo_speed", "Emulation speed", []string{"1x", "0.25x", "0.5x", "1.5x", "2x", "4x", "8x"}},
+		{"chipigo_tickrate", "Instructions per frame", []string{"auto", "7", "10", "15", "20", "30", "50", "100", "200", "500", "1000"}},
+	}
+	for _, quirk := range retro_quirks {
+		options = append(options, retro_option{"chipigo_quirk_" + quirk[0], "Quirk: " + quirk[1], []string{"auto", "enabled", "disabled"}})
+	}
+	return options
+}
+
+// Loads ROM on headless console. Config is chipigo config file layer, nil is defaults
+func new_retro(path string, config *CHIP8Config) (*CHIP8Retro, error) {
+	console := &CHIP8Console{headless: true, config: config}
+	if err := console.init(path); err != nil {
+		return nil, err
+	}
+	retro := &CHIP8Retro{
+		console: console,
+		quirks:  console.settings.quirks,
+		cycles:  console.settings.cycles_per_frame,
+		speed:   console.speed,
+		keys:    retro_default_keys,
+		video:   make([]uint32, retro_max_width*retro_max_height),
+		audio:   make([]int16, 2*retro_audio_frames),
+	}
+	for button, key := range console.settings.keys {
+		for id, name := range retro_buttons {
+			if name == button {
+				retro.keys[id] = key
+			}
+		}
+	}
+	return retro, nil
+}
+
+// Applies core options, get returns value of option key, empty if frontend has none
+func (retro *CHIP8Retro) set_options(get func(key string) string) {
+	settings := retro.console.settings
+	if speed := strings.TrimSuffix(get("chipigo_speed"), "x"); speed != "" {
+		if val, err := strconv.ParseFloat(speed, 64); err == nil {
+			retro.console.set_speed(val)
+			retro.speed = retro.console.speed
+		}
+	}
+	settings.cycles_per_frame = retro.cycles
+	if tickrate, err := strconv.Atoi(get("chipigo_tickrate")); err == nil && tickrate > 0 {
+		settings.cycles_per_frame = tickrate
+	}
+	quirks := map[string]bool{} // Partial quirks, the same way ROM database has them
+	for _, quirk := range retro_quirks {
+		switch get("chipigo_quirk_" + quirk[0]) {
+		case "enabled":
+			quirks[quirk[0]] = true
+		case "disabled":
+			quirks[quirk[0]] = false
+		}
+	}
+	settings.quirks = retro.quirks
+	data, _ := json.Marshal(quirks)
+	json.Unmarshal(data, &settings.quirks)
+	retro.console.apply_cpu_settings()
+}
+
+// Runs frames due at emulation speed with buttons pressed, bit per RetroPad id
+func (retro *CHIP8Retro) run(buttons uint16) {
+	var keys uint16
+	for id, key := range retro.keys {
+		if buttons&(1<<uint(id)) != 0 {
+			keys |= 1 << uint(key)
+		}
+	}
+	retro.console.input.set_keys(keys)
+	for retro.due += retro.speed; retro.due >= 1; retro.due-- {
+		retro.console.emulate_frame()
+	}
+	retro.console.sound.pcm(retro.audio, retro_sample_rate)
+}
+
+// Picture of display, rows of w pixels
+func (retro *CHIP8Retro) frame() (pixels []uint32, w, h int) {
+	gpu := retro.console.gpu
+	w, h = gpu.size()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			retro.video[y*w+x] = gpu.pixel_color(x, y)
+		}
+	}
+	return retro.video[:w*h], w, h
+}
+
+func (retro *CHIP8Retro) reset() {
+	retro.console.hard_reset()
+	retro.due = 0
+}
+
+// Size of serialized state. Frontends need one size for whole game, so it's
+// size of state with largest display and values that take longest to encode
+func (retro *CHIP8Retro) state_size() int {
+	state, err := retro.console.save_state()
+	if err != nil {
+		return 0
+	}
+	state.Random = math.MaxUint64
+	s := &state.CPU
+	s.V = bytes.Repeat([]uint8{0xFF}, len(s.V))
+	s.I, s.PC, s.SP, s.DT, s.ST = math.MaxUint32, math.MaxUint16, math.MaxUint16, math.MaxUint8, math.MaxUint8
+	s.VBlanked, s.Cycles, s.Mega = true, math.MaxInt64, true
+	s.SpriteW, s.SpriteH, s.Collision = math.MaxInt64, math.MaxInt64, math.MaxUint8
+	for i := range s.RPL {
+		s.RPL[i] = 0xFF
+	}
+	state.Memory = bytes.Repeat([]uint8{0xFF}, len(state.Memory))
+	g := &state.GPU
+	g.W, g.H, g.Background, g.Mega = math.MaxInt64, math.MaxInt64, math.MaxInt64, true
+	g.Pic = make([][]uint8, retro_max_width)
+	g.Zones = make([][]uint8, retro_max_width/8)
+	g.RGB = make([][]uint32, retro_max_width)
+	for x := range g.Pic {
+		g.Pic[x] = bytes.Repeat([]uint8{0xFF}, retro_max_height)
+		g.RGB[x] = make([]uint32, retro_max_height)
+		for y := range g.RGB[x] {
+			g.RGB[x][y] = math.MaxUint32
+		}
+	}
+	for x := range g.Zones {
+		g.Zones[x] = bytes.Repeat([]uint8{0xFF}, retro_max_height)
+	}
+	for i := range g.Palette {
+		g.Palette[i] = math.MaxUint32
+	}
+	g.Blend, g.Alpha = math.MaxUint8, math.MaxUint8
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(state)
+	return 4 + buf.Len()
+}
+
+// Writes state into data: length, gob of state, zero padding
+func (retro *CHIP8Retro) save(data []uint8) error {
+	state, err := retro.console.save_state()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err = gob.NewEncoder(&buf).Encode(state); err != nil {
+		return err
+	}
+	if 4+buf.Len() > len(data) {
+		return fmt.Errorf("state takes %d bytes, %d are given", 4+buf.Len(), len(data))
+	}
+	binary.LittleEndian.PutUint32(data, uint32(buf.Len()))
+	n := copy(data[4:], buf.Bytes())
+	for i := 4 + n; i < len(data); i++ {
+		data[i] = 0
+	}
+	return nil
+}
+
+func (retro *CHIP8Retro) load(data []uint8) error {
+	if len(data) < 4 || int(binary.LittleEndian.Uint32(data)) > len(data)-4 {
+		return fmt.Errorf("state is cut")
+	}
+	n := binary.LittleEndian.Uint32(data)
+	state := &CHIP8State{}
+	if err := gob.NewDecoder(bytes.NewReader(data[4 : 4+n])).Decode(state); err != nil {
+		return err
+	}
+	return retro.console.load_state(state)
+}
+
+// Copies memory frontend sees into buf, up to its length
+func (retro *CHIP8Retro) read_memory(buf []uint8) {
+	for i := range buf {
+		buf[i] = retro.console.mem.read(uint32(i))
+	}
+}
+
+// Writes bytes of buf changed by frontend, cheats for example, into memory
+func (retro *CHIP8Retro) write_memory(buf []uint8) {
+	mem := retro.console.mem
+	for i, b := range buf {
+		if mem.read(uint32(i)) != b {
+			mem.write(uint32(i), b)
+		}
+	}
+}
+
+// Adds cheat of frontend. Code is hex ADDR:VALUE, several are joined with +
+func (retro *CHIP8Retro) cheat(code string) error {
+	console := retro.console
+	if console.cheats == nil {
+		console.cheats = &CHIP8Cheats{ROM: console.rom_hash}
+	}
+	for _, part := range strings.Split(code, "+") {
+		var addr uint32
+		var value uint8
+		if _, err := fmt.Sscanf(strings.TrimSpace(part), "%x:%x", &addr, &value); err != nil {
+			return fmt.Errorf("bad cheat %q, hex ADDR:VALUE is expected", part)
+		}
+		console.cheats.freeze(addr, value, code)
+	}
+	return nil
+}
+
+// Size of memory frontend sees
+func (retro *CHIP8Retro) memory_size() int {
+	size := int(retro.console.mem.size())
+	if size > retro_memory_max {
+		size = retro_memory_max
+	}
+	return size
+}
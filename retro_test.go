@@ -0,0 +1,137 @@
+package main
+
+import "testing"
+
+func new_test_retro(t *testing.T, rom []uint8) *CHIP8Retro {
+	retro, err := new_retro(write_test_rom(t, "test.ch8", rom), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return retro
+}
+
+// Lit pixels of picture
+func retro_lit(retro *CHIP8Retro) int {
+	pixels, _, _ := retro.frame()
+	lit := 0
+	for _, color := range pixels {
+		if color == retro.console.settings.foreground {
+			lit++
+		}
+	}
+	return lit
+}
+
+func TestRetroVideoAndInput(t *testing.T) {
+	retro := new_test_retro(t, key_rom)
+	retro.run(0)
+	pixels, w, h := retro.frame()
+	if w != 64 || h != 32 || len(pixels) != 64*32 {
+		t.Fatalf("picture %dx%d of %d pixels", w, h, len(pixels))
+	}
+	if pixels[0] != retro.console.settings.background || retro_lit(retro) != 0 {
+		t.Errorf("picture isn't empty before key")
+	}
+	retro.run(1 << 8) // A is key 5
+	if lit := retro_lit(retro); lit != 14 {
+		t.Errorf("digit 5 has %d lit pixels, 14 expected", lit)
+	}
+}
+
+func TestRetroAudio(t *testing.T) {
+	rom := []uint8{
+		0x60, 0x3C, // LD V0, 60
+		0xF0, 0x18, // LD ST, V0
+		0x12, 0x04, // JP 204
+	}
+	retro := new_test_retro(t, rom)
+	retro.run(0)
+	if len(retro.audio) != 2*735 {
+		t.Fatalf("%d samples per frame", len(retro.audio))
+	}
+	loud := 0
+	for i := 0; i < len(retro.audio); i += 2 {
+		if retro.audio[i] != retro.audio[i+1] {
+			t.Fatalf("channels differ at %d", i)
+		}
+		if retro.audio[i] > 0 {
+			loud++
+		}
+	}
+	if loud < 300 || loud > 435 { // Half of square wave
+		t.Errorf("%d of 735 samples are high", loud)
+	}
+}
+
+func TestRetroOptions(t *testing.T) {
+	retro := new_test_retro(t, key_rom)
+	options := map[string]string{"chipigo_speed": "0.5x", "chipigo_tickrate": "100", "chipigo_quirk_wrap": "disabled", "chipigo_quirk_jump": "enabled"}
+	retro.set_options(func(key string) string { return options[key] })
+	settings := retro.console.settings
+	if settings.cycles_per_frame != 100 || settings.quirks.Wrap || !settings.quirks.Jump || !settings.quirks.Shift {
+		t.Errorf("settings %+v", settings)
+	}
+	retro.run(0)
+	if retro.due != 0.5 {
+		t.Errorf("half frame isn't owed at half speed: %v", retro.due)
+	}
+
+	retro.set_options(func(key string) string { return "auto" }) // Back to ROM settings
+	if settings.cycles_per_frame != 15 || settings.quirks != default_quirks {
+		t.Errorf("settings %+v", settings)
+	}
+}
+
+func TestRetroSerialize(t *testing.T) {
+	retro := new_test_retro(t, score_rom)
+	size := retro.state_size()
+	state := make([]uint8, size)
+	if err := retro.save(state); err != nil {
+		t.Fatal(err)
+	}
+	retro.run(0)
+	retro.console.gpu.set_mega(true) // Largest state
+	if err := retro.save(make([]uint8, size)); err != nil {
+		t.Errorf("state with MegaChip display: %s", err.Error())
+	}
+	if err := retro.load(state); err != nil {
+		t.Fatal(err)
+	}
+	if w, h := retro.console.gpu.size(); w != 64 || h != 32 {
+		t.Errorf("display %dx%d after loading state", w, h)
+	}
+	if pc := retro.console.cpu.program_counter(); pc != 0x200 {
+		t.Errorf("PC %03X after loading state", pc)
+	}
+	if err := retro.load(state[:10]); err == nil {
+		t.Errorf("cut state is loaded")
+	}
+}
+
+func TestRetroMemory(t *testing.T) {
+	retro := new_test_retro(t, score_rom)
+	memory := make([]uint8, retro.memory_size())
+	retro.read_memory(memory)
+	if memory[0x200] != 0x71 || len(memory) != 0x1000 {
+		t.Fatalf("memory of %d bytes has %02X at 200", len(memory), memory[0x200])
+	}
+	memory[0x300] = 9 // Frontend cheat
+	retro.write_memory(memory)
+	if val := retro.console.mem.read(0x300); val != 9 {
+		t.Errorf("cheat byte is %d", val)
+	}
+}
+
+func TestRetroCheat(t *testing.T) {
+	retro := new_test_retro(t, lives_rom)
+	if err := retro.cheat("300:09+301:0A"); err != nil {
+		t.Fatal(err)
+	}
+	retro.run(0)
+	if a, b := retro.console.mem.read(0x300), retro.console.mem.read(0x301); a != 9 || b != 10 {
+		t.Errorf("cheat bytes are %d and %d", a, b)
+	}
+	if err := retro.cheat("300"); err == nil {
+		t.Errorf("bad cheat is set")
+	}
+}
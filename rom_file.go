@@ -0,0 +1,263 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ROM as loaded from file: raw binary, or unwrapped from archive, hex listing
+// or Octo cartridge
+type CHIP8RomFile struct {
+	data     []uint8       // Program, patched
+	original []uint8       // Program before patches
+	addr     int           // Address file puts program at, -1 is load address of platform
+	entry    string        // Entry of archive program is read from
+	options  *octo_options // Options of Octo cartridge, nil for other files
+}
+
+// Loader of file format, picked by extension or else by content
+type rom_loader struct {
+	name  string
+	exts  []string
+	sniff func(data []uint8) bool
+	load  func(file *CHIP8RomFile, name string, data []uint8) error
+}
+
+var rom_loaders []rom_loader
+
+func init() { // Loaders load archive entries through the table, so it's set at init
+	rom_loaders = []rom_loader{
+		{"zip archive", []string{".zip"}, is_zip, load_zip},
+		{"Octo cartridge", []string{".gif"}, is_gif, load_cartridge},
+		{"Intel HEX", ihex_exts, is_ihex, load_ihex},
+		{"S-record", srec_exts, is_srec, load_srec},
+		{"hex listing", []string{".hex", ".txt"}, is_hex_text, load_hex_text},
+	}
+}
+
+// Extensions of programs in archives
+var rom_exts = []string{".ch8", ".c8", ".c8x", ".mc8", ".sc8", ".xo8", ".rom", ".bin", ".hex", ".txt", ".gif", ".ihx", ".srec", ".s19"}
+
+// Reads program from file and applies patches to it. Entry picks program of
+// zip archive, empty asks when archive has several
+func load_rom_file(path, entry string, patches []string) (*CHIP8RomFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &CHIP8RomFile{entry: entry, addr: -1}
+	if err = load_rom_data(file, path, data); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if len(file.original) == 0 {
+		return nil, fmt.Errorf("%s: ROM is empty", path)
+	}
+	file.data, err = apply_patch_files(file.original, patches)
+	return file, err
+}
+
+// Loader of extension, the one whose content matches when several have it
+func load_rom_data(file *CHIP8RomFile, name string, data []uint8) error {
+	ext := strings.ToLower(filepath.Ext(name))
+	var by_ext *rom_loader
+	for i, loader := range rom_loaders {
+		if contains(loader.exts, ext) {
+			if loader.sniff(data) {
+				return loader.load(file, name, data)
+			}
+			if by_ext == nil {
+				by_ext = &rom_loaders[i]
+			}
+		}
+	}
+	if by_ext != nil {
+		return by_ext.load(file, name, data)
+	}
+	for _, loader := range rom_loaders {
+		if loader.sniff(data) {
+			return loader.load(file, name, data)
+		}
+	}
+	file.original = data
+	return nil
+}
+
+func is_zip(data []uint8) bool {
+	return bytes.HasPrefix(data, []uint8("PK\x03\x04"))
+}
+
+// Program of archive: the only one, entry asked for, or one user picks
+func load_zip(file *CHIP8RomFile, name string, data []uint8) error {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	var roms []*zip.File
+	for _, f := range archive.File {
+		if contains(rom_exts, strings.ToLower(filepath.Ext(f.Name))) {
+			roms = append(roms, f)
+		}
+	}
+	var rom *zip.File
+	switch {
+	case file.entry != "":
+		for _, f := range archive.File {
+			if f.Name == file.entry {
+				rom = f
+			}
+		}
+		if rom == nil {
+			return fmt.Errorf("archive has no %s", file.entry)
+		}
+	case len(roms) == 0:
+		return fmt.Errorf("archive has no ROM, extensions %s", strings.Join(rom_exts, " "))
+	case len(roms) == 1:
+		rom = roms[0]
+	default:
+		names := make([]string, len(roms))
+		for i, f := range roms {
+			names[i] = f.Name
+		}
+		i, err := pick_rom(name, names)
+		if err != nil {
+			return err
+		}
+		rom = roms[i]
+	}
+	r, err := rom.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if data, err = ioutil.ReadAll(r); err != nil {
+		return fmt.Errorf("%s: %s", rom.Name, err.Error())
+	}
+	file.entry = rom.Name
+	if err = load_rom_data(file, rom.Name, data); err != nil {
+		return fmt.Errorf("%s: %s", rom.Name, err.Error())
+	}
+	return nil
+}
+
+// Asks which program of archive to run. Tests replace it
+var pick_rom = func(archive string, names []string) (int, error) {
+	fmt.Printf("%s has %d ROMs:\n", filepath.Base(archive), len(names))
+	for i, name := range names {
+		fmt.Printf("%3d. %s\n", i+1, name)
+	}
+	fmt.Printf("Which one to run? [1-%d] ", len(names))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	n, err := strconv.Atoi(strings.TrimSpace(answer))
+	if err != nil || n < 1 || n > len(names) {
+		return 0, fmt.Errorf("no ROM is picked")
+	}
+	return n - 1, nil
+}
+
+func load_cartridge(file *CHIP8RomFile, name string, data []uint8) error {
+	cart, err := read_cartridge(data)
+	if err != nil {
+		return err
+	}
+	if file.original, err = octo_compile(cart.Program); err != nil {
+		return fmt.Errorf("Octo program: %s", err.Error())
+	}
+	file.options = &cart.Options
+	return nil
+}
+
+// Hex listing has only hex digits, address colons, spaces and comments.
+// Intel HEX records start with colon, so they aren't listings
+func is_hex_text(data []uint8) bool {
+	digits := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(hex_text_code(line))
+		if strings.HasPrefix(line, ":") {
+			return false
+		}
+		for _, c := range line {
+			switch {
+			case strings.ContainsRune("0123456789abcdefABCDEF", c):
+				digits = true
+			case !strings.ContainsRune(" \t\r:", c):
+				return false
+			}
+		}
+	}
+	return digits
+}
+
+// Line without ; or # comment
+func hex_text_code(line string) string {
+	if i := strings.IndexAny(line, ";#"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// Hex listing as printed in magazines:
+//
+//	0200: 6A 02 6B 0C
+//	0204  6C3F 6D0C
+//
+// First field of line is address if it ends with colon, is wider than the
+// bytes after it, or is the address the line goes to. Listing without
+// addresses starts at 200. Bytes are pairs of digits, fields may hold several
+func load_hex_text(file *CHIP8RomFile, name string, data []uint8) error {
+	base, addr := -1, 0x200
+	has_addr := false
+	var rom []uint8
+	for num, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(hex_text_code(line))
+		for i, field := range fields {
+			value := strings.TrimSuffix(field, ":")
+			n, err := strconv.ParseUint(value, 16, 32)
+			if err != nil {
+				return fmt.Errorf("line %d: %q isn't hex", num+1, field)
+			}
+			is_addr := strings.HasSuffix(field, ":")
+			if i == 0 && len(fields) > 1 && !is_addr {
+				is_addr = int(n) == addr || len(value) >= 3 && len(value) > len(fields[1])
+			}
+			if is_addr {
+				has_addr = true
+				if base < 0 {
+					base = int(n)
+				}
+				if int(n) < base {
+					return fmt.Errorf("line %d: address %X is before start %X", num+1, n, base)
+				}
+				addr = int(n)
+				continue
+			}
+			if len(value)%2 != 0 {
+				return fmt.Errorf("line %d: %q has odd number of digits", num+1, field)
+			}
+			if base < 0 {
+				base = addr
+			}
+			for j := 0; j < len(value); j += 2 {
+				b, _ := strconv.ParseUint(value[j:j+2], 16, 8)
+				if offset := addr - base; offset < len(rom) {
+					rom[offset] = uint8(b)
+				} else {
+					rom = append(rom, make([]uint8, offset-len(rom))...)
+					rom = append(rom, uint8(b))
+				}
+				addr++
+			}
+		}
+	}
+	file.original = rom
+	if has_addr { // Listing is loaded where it says, like HEX and S-record images
+		file.addr = base
+	}
+	return nil
+}
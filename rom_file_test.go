@@ -0,0 +1,169 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func write_test_file(t *testing.T, name string, data []uint8) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func make_zip(t *testing.T, files map[string][]uint8) []uint8 {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoadZip(t *testing.T) {
+	path := write_test_file(t, "games.zip", make_zip(t, map[string][]uint8{
+		"readme.txt.orig": []uint8("not a ROM"),
+		"games/pong.ch8":  {0x12, 0x00},
+	}))
+	file, err := load_rom_file(path, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(file.data, []uint8{0x12, 0x00}) || file.entry != "games/pong.ch8" {
+		t.Errorf("ROM % X of entry %s", file.data, file.entry)
+	}
+
+	path = write_test_file(t, "two.dat", make_zip(t, map[string][]uint8{ // Sniffed
+		"a.ch8": {0xAA},
+		"b.ch8": {0xBB},
+	}))
+	picked := []string(nil)
+	pick_rom = func(archive string, names []string) (int, error) {
+		picked = names
+		return 1, nil
+	}
+	if file, err = load_rom_file(path, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(picked) != 2 || file.entry != picked[1] || file.data[0] != 0xBB && file.entry == "b.ch8" {
+		t.Errorf("picked %s of %v", file.entry, picked)
+	}
+	picked = nil
+	if file, err = load_rom_file(path, "a.ch8", nil); err != nil || picked != nil || file.data[0] != 0xAA {
+		t.Errorf("entry a.ch8 isn't loaded without asking: %v", err)
+	}
+	if _, err = load_rom_file(path, "c.ch8", nil); err == nil || !strings.Contains(err.Error(), "archive has no c.ch8") {
+		t.Errorf("missing entry: %v", err)
+	}
+
+	path = write_test_file(t, "empty.zip", make_zip(t, map[string][]uint8{"notes.md": nil}))
+	if _, err = load_rom_file(path, "", nil); err == nil || !strings.Contains(err.Error(), "archive has no ROM") {
+		t.Errorf("archive without ROM: %v", err)
+	}
+}
+
+func TestLoadHexText(t *testing.T) {
+	tests := []struct {
+		text string
+		rom  []uint8
+		addr int
+	}{
+		{"0200: 6A 02 6B 0C\n0204: 6C3F ; comment\n", []uint8{0x6A, 0x02, 0x6B, 0x0C, 0x6C, 0x3F}, 0x200},
+		{"0200 A2 1E\n0202 C2 01\n", []uint8{0xA2, 0x1E, 0xC2, 0x01}, 0x200},
+		{"0200 A21E C201\n0204 3201\n", []uint8{0xA2, 0x1E, 0xC2, 0x01, 0x32, 0x01}, 0x200},
+		{"600: 12 34\n604: 56\n", []uint8{0x12, 0x34, 0x00, 0x00, 0x56}, 0x600}, // Gap
+		{"00E0 1200\n", []uint8{0x00, 0xE0, 0x12, 0x00}, -1},                     // Platform's load address
+	}
+	for _, test := range tests {
+		file := &CHIP8RomFile{addr: -1}
+		if !is_hex_text([]uint8(test.text)) {
+			t.Errorf("%q isn't sniffed as hex listing", test.text)
+		}
+		if err := load_hex_text(file, "", []uint8(test.text)); err != nil {
+			t.Errorf("%q: %s", test.text, err.Error())
+		} else if !bytes.Equal(file.original, test.rom) || file.addr != test.addr {
+			t.Errorf("%q: % X at %X, % X at %X expected", test.text, file.original, file.addr, test.rom, test.addr)
+		}
+	}
+	errors := map[string]string{
+		"0200: 6A 0G":          `line 1: "0G" isn't hex`,
+		"0200: 6A0\n":          "odd number of digits",
+		"0300: 00\n0200: 11\n": "line 2: address 200 is before start 300",
+	}
+	for text, msg := range errors {
+		if err := load_hex_text(&CHIP8RomFile{}, "", []uint8(text)); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: error %v, %q expected", text, err, msg)
+		}
+	}
+	if is_hex_text([]uint8{0x12, 0x00}) || is_hex_text([]uint8(":0400000012340000B6\n")) {
+		t.Errorf("binary or Intel HEX is sniffed as hex listing")
+	}
+	console := &CHIP8Console{headless: true}
+	if err := console.init(write_test_rom(t, "high.txt", []uint8("0300: 12 34\n"))); err != nil || console.load_address() != 0x300 || console.mem.read(0x300) != 0x12 || console.mem.read(0x200) != 0 {
+		t.Errorf("listing at 300 is loaded at %X: %v", console.load_address(), err)
+	}
+	path := write_test_file(t, "listing.txt", []uint8("zz"))
+	if _, err := load_rom_file(path, "", nil); err == nil || !strings.HasPrefix(err.Error(), path+": line 1") {
+		t.Errorf("error of listing file: %v", err)
+	}
+}
+
+func TestLoadCartridge(t *testing.T) {
+	gif := make_cartridge(t, `{"program": ": main\n  v0 := 7\n  loop again\n", "options": {
+		"tickrate": 100, "fillColor": "#FF0000", "backgroundColor": "#000000",
+		"shiftQuirks": true, "clipQuirks": true, "maxSize": 3215}}`)
+	path := write_test_file(t, "game.gif", gif)
+	file, err := load_rom_file(path, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(file.data, []uint8{0x60, 0x07, 0x12, 0x02}) {
+		t.Errorf("program % X", file.data)
+	}
+	console := &CHIP8Console{headless: true}
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	if err := console.init(path); err != nil {
+		t.Fatal(err)
+	}
+	settings := console.settings
+	if settings.platform_id != "modernChip8" || settings.cycles_per_frame != 100 || settings.foreground != 0xFF0000 ||
+		!settings.quirks.Shift || settings.quirks.Wrap {
+		t.Errorf("settings %+v", settings)
+	}
+
+	for size, msg := range map[int]string{3583: "is for SCHIP", 65024: "is for XO-CHIP"} {
+		options := fmt.Sprintf(`{"program": ": main loop again", "options": {"maxSize": %d}}`, size)
+		path := write_test_file(t, fmt.Sprintf("big%d.gif", size), make_cartridge(t, options))
+		if err := (&CHIP8Console{headless: true}).init(path); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("maxSize %d: error %v, %q expected", size, err, msg)
+		}
+		console := &CHIP8Console{headless: true, platform_id: "megachip8"} // Platform of user runs it anyway
+		if err := console.init(path); err != nil || console.settings.platform != PlatformMegaChip {
+			t.Errorf("maxSize %d with megachip8: %v", size, err)
+		}
+	}
+
+	bad := write_test_file(t, "bad.gif", make_cartridge(t, `{"program": ": main jump nowhere"}`))
+	if _, err = load_rom_file(bad, "", nil); err == nil || !strings.Contains(err.Error(), "Octo program: line 1: undefined name nowhere") {
+		t.Errorf("error of bad program: %v", err)
+	}
+	plain := write_test_file(t, "plain.gif", make_cartridge(t, "not json"))
+	if _, err = load_rom_file(plain, "", nil); err == nil || !strings.Contains(err.Error(), "not Octo cartridge") {
+		t.Errorf("error of plain GIF: %v", err)
+	}
+}
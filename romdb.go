@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ROM database in chip-8-database format (https://github.com/chip-8/chip-8-database):
+// programs.json, sha1-hashes.json and platforms.json. Bundled files are extended
+// by the same files in user database directory, settings of unknown ROMs are saved there too.
+
+//go:embed database/*.json
+var romdb_bundled embed.FS
+
+type ROMDatabase struct {
+	programs  []*ROMDBProgram
+	hashes    map[string]int // ROM SHA-1 to index in programs
+	platforms map[string]*ROMDBPlatform
+}
+
+type ROMDBProgram struct {
+	Title       string               `json:"title"`
+	Description string               `json:"description,omitempty"`
+	Authors     []string             `json:"authors,omitempty"`
+	Roms        map[string]*ROMDBRom `json:"roms"`
+}
+
+type ROMDBRom struct {
+	File            string                     `json:"file,omitempty"`
+	Platforms       []string                   `json:"platforms"`
+	QuirkyPlatforms map[string]json.RawMessage `json:"quirkyPlatforms,omitempty"` // Partial CHIP8Quirks by platform
+	Tickrate        int                        `json:"tickrate,omitempty"`
+	Keys            map[string]uint8           `json:"keys,omitempty"`
+	Colors          *ROMDBColors               `json:"colors,omitempty"`
+	FontStyle       string                     `json:"fontStyle,omitempty"` // Font ROM reads, name of built-in font
+}
+
+type ROMDBColors struct {
+	Pixels []string `json:"pixels,omitempty"` // Background, then foreground
+}
+
+type ROMDBPlatform struct {
+	Id                 string      `json:"id"`
+	Name               string      `json:"name"`
+	DisplayResolutions []string    `json:"displayResolutions"`
+	DefaultTickrate    int         `json:"defaultTickrate"`
+	Quirks             CHIP8Quirks `json:"quirks"`
+}
+
+// Database platforms supported by chipigo
+var romdb_platforms = map[string]Platform{
+	"originalChip8": PlatformCHIP8,
+	"hybridVIP":     PlatformHybridVIP,
+	"modernChip8":   PlatformCHIP8,
+	"chip8x":        PlatformCHIP8X,
+	"megachip8":     PlatformMegaChip,
+	"eti660":        PlatformETI660,   // Not in chip-8-database
+	"chip8HiRes":    PlatformHiresVIP, // Not in chip-8-database, detected by 1260 at 0x200
+}
+
+// Directory of user database. Empty if it can't be found
+func romdb_user_dir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "chipigo", "database")
+}
+
+func rom_hash(rom []uint8) string {
+	sum := sha1.Sum(rom)
+	return hex.EncodeToString(sum[:])
+}
+
+// Loads bundled database extended by user database
+func load_romdb() *ROMDatabase {
+	return load_romdb_from(romdb_user_dir())
+}
+
+// Loads bundled database extended by database in dir, empty dir is none
+func load_romdb_from(dir string) *ROMDatabase {
+	db := &ROMDatabase{hashes: make(map[string]int), platforms: make(map[string]*ROMDBPlatform)}
+	read_bundled := func(name string) ([]byte, error) {
+		return romdb_bundled.ReadFile("database/" + name)
+	}
+	if err := db.merge(read_bundled); err != nil {
+		panic("Bad bundled ROM database: " + err.Error())
+	}
+	if dir != "" {
+		read_user := func(name string) ([]byte, error) {
+			data, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return data, err
+		}
+		if err := db.merge(read_user); err != nil {
+			fmt.Printf("ROM database in %s is ignored: %s\n", dir, err.Error())
+		}
+	}
+	return db
+}
+
+// Adds database files to db. Missing files (nil data) are skipped
+func (db *ROMDatabase) merge(read func(name string) ([]byte, error)) error {
+	var programs []*ROMDBProgram
+	var hashes map[string]int
+	var platforms []*ROMDBPlatform
+	files := map[string]interface{}{"programs.json": &programs, "sha1-hashes.json": &hashes, "platforms.json": &platforms}
+	for name, dst := range files {
+		data, err := read(name)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			continue
+		}
+		if err = json.Unmarshal(data, dst); err != nil {
+			return fmt.Errorf("%s: %s", name, err.Error())
+		}
+	}
+	for hash, index := range hashes {
+		if index < 0 || index >= len(programs) {
+			return fmt.Errorf("sha1-hashes.json: bad program index %d", index)
+		}
+		db.hashes[strings.ToLower(hash)] = len(db.programs) + index
+	}
+	db.programs = append(db.programs, programs...)
+	for _, platform := range platforms {
+		db.platforms[platform.Id] = platform
+	}
+	return nil
+}
+
+// Returns settings for ROM on top of base settings, or base and false for unknown ROM
+func (db *ROMDatabase) lookup(hash string, base *CHIP8Settings) (*CHIP8Settings, bool) {
+	settings := base
+	index, ok := db.hashes[hash]
+	if !ok {
+		return settings, false
+	}
+	program := db.programs[index]
+	rom, ok := program.Roms[hash]
+	if !ok {
+		return settings, false
+	}
+	settings.title = program.Title
+	for _, id := range rom.Platforms {
+		if !db.set_platform(settings, id) {
+			continue
+		}
+		if quirks, ok := rom.QuirkyPlatforms[id]; ok {
+			if err := json.Unmarshal(quirks, &settings.quirks); err != nil {
+				fmt.Printf("Bad quirks of %s: %s\n", program.Title, err.Error())
+			}
+		}
+		break
+	}
+	if !contains(rom.Platforms, settings.platform_id) {
+		fmt.Printf("%s needs unsupported platform %s\n", program.Title, strings.Join(rom.Platforms, ", "))
+	}
+	if rom.Tickrate > 0 {
+		settings.cycles_per_frame = rom.Tickrate
+	}
+	if rom.Colors != nil && len(rom.Colors.Pixels) >= 2 {
+		bg, err1 := parse_color(rom.Colors.Pixels[0])
+		fg, err2 := parse_color(rom.Colors.Pixels[1])
+		if err1 == nil && err2 == nil {
+			settings.background, settings.foreground = bg, fg
+		}
+	}
+	if _, ok := chip8_fonts[rom.FontStyle]; ok {
+		settings.font = rom.FontStyle
+	}
+	for button, key := range rom.Keys {
+		settings.keys[button] = key
+	}
+	return settings, true
+}
+
+// Sets platform with its quirks and tickrate. False if platform isn't supported
+func (db *ROMDatabase) set_platform(settings *CHIP8Settings, id string) bool {
+	platform, ok := romdb_platforms[id]
+	if !ok {
+		return false
+	}
+	settings.platform = platform
+	settings.platform_id = id
+	if p, ok := db.platforms[id]; ok {
+		settings.quirks = p.Quirks
+		if p.DefaultTickrate > 0 {
+			settings.cycles_per_frame = p.DefaultTickrate
+		}
+	}
+	return true
+}
+
+func contains(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// Writes settings of ROM to user database
+func save_rom_settings(hash, rom_path string, settings *CHIP8Settings) error {
+	dir := romdb_user_dir()
+	if dir == "" {
+		return fmt.Errorf("user config directory is not found")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var programs []*ROMDBProgram
+	hashes := make(map[string]int)
+	for name, dst := range map[string]interface{}{"programs.json": &programs, "sha1-hashes.json": &hashes} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(data, dst); err != nil {
+			return fmt.Errorf("%s: %s", name, err.Error())
+		}
+	}
+	quirks, _ := json.Marshal(settings.quirks)
+	rom := &ROMDBRom{
+		File:            filepath.Base(rom_path),
+		Platforms:       []string{settings.platform_id},
+		QuirkyPlatforms: map[string]json.RawMessage{settings.platform_id: quirks},
+		Tickrate:        settings.cycles_per_frame,
+		Colors:          &ROMDBColors{Pixels: []string{format_color(settings.background), format_color(settings.foreground)}},
+	}
+	if len(settings.keys) > 0 {
+		rom.Keys = settings.keys
+	}
+	if _, ok := chip8_fonts[settings.font]; ok {
+		rom.FontStyle = settings.font
+	}
+	title := settings.title
+	if title == "" {
+		title = strings.TrimSuffix(rom.File, filepath.Ext(rom.File))
+	}
+	programs = append(programs, &ROMDBProgram{Title: title, Roms: map[string]*ROMDBRom{hash: rom}})
+	hashes[hash] = len(programs) - 1
+	for name, src := range map[string]interface{}{"programs.json": programs, "sha1-hashes.json": hashes} {
+		data, err := json.MarshalIndent(src, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stdin is typed by user, not piped or redirected
+func stdin_is_terminal() bool {
+	stat, err := os.Stdin.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// Asks user whether settings of unknown ROM should be saved
+func prompt_save_settings(hash, rom_path string, settings *CHIP8Settings) {
+	fmt.Printf("%s is not in ROM database. Save settings used for it? [y/N] ", filepath.Base(rom_path))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "y" && answer != "yes" {
+		return
+	}
+	if err := save_rom_settings(hash, rom_path, settings); err != nil {
+		fmt.Printf("Settings are not saved: %s\n", err.Error())
+		return
+	}
+	fmt.Printf("Settings are saved to %s\n", romdb_user_dir())
+}
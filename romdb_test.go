@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestROMDatabaseSaveAndLookup(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	hash := rom_hash([]uint8{0x12, 0x00})
+	if _, known := load_romdb().lookup(hash, default_settings()); known {
+		t.Fatal("unknown ROM is found")
+	}
+	settings := default_settings()
+	settings.cycles_per_frame = 30
+	settings.quirks.Jump = true
+	settings.background = 0x102030
+	settings.keys["up"] = 5
+	if err := save_rom_settings(hash, "/roms/jump.ch8", settings); err != nil {
+		t.Fatal(err)
+	}
+	found, known := load_romdb().lookup(hash, default_settings())
+	if !known {
+		t.Fatal("saved ROM is not found")
+	}
+	if found.title != "jump" || found.cycles_per_frame != 30 || found.quirks != settings.quirks ||
+		found.background != 0x102030 || found.foreground != settings.foreground || found.keys["up"] != 5 {
+		t.Errorf("lookup returned %+v, want %+v", found, settings)
+	}
+}
+
+func TestROMDatabasePlatformQuirks(t *testing.T) {
+	db := load_romdb()
+	db.programs = append(db.programs, &ROMDBProgram{Title: "Test", Roms: map[string]*ROMDBRom{
+		"abc": {Platforms: []string{"superchip", "originalChip8"}},
+	}})
+	db.hashes["abc"] = len(db.programs) - 1
+	settings, known := db.lookup("abc", default_settings())
+	if !known || settings.platform_id != "originalChip8" {
+		t.Fatalf("unsupported platform is not skipped: %+v", settings)
+	}
+	if settings.quirks != db.platforms["originalChip8"].Quirks || settings.cycles_per_frame != 15 {
+		t.Errorf("platform defaults are not applied: %+v", settings)
+	}
+}
+
+// IBM logo ROM is known by bundled database without user files
+func TestROMDatabaseBundled(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	rom, _ := hex.DecodeString("00e0a22a600c6108d01f7009a239d01fa2487008d01f7004a257d01f7008a266d01f7008a275d01f1228" +
+		"ff00ff003c003c003c003c00ff00ffff00ff0038003f003f003800ff00ff8000e000e00080008000e000e00080" +
+		"f800fc003e003f003b003900f800f8030007000f00bf00fb00f300e30043e000e0008000800080008000e000e0")
+	settings, known := load_romdb().lookup(rom_hash(rom), default_settings())
+	if !known || settings.title != "IBM Logo" || settings.platform_id != "originalChip8" || settings.cycles_per_frame != 15 {
+		t.Errorf("IBM logo: known %v, settings %+v", known, settings)
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Starlark script attached to console: trainers, bots and playthrough checks.
+// Top level of script registers callbacks, which run as emulation goes:
+//
+//	on_frame(fn)            fn(frame) after every frame
+//	on_pc(addr, fn)         fn(addr) before instruction at addr runs
+//	on_write(addr, fn)      fn(addr, value) after byte at addr is written
+//	on_draw(fn)             fn(x, y, n, collision) after DXYN has drawn
+//
+// Callbacks use the machine with:
+//
+//	peek(addr), poke(addr, value)
+//	reg(name), set_reg(name, value)     v0-vf, i, pc, sp, dt, st
+//	press(key), release(key)            keys are held on top of player's
+//	text(x, y, s), clear_text()         overlay in display pixels
+//	screenshot(path)                    PNG in directory granted by -script-dir
+//	quit()                              stop emulation
+//
+// Starlark can't reach files, network or clock by itself, so script can only
+// do what these builtins let it. Top level and every callback may run up to
+// script_max_steps Starlark steps, so endless loops don't hang emulation.
+// Error in script stops emulation.
+type CHIP8Script struct {
+	console *CHIP8Console
+	thread  *starlark.Thread
+	dir     string // Directory granted for files, empty is no access
+
+	frame_hooks []starlark.Callable
+	pc_hooks    map[uint16][]starlark.Callable
+	write_hooks map[uint32][]starlark.Callable
+	draw_hooks  []starlark.Callable
+
+	frames  int
+	keys    uint16 // Keys pressed by script
+	running bool   // Callback runs, hooks don't nest
+	quit    bool
+	err     error
+}
+
+// Steps of Starlark VM that top level or one callback may take
+const script_max_steps = 1000000
+
+// Names of registers for reg and set_reg
+var script_registers = []string{"i", "pc", "sp", "dt", "st"}
+
+func load_script(console *CHIP8Console, path, dir string) (*CHIP8Script, error) {
+	script := &CHIP8Script{
+		console:     console,
+		dir:         dir,
+		pc_hooks:    make(map[uint16][]starlark.Callable),
+		write_hooks: make(map[uint32][]starlark.Callable),
+	}
+	script.thread = &starlark.Thread{
+		Name:  path,
+		Print: func(_ *starlark.Thread, msg string) { fmt.Printf("%s\n", msg) },
+	}
+	// Globals aren't frozen after top level has run: callbacks keep their state in them
+	options := &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true, Recursion: true}
+	builtins := script.builtins()
+	_, program, err := starlark.SourceProgramOptions(options, path, nil, builtins.Has)
+	if err != nil {
+		return nil, err
+	}
+	script.budget()
+	if _, err = program.Init(script.thread, builtins); err != nil {
+		return nil, script_error(err)
+	}
+	console.mem.set_watch(script.write)
+	return script, nil
+}
+
+// Error with Starlark backtrace when there is one
+func script_error(err error) error {
+	if eval, ok := err.(*starlark.EvalError); ok {
+		return fmt.Errorf("%s", eval.Backtrace())
+	}
+	return err
+}
+
+func (script *CHIP8Script) builtins() starlark.StringDict {
+	builtins := map[string]func(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error){
+		"on_frame":   script.on_frame,
+		"on_pc":      script.on_pc,
+		"on_write":   script.on_write,
+		"on_draw":    script.on_draw,
+		"peek":       script.peek,
+		"poke":       script.poke,
+		"reg":        script.reg,
+		"set_reg":    script.set_reg,
+		"press":      script.press,
+		"release":    script.release,
+		"text":       script.text,
+		"clear_text": script.clear_text,
+		"screenshot": script.screenshot,
+		"quit":       script.stop,
+	}
+	dict := starlark.StringDict{}
+	for name, fn := range builtins {
+		fn := fn
+		dict[name] = starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			value, err := fn(args, kwargs)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", b.Name(), err.Error())
+			}
+			return value, nil
+		})
+	}
+	return dict
+}
+
+// Gives next run of script its steps. Thread is cancelled when they run out
+func (script *CHIP8Script) budget() {
+	script.thread.SetMaxExecutionSteps(script.thread.ExecutionSteps() + script_max_steps)
+}
+
+// Emulation should stop: script has quit or failed
+func (script *CHIP8Script) done() bool {
+	return script.quit || script.err != nil
+}
+
+// Calls hooks, first error is kept and stops calling
+func (script *CHIP8Script) call(hooks []starlark.Callable, args ...starlark.Value) {
+	if script.running || script.done() {
+		return
+	}
+	script.running = true
+	defer func() { script.running = false }()
+	for _, fn := range hooks {
+		script.budget()
+		if _, err := starlark.Call(script.thread, fn, args, nil); err != nil {
+			script.err = script_error(err)
+			return
+		}
+	}
+}
+
+// Runs instruction, calling hooks of its address and of drawing
+func (script *CHIP8Script) tick() {
+	console := script.console
+	pc := console.cpu.program_counter()
+	if hooks, ok := script.pc_hooks[pc]; ok {
+		script.call(hooks, starlark.MakeInt(int(pc)))
+	}
+	op := OpCode(console.mem.read2(uint32(pc)))
+	console.cpu.tick(console)
+	if len(script.draw_hooks) > 0 && op&0xF000 == 0xD000 && console.cpu.program_counter() != pc {
+		state := &CHIP8State{}
+		console.cpu.save_state(state)
+		v := state.CPU.V
+		script.call(script.draw_hooks, starlark.MakeInt(int(v[op>>8&0xF])), starlark.MakeInt(int(v[op>>4&0xF])),
+			starlark.MakeInt(int(op&0xF)), starlark.Bool(v[0xF] != 0))
+	}
+}
+
+func (script *CHIP8Script) after_frame() {
+	script.frames++
+	script.call(script.frame_hooks, starlark.MakeInt(script.frames))
+}
+
+func (script *CHIP8Script) write(addr uint32, val uint8) {
+	if hooks, ok := script.write_hooks[addr]; ok {
+		script.call(hooks, starlark.MakeUint(uint(addr)), starlark.MakeInt(int(val)))
+	}
+}
+
+func (script *CHIP8Script) on_frame(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var fn starlark.Callable
+	if err := starlark.UnpackPositionalArgs("on_frame", args, kwargs, 1, &fn); err != nil {
+		return nil, err
+	}
+	script.frame_hooks = append(script.frame_hooks, fn)
+	return starlark.None, nil
+}
+
+func (script *CHIP8Script) on_pc(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var addr int
+	var fn starlark.Callable
+	if err := starlark.UnpackPositionalArgs("on_pc", args, kwargs, 2, &addr, &fn); err != nil {
+		return nil, err
+	}
+	script.pc_hooks[uint16(addr)] = append(script.pc_hooks[uint16(addr)], fn)
+	return starlark.None, nil
+}
+
+func (script *CHIP8Script) on_write(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var addr int
+	var fn starlark.Callable
+	if err := starlark.UnpackPositionalArgs("on_write", args, kwargs, 2, &addr, &fn); err != nil {
+		return nil, err
+	}
+	script.write_hooks[uint32(addr)] = append(script.write_hooks[uint32(addr)], fn)
+	return starlark.None, nil
+}
+
+func (script *CHIP8Script) on_draw(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var fn starlark.Callable
+	if err := starlark.UnpackPositionalArgs("on_draw", args, kwargs, 1, &fn); err != nil {
+		return nil, err
+	}
+	script.draw_hooks = append(script.draw_hooks, fn)
+	return starlark.None, nil
+}
+
+func (script *CHIP8Script) peek(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var addr int
+	if err := starlark.UnpackPositionalArgs("peek", args, kwargs, 1, &addr); err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt(int(script.console.mem.read(uint32(addr)))), nil
+}
+
+func (script *CHIP8Script) poke(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var addr, val int
+	if err := starlark.UnpackPositionalArgs("poke", args, kwargs, 2, &addr, &val); err != nil {
+		return nil, err
+	}
+	script.console.mem.write(uint32(addr), uint8(val))
+	return starlark.None, nil
+}
+
+// Register of CPU state by name
+func script_register(state *CHIP8CPUState, name string) (get func() int, set func(int), err error) {
+	name = strings.ToLower(name)
+	if len(name) == 2 && name[0] == 'v' && strings.ContainsRune("0123456789abcdef", rune(name[1])) {
+		x := strings.IndexRune("0123456789abcdef", rune(name[1]))
+		return func() int { return int(state.V[x]) }, func(val int) { state.V[x] = uint8(val) }, nil
+	}
+	switch name {
+	case "i":
+		return func() int { return int(state.I) }, func(val int) { state.I = uint32(val) }, nil
+	case "pc":
+		return func() int { return int(state.PC) }, func(val int) { state.PC = uint16(val) }, nil
+	case "sp":
+		return func() int { return int(state.SP) }, func(val int) { state.SP = uint16(val) }, nil
+	case "dt":
+		return func() int { return int(state.DT) }, func(val int) { state.DT = uint8(val) }, nil
+	case "st":
+		return func() int { return int(state.ST) }, func(val int) { state.ST = uint8(val) }, nil
+	}
+	return nil, nil, fmt.Errorf("unknown register %s, known are v0-vf, %s", name, strings.Join(script_registers, ", "))
+}
+
+func (script *CHIP8Script) reg(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackPositionalArgs("reg", args, kwargs, 1, &name); err != nil {
+		return nil, err
+	}
+	state := &CHIP8State{}
+	script.console.cpu.save_state(state)
+	get, _, err := script_register(&state.CPU, name)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt(get()), nil
+}
+
+func (script *CHIP8Script) set_reg(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var val int
+	if err := starlark.UnpackPositionalArgs("set_reg", args, kwargs, 2, &name, &val); err != nil {
+		return nil, err
+	}
+	state := &CHIP8State{}
+	script.console.cpu.save_state(state)
+	_, set, err := script_register(&state.CPU, name)
+	if err != nil {
+		return nil, err
+	}
+	set(val)
+	script.console.cpu.load_state(state)
+	return starlark.None, nil
+}
+
+func (script *CHIP8Script) press(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	return script.set_key("press", args, kwargs, true)
+}
+
+func (script *CHIP8Script) release(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	return script.set_key("release", args, kwargs, false)
+}
+
+func (script *CHIP8Script) set_key(name string, args starlark.Tuple, kwargs []starlark.Tuple, down bool) (starlark.Value, error) {
+	var key int
+	if err := starlark.UnpackPositionalArgs(name, args, kwargs, 1, &key); err != nil {
+		return nil, err
+	}
+	if key < 0 || key > 0xF {
+		return nil, fmt.Errorf("key %d is not 0-15", key)
+	}
+	if down {
+		script.keys |= 1 << uint(key)
+	} else {
+		script.keys &^= 1 << uint(key)
+	}
+	script.console.input.hold(script.keys)
+	return starlark.None, nil
+}
+
+func (script *CHIP8Script) text(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, y starlark.Value
+	var text string
+	if err := starlark.UnpackPositionalArgs("text", args, kwargs, 3, &x, &y, &text); err != nil {
+		return nil, err
+	}
+	fx, ok_x := starlark.AsFloat(x)
+	fy, ok_y := starlark.AsFloat(y)
+	if !ok_x || !ok_y {
+		return nil, fmt.Errorf("position must be numbers")
+	}
+	script.console.gpu.draw_text(fx, fy, text)
+	return starlark.None, nil
+}
+
+func (script *CHIP8Script) clear_text(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs("clear_text", args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	script.console.gpu.clear_text()
+	return starlark.None, nil
+}
+
+func (script *CHIP8Script) screenshot(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path string
+	if err := starlark.UnpackPositionalArgs("screenshot", args, kwargs, 1, &path); err != nil {
+		return nil, err
+	}
+	full, err := script.file(path)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.None, write_png(full, screenshot(script.console.gpu))
+}
+
+// Path of file in granted directory. Paths out of it aren't allowed
+func (script *CHIP8Script) file(path string) (string, error) {
+	if script.dir == "" {
+		return "", fmt.Errorf("script has no file access, -script-dir grants it")
+	}
+	clean := filepath.Clean(path)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is out of script directory", path)
+	}
+	return filepath.Join(script.dir, clean), nil
+}
+
+func (script *CHIP8Script) stop(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs("quit", args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	script.quit = true
+	return starlark.None, nil
+}
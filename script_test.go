@@ -0,0 +1,179 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Console running ROM with script of source
+func new_script_console(t *testing.T, rom []uint8, source, dir string) (*CHIP8Console, error) {
+	script_path := write_test_file(t, "test.star", []byte(source))
+	return start_test_console(t, rom, &CHIP8Console{script_path: script_path, script_dir: dir})
+}
+
+func run_script_frames(console *CHIP8Console, frames int) {
+	for i := 0; i < frames && !console.script_done(); i++ {
+		console.frame()
+	}
+}
+
+func TestScriptHooks(t *testing.T) {
+	console, err := new_script_console(t, counter_rom, `
+jumps = []
+def jump(pc):
+    jumps.append(pc)
+    if len(jumps) == 3:
+        set_reg("v0", 100)
+
+def frame(n):
+    poke(0x300, reg("v0"))
+    if n == 2:
+        text(1, 1, "jumps %d" % len(jumps))
+        quit()
+
+on_pc(0x202, jump)
+on_frame(frame)
+`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run_script_frames(console, 10)
+	if console.script.frames != 2 || !console.script_done() || console.script.err != nil {
+		t.Errorf("script has run %d frames, err %v", console.script.frames, console.script.err)
+	}
+	cpu := console.cpu.(*CHIP8CPU)
+	if want := Registr(100 + console.settings.cycles_per_frame - 3); cpu.v[0] != want {
+		t.Errorf("V0 is %d, want %d", cpu.v[0], want)
+	}
+	if console.mem.read(0x300) != uint8(cpu.v[0]) {
+		t.Errorf("poke of V0 wrote %d", console.mem.read(0x300))
+	}
+	if gpu := console.gpu.(*CHIP8GPU); len(gpu.overlay) != 1 || gpu.overlay[0].text != "jumps 15" {
+		t.Errorf("overlay is %+v", gpu.overlay)
+	}
+}
+
+func TestScriptWriteAndDraw(t *testing.T) {
+	console, err := new_script_console(t, score_rom, `
+scores = []
+on_write(0x302, lambda addr, val: scores.append(val))
+def check(n):
+    if n == 2 and scores != [1, 2, 3, 4, 5]:
+        fail("scores are %s" % scores)
+on_frame(check)
+`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run_script_frames(console, 2)
+	if console.script.err != nil {
+		t.Error(console.script.err)
+	}
+
+	console, err = new_script_console(t, key_rom, `
+draws = []
+on_frame(lambda n: press(5))
+on_draw(lambda x, y, n, collision: draws.append((x, y, n, collision)))
+def check(n):
+    if n == 3 and draws != [(0, 0, 5, False)]:
+        fail("draws are %s" % draws)
+on_frame(check)
+`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run_script_frames(console, 3)
+	if console.script.err != nil {
+		t.Error(console.script.err)
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	if _, err := new_script_console(t, counter_rom, "on_frame(1, 2)", ""); err == nil {
+		t.Errorf("bad call at top level is not an error")
+	}
+	console, err := new_script_console(t, counter_rom, `on_frame(lambda n: reg("v16"))`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run_script_frames(console, 10)
+	if console.script.err == nil || !strings.Contains(console.script.err.Error(), "unknown register") {
+		t.Errorf("error is %v", console.script.err)
+	}
+	if console.script.frames != 1 {
+		t.Errorf("emulation goes on after error: %d frames", console.script.frames)
+	}
+}
+
+// Files are written only into granted directory
+// Endless loops run out of steps instead of hanging emulation
+func TestScriptStepLimit(t *testing.T) {
+	if _, err := new_script_console(t, counter_rom, "while True:\n    pass\n", ""); err == nil || !strings.Contains(err.Error(), "too many steps") {
+		t.Errorf("endless top level: %v", err)
+	}
+	console, err := new_script_console(t, counter_rom, `
+def frame(n):
+    for i in range(100000): # Most of budget, which every callback gets anew
+        pass
+    if n == 3:
+        while True:
+            pass
+
+on_frame(frame)
+`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run_script_frames(console, 10)
+	if console.script.frames != 3 || console.script.err == nil || !strings.Contains(console.script.err.Error(), "too many steps") {
+		t.Errorf("endless callback at frame %d: %v", console.script.frames, console.script.err)
+	}
+}
+
+func TestScriptSandbox(t *testing.T) {
+	console, _ := new_script_console(t, counter_rom, `on_frame(lambda n: screenshot("shot.png"))`, "")
+	run_script_frames(console, 1)
+	if console.script.err == nil || !strings.Contains(console.script.err.Error(), "no file access") {
+		t.Errorf("screenshot without grant: %v", console.script.err)
+	}
+
+	dir := t.TempDir()
+	console, _ = new_script_console(t, counter_rom, `on_frame(lambda n: screenshot("../shot.png"))`, dir)
+	run_script_frames(console, 1)
+	if console.script.err == nil || !strings.Contains(console.script.err.Error(), "out of script directory") {
+		t.Errorf("screenshot out of directory: %v", console.script.err)
+	}
+
+	console, _ = new_script_console(t, counter_rom, `on_frame(lambda n: screenshot("shot.png"))`, dir)
+	run_script_frames(console, 1)
+	if _, err := os.Stat(filepath.Join(dir, "shot.png")); err != nil || console.script.err != nil {
+		t.Errorf("screenshot in granted directory: %v, %v", err, console.script.err)
+	}
+
+	if _, err := new_script_console(t, counter_rom, `load("other.star", "x")`, dir); err == nil {
+		t.Errorf("script loads other files")
+	}
+}
+
+func TestOverlayPixels(t *testing.T) {
+	gpu := &CHIP8GPU{headless: true}
+	gpu.init()
+	gpu.draw_text(2, 3, "1 ")
+	var count int
+	var first_x, first_y float64
+	gpu.overlay_pixels(func(x, y, size float64) {
+		if count == 0 {
+			first_x, first_y = x, y
+		}
+		count++
+	})
+	if count != 8 || first_x != 2+0.4 || first_y != 3 {
+		t.Errorf("%d pixels, first at %v,%v", count, first_x, first_y)
+	}
+	gpu.clear_text()
+	if gpu.overlay != nil {
+		t.Errorf("text is not cleared")
+	}
+}
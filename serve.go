@@ -0,0 +1,266 @@
+package main
+
+import (
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Page of serve: canvas fed over WebSocket, keyboard sent back
+//
+//go:embed web/*
+var serve_web embed.FS
+
+// Runs console headlessly for WebSocket clients. The first connected client
+// plays, others spectate; when player leaves, the next client plays.
+//
+// Server messages are JSON objects by type:
+//
+//	{"type": "hello", "role": "player", "foreground": "#11ff11", "background": "#1a1a1a"}
+//	{"type": "role", "role": "player"}
+//	{"type": "frame", "width": 64, "height": 32, "pixels": "<base64 of byte per pixel, by rows>"}
+//	{"type": "delta", "pixels": [[index, value], ...]}
+//	{"type": "sound", "on": true}
+//
+// Client messages: {"type": "key", "key": 5, "down": true}
+type CHIP8Server struct {
+	console *CHIP8Console
+	lock    sync.Mutex
+	clients []*serve_client // In connection order, first one plays
+	keys    uint16          // Keys held by player
+	screen  []uint8         // Picture sent to clients, byte per pixel by rows
+	w, h    int
+	beep    bool
+}
+
+type serve_client struct {
+	ws   *ws_conn
+	send chan []byte // Messages to client, written by its writer
+}
+
+// Messages queued for slow client before it's dropped
+const serve_queue = 64
+
+type serve_message struct {
+	Type       string `json:"type"`
+	Role       string `json:"role,omitempty"`
+	Foreground string `json:"foreground,omitempty"`
+	Background string `json:"background,omitempty"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Pixels     string `json:"pixels,omitempty"`
+	On         *bool  `json:"on,omitempty"`
+}
+
+type serve_delta struct {
+	Type   string   `json:"type"`
+	Pixels [][2]int `json:"pixels"`
+}
+
+type serve_key struct {
+	Type string `json:"type"`
+	Key  uint8  `json:"key"`
+	Down bool   `json:"down"`
+}
+
+func new_server(console *CHIP8Console) *CHIP8Server {
+	server := &CHIP8Server{console: console}
+	server.screen, server.w, server.h = server.capture()
+	return server
+}
+
+func (server *CHIP8Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	web, _ := fs.Sub(serve_web, "web")
+	mux.Handle("/", http.FileServer(http.FS(web)))
+	mux.HandleFunc("/ws", server.serve_ws)
+	return mux
+}
+
+// Runs frames at console speed until stop is closed
+func (server *CHIP8Server) run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Duration(float64(time.Second) / frames_per_second / server.console.speed)):
+			server.frame()
+		}
+	}
+}
+
+// Runs one frame with player keys and sends changes to clients
+func (server *CHIP8Server) frame() {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.console.input.set_keys(server.keys)
+	server.console.frame()
+
+	screen, w, h := server.capture()
+	if w != server.w || h != server.h {
+		server.screen, server.w, server.h = screen, w, h
+		server.broadcast(server.frame_message())
+	} else {
+		delta := &serve_delta{Type: "delta", Pixels: make([][2]int, 0)}
+		for i := range screen {
+			if screen[i] != server.screen[i] {
+				delta.Pixels = append(delta.Pixels, [2]int{i, int(screen[i])})
+			}
+		}
+		server.screen = screen
+		if len(delta.Pixels) > 0 {
+			server.broadcast(delta)
+		}
+	}
+	if beep := server.console.sound.beeping(); beep != server.beep {
+		server.beep = beep
+		server.broadcast(&serve_message{Type: "sound", On: &beep})
+	}
+}
+
+// Picture of console, byte per pixel by rows
+func (server *CHIP8Server) capture() ([]uint8, int, int) {
+	gpu := server.console.gpu
+	w, h := gpu.size()
+	screen := make([]uint8, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			screen[y*w+x] = gpu.pixel(x, y)
+		}
+	}
+	return screen, w, h
+}
+
+func (server *CHIP8Server) frame_message() *serve_message {
+	return &serve_message{Type: "frame", Width: server.w, Height: server.h,
+		Pixels: base64.StdEncoding.EncodeToString(server.screen)}
+}
+
+// Queues message for all clients. Locked by caller
+func (server *CHIP8Server) broadcast(msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return
+	}
+	for _, client := range server.clients {
+		client.queue(data)
+	}
+}
+
+// Slow client that doesn't take messages is disconnected
+func (client *serve_client) queue(data []byte) {
+	select {
+	case client.send <- data:
+	default:
+		client.ws.close()
+	}
+}
+
+func (client *serve_client) queue_message(msg interface{}) {
+	data, _ := json.Marshal(msg)
+	client.queue(data)
+}
+
+func (server *CHIP8Server) serve_ws(w http.ResponseWriter, r *http.Request) {
+	ws, err := ws_upgrade(w, r)
+	if err != nil {
+		return
+	}
+	client := &serve_client{ws: ws, send: make(chan []byte, serve_queue)}
+	go func() {
+		for data := range client.send {
+			if ws.write_text(data) != nil {
+				ws.close()
+			}
+		}
+	}()
+
+	server.lock.Lock()
+	server.clients = append(server.clients, client)
+	settings := server.console.settings
+	client.queue_message(&serve_message{Type: "hello", Role: server.role(client),
+		Foreground: format_color(settings.foreground), Background: format_color(settings.background)})
+	client.queue_message(server.frame_message())
+	if server.beep {
+		client.queue_message(&serve_message{Type: "sound", On: &server.beep})
+	}
+	server.lock.Unlock()
+
+	for {
+		data, err := ws.read_text()
+		if err != nil {
+			break
+		}
+		var key serve_key
+		if json.Unmarshal(data, &key) != nil || key.Type != "key" || key.Key > 0xF {
+			continue
+		}
+		server.lock.Lock()
+		if server.role(client) == "player" {
+			if key.Down {
+				server.keys |= 1 << key.Key
+			} else {
+				server.keys &^= 1 << key.Key
+			}
+		}
+		server.lock.Unlock()
+	}
+	server.disconnect(client)
+}
+
+// Role of client. Locked by caller
+func (server *CHIP8Server) role(client *serve_client) string {
+	if len(server.clients) > 0 && server.clients[0] == client {
+		return "player"
+	}
+	return "spectator"
+}
+
+func (server *CHIP8Server) disconnect(client *serve_client) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	for i, c := range server.clients {
+		if c != client {
+			continue
+		}
+		server.clients = append(server.clients[:i], server.clients[i+1:]...)
+		if i == 0 { // Player left, keys are released and next client plays
+			server.keys = 0
+			if len(server.clients) > 0 {
+				server.clients[0].queue_message(&serve_message{Type: "role", Role: "player"})
+			}
+		}
+		break
+	}
+	close(client.send)
+	client.ws.close()
+}
+
+func cmd_serve(cmd *cli_command, args []string) int {
+	flags := cmd.flag_set()
+	f := add_console_flags(flags)
+	listen := flags.String("listen", "localhost:8080", "Address to serve web page and WebSocket on")
+	args, ok := parse_args(flags, args, 1)
+	if !ok {
+		return exit_usage
+	}
+	console := f.console(true)
+	if err := console.init(args[0]); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return exit_error
+	}
+	server := new_server(console)
+	go server.run(nil)
+	fmt.Printf("Serving %s on http://%s/\n", args[0], *listen)
+	if err := http.ListenAndServe(*listen, server.handler()); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return exit_error
+	}
+	return exit_ok
+}
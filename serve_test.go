@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Waits for key 5, then draws its digit at 0,0
+var key_rom = []uint8{
+	0x60, 0x05, // LD V0, 5
+	0xE0, 0x9E, // SKP V0
+	0x12, 0x02, // JP 202
+	0xF0, 0x29, // LD F, V0
+	0xD1, 0x15, // DRW V1, V1, 5
+	0x12, 0x0A, // JP 20A
+}
+
+type ws_test_client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func ws_dial(t *testing.T, url string) *ws_test_client {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: test\r\nOrigin: http://test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "Sec-WebSocket-Accept:") && strings.TrimSpace(line[21:]) != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Errorf("accept key is %s", line)
+		}
+		if line == "\r\n" {
+			break
+		}
+	}
+	return &ws_test_client{conn: conn, reader: reader}
+}
+
+func (client *ws_test_client) read(t *testing.T) map[string]interface{} {
+	client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	opcode, payload, err := ws_read_frame(client.reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opcode != ws_op_text {
+		t.Fatalf("opcode is %X", opcode)
+	}
+	msg := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func (client *ws_test_client) expect(t *testing.T, typ string) map[string]interface{} {
+	msg := client.read(t)
+	if msg["type"] != typ {
+		t.Fatalf("message is %v, want %s", msg, typ)
+	}
+	return msg
+}
+
+func (client *ws_test_client) key(t *testing.T, key int, down bool) {
+	data := fmt.Sprintf(`{"type":"key","key":%d,"down":%v}`, key, down)
+	if err := ws_write_frame(client.conn, ws_op_text, []byte(data), true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Closes connection and waits for server to answer, after it has read all messages
+func (client *ws_test_client) leave(t *testing.T) {
+	ws_write_frame(client.conn, ws_op_close, nil, true)
+	client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := ws_read_frame(client.reader); err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+}
+
+func (server *CHIP8Server) held_keys() uint16 {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.keys
+}
+
+func wait_keys(t *testing.T, server *CHIP8Server, keys uint16) {
+	for i := 0; server.held_keys() != keys; i++ {
+		if i == 500 {
+			t.Fatalf("keys are %04X, want %04X", server.held_keys(), keys)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServe(t *testing.T) {
+	server := new_server(new_rom_console(t, key_rom, ""))
+	http := httptest.NewServer(server.handler())
+	defer http.Close()
+
+	player := ws_dial(t, http.URL)
+	if msg := player.expect(t, "hello"); msg["role"] != "player" || msg["foreground"] != "#11ff11" {
+		t.Errorf("hello of player is %v", msg)
+	}
+	if msg := player.expect(t, "frame"); msg["width"] != 64.0 || msg["height"] != 32.0 {
+		t.Errorf("frame is %v", msg)
+	}
+	spectator := ws_dial(t, http.URL)
+	if msg := spectator.expect(t, "hello"); msg["role"] != "spectator" {
+		t.Errorf("hello of spectator is %v", msg)
+	}
+	spectator.expect(t, "frame")
+	next := ws_dial(t, http.URL)
+	next.expect(t, "hello")
+	next.expect(t, "frame")
+
+	spectator.key(t, 5, true)
+	spectator.leave(t)
+	if keys := server.held_keys(); keys != 0 {
+		t.Errorf("key of spectator is held: %04X", keys)
+	}
+
+	player.key(t, 5, true)
+	wait_keys(t, server, 1<<5)
+	server.frame()
+	msg := player.expect(t, "delta")
+	if pixels := fmt.Sprint(msg["pixels"]); !strings.HasPrefix(pixels, "[[0 1] [1 1] [2 1] [3 1] [64 1]") {
+		t.Errorf("delta of digit 5 is %s", pixels)
+	}
+	next.expect(t, "delta")
+
+	player.leave(t) // Keys are released and next client plays
+	wait_keys(t, server, 0)
+	if msg := next.expect(t, "role"); msg["role"] != "player" {
+		t.Errorf("role of next client is %v", msg)
+	}
+}
+
+func TestWebPage(t *testing.T) {
+	server := new_server(new_rom_console(t, key_rom, ""))
+	recorder := httptest.NewRecorder()
+	server.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != 200 || !strings.Contains(recorder.Body.String(), "WebSocket") {
+		t.Errorf("page is %d %.100s", recorder.Code, recorder.Body.String())
+	}
+	recorder = httptest.NewRecorder()
+	server.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/ws", nil))
+	if recorder.Code != 400 {
+		t.Errorf("plain request of /ws is %d", recorder.Code)
+	}
+	for origin, code := range map[string]int{"http://evil.example": 403, "http://example.com.evil": 403, "%": 403, "http://example.com": 500} {
+		request := httptest.NewRequest("GET", "/ws", nil)
+		request.Header.Set("Upgrade", "websocket")
+		request.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		request.Header.Set("Origin", origin)
+		recorder = httptest.NewRecorder()
+		server.handler().ServeHTTP(recorder, request) // Recorder can't be hijacked: 500 once origin passes
+		if recorder.Code != code {
+			t.Errorf("/ws from %s is %d, %d expected", origin, recorder.Code, code)
+		}
+	}
+}
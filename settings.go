@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Behaviour differences between CHIP-8 interpreters. Names follow chip-8-database
+type CHIP8Quirks struct {
+	Shift                 bool `json:"shift"`                 // 8XY6/8XYE shift VX instead of VY
+	MemoryIncrementByX    bool `json:"memoryIncrementByX"`    // FX55/FX65 increment I by X instead of X+1
+	MemoryLeaveIUnchanged bool `json:"memoryLeaveIUnchanged"` // FX55/FX65 don't change I
+	Wrap                  bool `json:"wrap"`                  // Sprites wrap around screen edges instead of clipping
+	Jump                  bool `json:"jump"`                  // BXNN jumps to XNN+VX instead of NNN+V0
+	VBlank                bool `json:"vblank"`                // DXYN waits for display interrupt
+	Logic                 bool `json:"logic"`                 // 8XY1/8XY2/8XY3 reset VF
+}
+
+// Quirks chipigo always had
+var default_quirks = CHIP8Quirks{
+	Shift:                 true,
+	MemoryLeaveIUnchanged: true,
+	Wrap:                  true,
+}
+
+// Per-game settings. Found in ROM database by hash, or default
+type CHIP8Settings struct {
+	title            string
+	platform         Platform
+	platform_id      string // Platform name in ROM database
+	quirks           CHIP8Quirks
+	cycles_per_frame int              // Instructions per 1/60 second
+	foreground       uint32           // Color of lit pixels, 0xRRGGBB
+	background       uint32           // Color of dark pixels
+	keys             map[string]uint8 // Game buttons (up, down, a...) to CHIP-8 keys
+	font             string           // Built-in font name or font file, empty is default
+	font_base        uint32           // Address of font
+}
+
+func default_settings() *CHIP8Settings {
+	return &CHIP8Settings{
+		platform:         PlatformCHIP8,
+		platform_id:      "originalChip8",
+		quirks:           default_quirks,
+		cycles_per_frame: 15,
+		foreground:       0x11FF11, // Some kind of green
+		background:       0x1A1A1A,
+		keys:             make(map[string]uint8),
+	}
+}
+
+// Parses "#RRGGBB" color
+func parse_color(str string) (uint32, error) {
+	if !strings.HasPrefix(str, "#") || len(str) != 7 {
+		return 0, fmt.Errorf("bad color %q", str)
+	}
+	color, err := strconv.ParseUint(str[1:], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad color %q", str)
+	}
+	return uint32(color), nil
+}
+
+func format_color(color uint32) string {
+	return fmt.Sprintf("#%06x", color&0xFFFFFF)
+}
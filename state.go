@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+)
+
+// Save state: snapshot of machine that program continues from after loading.
+// Settings, keys held and booted CDP1802 interpreter aren't part of it
+type CHIP8State struct {
+	ROM    string // SHA-1 of ROM, states of other ROMs aren't loaded
+	Random uint64 // State of seeded random numbers
+	CPU    CHIP8CPUState
+	Memory []uint8
+	GPU    CHIP8GPUState
+}
+
+type CHIP8CPUState struct {
+	V                []uint8
+	I                uint32
+	PC, SP           uint16
+	DT, ST           uint8
+	VBlanked         bool
+	Cycles           int
+	Mega             bool
+	SpriteW, SpriteH int
+	Collision        uint8
+	RPL              [8]uint8
+}
+
+type CHIP8GPUState struct {
+	W, H       int
+	Pic        [][]uint8
+	Zones      [][]uint8
+	Background int // CHIP-8X background colour index
+	Mega       bool
+	RGB        [][]uint32
+	Palette    [256]uint32
+	Blend      uint8
+	Alpha      uint8
+}
+
+func (console *CHIP8Console) save_state() (*CHIP8State, error) {
+	if console.interpreter != "" {
+		return nil, fmt.Errorf("state of booted interpreter can't be saved")
+	}
+	state := &CHIP8State{ROM: console.rom_hash}
+	if console.rng != nil {
+		state.Random = console.rng.state
+	}
+	console.cpu.save_state(state)
+	console.mem.save_state(state)
+	console.gpu.save_state(state)
+	return state, nil
+}
+
+func (console *CHIP8Console) load_state(state *CHIP8State) error {
+	if console.interpreter != "" {
+		return fmt.Errorf("state of booted interpreter can't be loaded")
+	}
+	if state.ROM != console.rom_hash {
+		return fmt.Errorf("state is saved from other ROM")
+	}
+	if console.rng != nil {
+		console.rng.state = state.Random
+	}
+	console.cpu.load_state(state)
+	console.mem.load_state(state)
+	console.gpu.load_state(state)
+	return nil
+}
+
+func write_state(path string, state *CHIP8State) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = gob.NewEncoder(file).Encode(state); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func read_state(path string) (*CHIP8State, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	state := &CHIP8State{}
+	if err = gob.NewDecoder(file).Decode(state); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return state, nil
+}
+
+func (cpu *CHIP8CPU) save_state(state *CHIP8State) {
+	s := &state.CPU
+	s.V = make([]uint8, len(cpu.v))
+	for i, v := range cpu.v {
+		s.V[i] = uint8(v)
+	}
+	s.I, s.PC, s.SP = cpu.i, cpu.pc, cpu.sp
+	s.DT, s.ST = uint8(cpu.dt), uint8(cpu.st)
+	s.VBlanked, s.Cycles = cpu.vblanked, cpu.cycles
+	s.Mega, s.SpriteW, s.SpriteH, s.Collision = cpu.mega, cpu.sprite_w, cpu.sprite_h, cpu.collision
+	for i, v := range cpu.rpl {
+		s.RPL[i] = uint8(v)
+	}
+}
+
+func (cpu *CHIP8CPU) load_state(state *CHIP8State) {
+	s := &state.CPU
+	cpu.v = make([]Registr, 16)
+	for i := 0; i < len(s.V) && i < 16; i++ {
+		cpu.v[i] = Registr(s.V[i])
+	}
+	cpu.i, cpu.pc, cpu.sp = s.I, s.PC, s.SP
+	cpu.dt, cpu.st = CPUTimer(s.DT), CPUTimer(s.ST)
+	cpu.vblanked, cpu.cycles = s.VBlanked, s.Cycles
+	cpu.mega, cpu.sprite_w, cpu.sprite_h, cpu.collision = s.Mega, s.SpriteW, s.SpriteH, s.Collision
+	for i, v := range s.RPL {
+		cpu.rpl[i] = Registr(v)
+	}
+}
+
+func (mem *CHIP8Memory) save_state(state *CHIP8State) {
+	state.Memory = append([]uint8(nil), mem.data...)
+}
+
+func (mem *CHIP8Memory) load_state(state *CHIP8State) {
+	mem.data = append([]uint8(nil), state.Memory...)
+}
+
+func (gpu *CHIP8GPU) save_state(state *CHIP8State) {
+	s := &state.GPU
+	s.W, s.H = gpu.w, gpu.h
+	s.Pic = copy_columns(gpu.pic)
+	s.Zones = copy_columns(gpu.zones)
+	s.Background = gpu.background_index
+	s.Mega = gpu.mega
+	if gpu.mega {
+		s.RGB = make([][]uint32, len(gpu.rgb))
+		for x := range gpu.rgb {
+			s.RGB[x] = append([]uint32(nil), gpu.rgb[x]...)
+		}
+	}
+	s.Palette, s.Blend, s.Alpha = gpu.palette, gpu.blend, gpu.alpha
+}
+
+func (gpu *CHIP8GPU) load_state(state *CHIP8State) {
+	s := &state.GPU
+	if s.W != gpu.w || s.H != gpu.h {
+		gpu.set_size(s.W, s.H)
+	}
+	gpu.pic = copy_columns(s.Pic)
+	if gpu.zones != nil && s.Zones != nil {
+		gpu.zones = copy_columns(s.Zones)
+		gpu.background_index = s.Background % len(chip8x_background)
+		gpu.set_colors(gpu.color, chip8x_background[gpu.background_index])
+	}
+	gpu.mega = s.Mega
+	gpu.rgb = nil
+	if s.Mega {
+		gpu.rgb = make([][]uint32, len(s.RGB))
+		for x := range s.RGB {
+			gpu.rgb[x] = append([]uint32(nil), s.RGB[x]...)
+		}
+	}
+	gpu.palette, gpu.blend, gpu.alpha = s.Palette, s.Blend, s.Alpha
+}
+
+func copy_columns(columns [][]uint8) [][]uint8 {
+	if columns == nil {
+		return nil
+	}
+	out := make([][]uint8, len(columns))
+	for x := range columns {
+		out[x] = append([]uint8(nil), columns[x]...)
+	}
+	return out
+}
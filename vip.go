@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+// COSMAC VIP hardware around CDP1802: CDP1861 display, hex keypad and beeper.
+// It runs machine code subroutines called by 0NNN, or whole CHIP-8 interpreter
+// image booted from 0x000.
+type COSMACVIP struct {
+	cpu        CDP1802
+	console    *CHIP8Console
+	display_on bool  // CDP1861 is turned on by INP 1 and off by OUT 1
+	key        uint8 // Key latched by OUT 2. EF3 is set while it is pressed
+	ef1        bool  // CDP1861 display status
+	cycles     int   // Cycles of current line left for CPU
+}
+
+// CDP1861 frame is 262 lines of 14 machine cycles. Interrupt is requested
+// 2 lines before 128 display lines; every display line takes 8 cycles of DMA.
+// EF1 is set for 4 lines before the start and before the end of display lines.
+const (
+	cdp1861_lines          = 262
+	cdp1861_line_cycles    = 14
+	cdp1861_dma_cycles     = 8
+	cdp1861_interrupt_line = 78
+	cdp1861_first_line     = 80
+	cdp1861_display_lines  = 128
+	cdp1861_ef1_lines      = 4
+)
+
+// Machine code subroutine doesn't return after that many cycles
+const vip_call_limit = 100 * vip_cycles_per_frame
+
+const vip_interpreter_size = 0x200
+
+func new_vip(console *CHIP8Console) *COSMACVIP {
+	vip := &COSMACVIP{console: console}
+	vip.cpu.bus = vip
+	return vip
+}
+
+// VIP of console, created on first use
+func (console *CHIP8Console) get_vip() *COSMACVIP {
+	if console.vip == nil {
+		console.vip = new_vip(console)
+	}
+	return console.vip
+}
+
+func (vip *COSMACVIP) read(addr uint16) uint8 {
+	return vip.console.mem.read(uint32(addr))
+}
+
+func (vip *COSMACVIP) write(addr uint16, val uint8) {
+	vip.console.mem.write(uint32(addr), val)
+}
+
+func (vip *COSMACVIP) out(port uint8, val uint8) {
+	switch port {
+	case 1:
+		vip.display_on = false
+	case 2:
+		vip.key = val & 0xF
+	}
+}
+
+func (vip *COSMACVIP) in(port uint8) uint8 {
+	if port == 1 {
+		vip.display_on = true
+	}
+	return 0
+}
+
+func (vip *COSMACVIP) ef(n uint8) bool {
+	switch n {
+	case 1:
+		return vip.ef1
+	case 3:
+		return vip.console.input.is_pressed(vip.key)
+	}
+	return false
+}
+
+func (vip *COSMACVIP) set_q(on bool) {
+	vip.console.sound.turn_beep(on)
+}
+
+// Loads interpreter image into 0x000-0x1FF
+func (vip *COSMACVIP) load_interpreter(path string) error {
+	image, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(image) > vip_interpreter_size {
+		return fmt.Errorf("interpreter image is %d bytes, only %d fit below 0x200", len(image), vip_interpreter_size)
+	}
+	for i, val := range image {
+		vip.console.mem.write(uint32(i), val)
+	}
+	return nil
+}
+
+// Resets CPU to run from 0x000. R1.1 is set to the top memory page,
+// like VIP monitor leaves it for the interpreter
+func (vip *COSMACVIP) boot() {
+	vip.cpu.bus = vip
+	vip.cpu.reset()
+	vip.cpu.r[1] = uint16(vip.console.mem.size()/0x100-1) << 8
+	vip.display_on = false
+	vip.cycles = 0
+}
+
+// Runs one frame of CDP1861 with CPU running between DMA cycles
+func (vip *COSMACVIP) run_frame() {
+	gpu := vip.console.gpu
+	w, h := gpu.size()
+	last_line := cdp1861_first_line + cdp1861_display_lines
+	for line := 0; line < cdp1861_lines; line++ {
+		vip.ef1 = vip.display_on &&
+			(line >= cdp1861_first_line-cdp1861_ef1_lines && line < cdp1861_first_line ||
+				line >= last_line-cdp1861_ef1_lines && line < last_line)
+		if vip.display_on && line == cdp1861_interrupt_line {
+			vip.cpu.interrupt()
+		}
+		vip.cycles += cdp1861_line_cycles
+		if vip.display_on && line >= cdp1861_first_line && line < last_line {
+			y := (line - cdp1861_first_line) * h / cdp1861_display_lines
+			for b := 0; b < cdp1861_dma_cycles; b++ {
+				val := vip.cpu.dma_out()
+				for bit := 0; bit < 8 && b*8+bit < w; bit++ {
+					gpu.set_pixel(b*8+bit, y, val>>uint(7-bit)&1)
+				}
+			}
+			vip.cycles -= cdp1861_dma_cycles
+		}
+		for vip.cycles > 0 {
+			vip.cycles -= vip.cpu.step()
+		}
+	}
+}
+
+// VIP interpreter keeps V0-VF at 0xEF0 and display at 0xF00 of 4K memory,
+// its stack grows down from 0xECF. Other memory sizes move them with the top page.
+func (vip *COSMACVIP) vars_addr() uint32 {
+	return vip.console.mem.size() - 0x110
+}
+
+func (vip *COSMACVIP) display_addr() uint32 {
+	return vip.console.mem.size() - 0x100
+}
+
+// Runs machine code subroutine at addr for 0NNN. CHIP-8 state is put where
+// VIP interpreter keeps it, and registers are set the way it calls subroutines:
+// R3 is program counter, R4 returns to interpreter (subroutine ends with D4),
+// R5 is CHIP-8 PC, R6 and R7 point to VX and VY, R8 holds timers, RA is I
+// and RB points to display. Returns machine cycles subroutine took.
+func (vip *COSMACVIP) call(cpu *CHIP8CPU, op OpCode, addr uint16) int {
+	mem := vip.console.mem
+	vars := vip.vars_addr()
+	for i, val := range cpu.v {
+		mem.write(vars+uint32(i), uint8(val))
+	}
+	vip.store_display()
+
+	r := &vip.cpu.r
+	vip.cpu.bus = vip
+	vip.cpu.idle = false
+	vip.cpu.ie = false
+	vip.cpu.x = 2
+	vip.cpu.p = 3
+	r[2] = uint16(vars - 0x21)
+	r[3] = addr
+	r[5] = cpu.pc
+	r[6] = uint16(vars) + uint16(op&0x0F00>>8)
+	r[7] = uint16(vars) + uint16(op&0x00F0>>4)
+	r[8] = uint16(cpu.dt)<<8 | uint16(cpu.st)
+	r[0xA] = uint16(cpu.i)
+	r[0xB] = uint16(vip.display_addr())
+
+	cycles := 0
+	for vip.cpu.p != 4 {
+		if cycles >= vip_call_limit {
+			fmt.Printf("Machine code at %03X doesn't return\n", addr)
+			break
+		}
+		cycles += vip.cpu.step()
+	}
+
+	for i := range cpu.v {
+		cpu.v[i] = Registr(mem.read(vars + uint32(i)))
+	}
+	vip.load_display()
+	cpu.pc = r[5]
+	cpu.i = uint32(r[0xA])
+	cpu.dt = CPUTimer(r[8] >> 8)
+	cpu.st = CPUTimer(r[8] & 0xFF)
+	return cycles
+}
+
+// Copies screen into display memory, 8 pixels per byte
+func (vip *COSMACVIP) store_display() {
+	gpu := vip.console.gpu
+	w, h := gpu.size()
+	addr := vip.display_addr()
+	for y := 0; y < h && y*w/8 < 0x100; y++ {
+		for b := 0; b < w/8; b++ {
+			var val uint8
+			for bit := 0; bit < 8; bit++ {
+				val = val<<1 | gpu.pixel(b*8+bit, y)&1
+			}
+			vip.console.mem.write(addr+uint32(y*w/8+b), val)
+		}
+	}
+}
+
+// Copies display memory onto screen
+func (vip *COSMACVIP) load_display() {
+	gpu := vip.console.gpu
+	w, h := gpu.size()
+	addr := vip.display_addr()
+	for y := 0; y < h && y*w/8 < 0x100; y++ {
+		for b := 0; b < w/8; b++ {
+			val := vip.console.mem.read(addr + uint32(y*w/8+b))
+			for bit := 0; bit < 8; bit++ {
+				gpu.set_pixel(b*8+bit, y, val>>uint(7-bit)&1)
+			}
+		}
+	}
+}
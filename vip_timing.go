@@ -0,0 +1,99 @@
+package main
+
+// COSMAC VIP timing. CDP1802 runs at 1.76064 MHz, one machine cycle takes
+// 8 clocks. CDP1861 display interrupts every frame, 3668 machine cycles; its
+// interrupt routine and display DMA take part of them, the rest is left for
+// interpreter.
+const (
+	vip_clock            = 1760640
+	vip_cycles_per_frame = vip_clock / 8 / frames_per_second
+	vip_interrupt_cycles = 1024 + 98 // DMA of 128 lines of 8 bytes, and interrupt routine with timers update
+	vip_frame_cycles     = vip_cycles_per_frame - vip_interrupt_cycles
+	vip_fetch_cycles     = 40 // Fetch and decode of every instruction
+)
+
+// Returns machine cycles instruction takes on VIP, not counting fetch.
+// Called before instruction is executed
+type InstructionTiming func(cpu *CHIP8CPU, op OpCode, console *CHIP8Console) int
+
+func vip_cycles(cycles int) InstructionTiming {
+	return func(cpu *CHIP8CPU, op OpCode, console *CHIP8Console) int {
+		return cycles
+	}
+}
+
+// Skips take 4 more cycles
+func vip_skip_cycles(cycles int, skip bool) int {
+	if skip {
+		return cycles + 4
+	}
+	return cycles
+}
+
+func vip_x(cpu *CHIP8CPU, op OpCode) Registr {
+	return cpu.v[(op&0x0F00)>>8]
+}
+
+func vip_y(cpu *CHIP8CPU, op OpCode) Registr {
+	return cpu.v[(op&0x00F0)>>4]
+}
+
+func vip_3XNN_cycles(cpu *CHIP8CPU, op OpCode, console *CHIP8Console) int {
+	return vip_skip_cycles(10, vip_x(cpu, op) == Registr(op&0xFF))
+}
+
+func vip_4XNN_cycles(cpu *CHIP8CPU, op OpCode, console *CHIP8Console) int {
+	return vip_skip_cycles(10, vip_x(cpu, op) != Registr(op&0xFF))
+}
+
+func vip_5XY0_cycles(cpu *CHIP8CPU, op OpCode, console *CHIP8Console) int {
+	return vip_skip_cycles(14, vip_x(cpu, op) == vip_y(cpu, op))
+}
+
+func vip_9XY0_cycles(cpu *CHIP8CPU, op OpCode, console *CHIP8Console) int {
+	return vip_skip_cycles(14, vip_x(cpu, op) != vip_y(cpu, op))
+}
+
+func vip_BNNN_cycles(cpu *CHIP8CPU, op OpCode, console *CHIP8Console) int {
+	if uint16(op&0xFF)+uint16(cpu.v[0]) > 0xFF { // Page crossing
+		return 24
+	}
+	return 22
+}
+
+// Aligned row is XORed as one byte. Unaligned row is shifted bit by bit
+// into two bytes, so it costs more the further it is from byte boundary
+func vip_DXYN_cycles(cpu *CHIP8CPU, op OpCode, console *CHIP8Console) int {
+	rows := int(op & 0xF)
+	shift := int(vip_x(cpu, op) & 7)
+	if shift == 0 {
+		return 26 + rows*34
+	}
+	return 26 + rows*(46+8*shift)
+}
+
+func vip_EX9E_cycles(cpu *CHIP8CPU, op OpCode, console *CHIP8Console) int {
+	return vip_skip_cycles(14, console.input.is_pressed(uint8(vip_x(cpu, op))))
+}
+
+func vip_EXA1_cycles(cpu *CHIP8CPU, op OpCode, console *CHIP8Console) int {
+	return vip_skip_cycles(14, !console.input.is_pressed(uint8(vip_x(cpu, op))))
+}
+
+func vip_FX1E_cycles(cpu *CHIP8CPU, op OpCode, console *CHIP8Console) int {
+	if cpu.i&0xFF+uint32(vip_x(cpu, op)) > 0xFF { // Page crossing
+		return 16
+	}
+	return 12
+}
+
+// Digits are found by repeated subtraction
+func vip_FX33_cycles(cpu *CHIP8CPU, op OpCode, console *CHIP8Console) int {
+	val := int(vip_x(cpu, op))
+	return 80 + 16*(val/100+val/10%10+val%10)
+}
+
+// FX55 and FX65 copy X+1 registers
+func vip_FXN5_cycles(cpu *CHIP8CPU, op OpCode, console *CHIP8Console) int {
+	return 14 + 14*int((op&0x0F00)>>8+1)
+}
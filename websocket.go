@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Minimal WebSocket (RFC 6455) for serve: unfragmented text messages,
+// ping and close. Enough for browsers talking to localhost.
+
+const websocket_guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	ws_op_text  = 0x1
+	ws_op_close = 0x8
+	ws_op_ping  = 0x9
+	ws_op_pong  = 0xA
+)
+
+// Messages are small: keys from client, framebuffer deltas to it
+const ws_max_message = 1 << 20
+
+type ws_conn struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	write_lock sync.Mutex // Pongs are written by reader while messages are written by writer
+}
+
+func ws_accept_key(key string) string {
+	sum := sha1.Sum([]byte(key + websocket_guid))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Browsers send Origin of page; a page of another site mustn't connect.
+// Clients that aren't browsers send none
+func ws_same_origin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Upgrades HTTP request to WebSocket connection
+func ws_upgrade(w http.ResponseWriter, r *http.Request) (*ws_conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "WebSocket is expected", http.StatusBadRequest)
+		return nil, fmt.Errorf("not a WebSocket request")
+	}
+	if !ws_same_origin(r) {
+		http.Error(w, "WebSocket of other origin", http.StatusForbidden)
+		return nil, fmt.Errorf("origin %s isn't %s", r.Header.Get("Origin"), r.Host)
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Connection can't be upgraded", http.StatusInternalServerError)
+		return nil, fmt.Errorf("connection can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", ws_accept_key(key))
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &ws_conn{conn: conn, reader: rw.Reader}, nil
+}
+
+// Writes one frame. Client frames must be masked, server ones must not
+func ws_write_frame(w io.Writer, opcode uint8, payload []byte, mask bool) error {
+	header := []byte{0x80 | opcode, 0}
+	n := len(payload)
+	switch {
+	case n < 126:
+		header[1] = uint8(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = append(header, uint8(n>>8), uint8(n))
+	default:
+		header[1] = 127
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		header = append(header, ext[:]...)
+	}
+	if mask {
+		header[1] |= 0x80
+		key := []byte{0x12, 0x34, 0x56, 0x78}
+		header = append(header, key...)
+		masked := make([]byte, n)
+		for i := range payload {
+			masked[i] = payload[i] ^ key[i%4]
+		}
+		payload = masked
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// Reads one frame, unmasking payload
+func ws_read_frame(r io.Reader) (opcode uint8, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if header[0]&0x80 == 0 {
+		return 0, nil, fmt.Errorf("fragmented WebSocket messages aren't supported")
+	}
+	opcode = header[0] & 0xF
+	n := uint64(header[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > ws_max_message {
+		return 0, nil, fmt.Errorf("WebSocket message of %d bytes is too big", n)
+	}
+	var key [4]byte
+	masked := header[1]&0x80 != 0
+	if masked {
+		if _, err = io.ReadFull(r, key[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+func (ws *ws_conn) write(opcode uint8, payload []byte) error {
+	ws.write_lock.Lock()
+	defer ws.write_lock.Unlock()
+	return ws_write_frame(ws.conn, opcode, payload, false)
+}
+
+func (ws *ws_conn) write_text(text []byte) error {
+	return ws.write(ws_op_text, text)
+}
+
+// Reads next text message. Pings are answered, close ends with io.EOF
+func (ws *ws_conn) read_text() ([]byte, error) {
+	for {
+		opcode, payload, err := ws_read_frame(ws.reader)
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case ws_op_text:
+			return payload, nil
+		case ws_op_ping:
+			if err = ws.write(ws_op_pong, payload); err != nil {
+				return nil, err
+			}
+		case ws_op_close:
+			ws.write(ws_op_close, nil)
+			return nil, io.EOF
+		}
+	}
+}
+
+func (ws *ws_conn) close() error {
+	return ws.conn.Close()
+}